@@ -1,6 +1,7 @@
 package algo
 
 import (
+	"math"
 	"time"
 
 	"github.com/revelaction/go-srs/review"
@@ -15,8 +16,78 @@ type Algo interface {
 	//
 	// old is a []bytes serialization of the internal algo parameters.
 	// Implementations should choose the serialization format
+	//
+	// The review happened at r.ReviewedAt if given, otherwise now.
 	Update(old []byte, r review.ReviewItem) ([]byte, error)
 
 	// Due retrieves the Due Items (card ids)  that are overdue for time t (UTC)
+	//
+	// A due item is the Summary of the card. A card that is not overdue
+	// returns a zero DueItem.
 	Due(old []byte, t time.Time) review.DueItem
+
+	// Summary returns the card id, next due time, interval and state of the
+	// serialized algo parameters, independently of the time.
+	Summary(old []byte) (review.DueItem, error)
+}
+
+// Recaller is implemented by the algorithms that predict the probability of
+// recall of a card.
+type Recaller interface {
+
+	// Recall returns the probability of recall of the serialized algo
+	// parameters at time t. Cards without review, in review.StateNew, have
+	// recall 0: there is nothing learned to recall yet, even if the prior of
+	// the algorithm predicts a recall.
+	Recall(old []byte, t time.Time) (float64, error)
+}
+
+// Easer is implemented by the algorithms that keep an ease factor per card,
+// like sm2. Cards with higher ease get longer intervals.
+type Easer interface {
+
+	// Ease returns the ease factor of the serialized algo parameters.
+	Ease(old []byte) (float64, error)
+}
+
+// Difficulter is implemented by the algorithms that keep a difficulty per
+// card, like fsrs. Cards with higher difficulty get shorter intervals.
+type Difficulter interface {
+
+	// Difficulty returns the difficulty of the serialized algo parameters.
+	Difficulty(old []byte) (float64, error)
+}
+
+// Recall returns the probability of recall of the card at time t if a is a
+// Recaller.
+//
+// For other algorithms it is approximated from the Summary: the recall
+// halves each interval after the last review, so cards that are more overdue
+// relative to their interval have lower recall. Cards without interval are
+// given one day. New cards have recall 0, as with a Recaller.
+func Recall(a Algo, old []byte, t time.Time) (float64, error) {
+	if r, ok := a.(Recaller); ok {
+		return r.Recall(old, t)
+	}
+
+	d, err := a.Summary(old)
+	if err != nil {
+		return 0, err
+	}
+
+	if d.State == review.StateNew {
+		return 0, nil
+	}
+
+	interval := d.Interval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+
+	elapsed := t.Sub(d.Due.Add(-interval))
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Pow(2, -float64(elapsed)/float64(interval)), nil
 }
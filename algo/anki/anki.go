@@ -0,0 +1,406 @@
+// Package anki is an implementation of the Anki variant of the supermemo 2
+// algorithm.
+// See https://faqs.ankiweb.net/what-spaced-repetition-algorithm.html
+//
+// Contrary to the sm2 package, new and failed cards go through short
+// (minutes) learning steps before they are scheduled in days:
+//
+//	new ──▶ learning ──▶ review ──▶ relearning ──▶ review
+//	          │  ▲         │  ▲        │  ▲
+//	          └──┘         └──┘        └──┘
+//
+// Learning and relearning cards move one step forward on a Good answer,
+// return to the first step on an Again answer and repeat the step on a Hard
+// answer. After the last step, the card graduates to review.
+//
+// Review cards are scheduled in whole days:
+//
+//	Again: the card lapses. Ease ← Ease - 0.20, and the card goes to relearning
+//	Hard:  I ← I × HardIntervalMultiplier,            Ease ← Ease - 0.15
+//	Good:  I ← (I + late/2) × Ease
+//	Easy:  I ← (I + late) × Ease × EasyBonus,         Ease ← Ease + 0.15
+//
+// All review intervals are multiplied by the IntervalModifier.
+package anki
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/review"
+)
+
+const (
+	DefaultEasiness = 2.5
+	MinEasiness     = 1.3
+
+	EasinessAgain = -0.20
+	EasinessHard  = -0.15
+	EasinessEasy  = 0.15
+
+	DefaultGraduatingInterval     = 1
+	DefaultEasyInterval           = 4
+	DefaultEasyBonus              = 1.3
+	DefaultHardIntervalMultiplier = 1.2
+	DefaultIntervalModifier       = 1.0
+	DefaultMaximumInterval        = 36500
+)
+
+var (
+	DefaultLearningSteps   = []time.Duration{time.Minute, 10 * time.Minute}
+	DefaultRelearningSteps = []time.Duration{10 * time.Minute}
+)
+
+// Grade is the Anki own review rating.
+type Grade int
+
+const (
+	Again Grade = iota + 1
+	Hard
+	Good
+	Easy
+)
+
+// State is the scheduling phase of a card
+type State int
+
+const (
+	StateNew State = iota
+	StateLearning
+	StateReview
+	StateRelearning
+)
+
+type Item struct {
+	CardId int
+
+	State State
+
+	// Step is the current (re)learning step index
+	Step int
+
+	Easiness float64
+
+	// Interval in days of a review card
+	Interval int
+
+	Lapses int
+
+	// Unix timestamp
+	Due int64
+}
+
+// Anki contains the scheduling options. The zero value of the steps means no
+// learning (or relearning) steps.
+type Anki struct {
+	clock clock.Clock
+
+	LearningSteps   []time.Duration
+	RelearningSteps []time.Duration
+
+	// GraduatingInterval is the interval in days after the last learning
+	// step.
+	GraduatingInterval int
+
+	// EasyInterval is the interval in days of a learning card answered Easy
+	EasyInterval int
+
+	EasyBonus              float64
+	HardIntervalMultiplier float64
+	IntervalModifier       float64
+
+	// MaximumInterval in days
+	MaximumInterval int
+}
+
+// New returns a Anki with a clock fixed at now. Long-running processes should
+// use NewWithClock.
+func New(now time.Time) *Anki {
+	return NewWithClock(clock.NewFake(now))
+}
+
+// NewWithClock returns a Anki that asks c for the current time at each
+// Update.
+func NewWithClock(c clock.Clock) *Anki {
+	return &Anki{
+		clock:                  c,
+		LearningSteps:          append([]time.Duration(nil), DefaultLearningSteps...),
+		RelearningSteps:        append([]time.Duration(nil), DefaultRelearningSteps...),
+		GraduatingInterval:     DefaultGraduatingInterval,
+		EasyInterval:           DefaultEasyInterval,
+		EasyBonus:              DefaultEasyBonus,
+		HardIntervalMultiplier: DefaultHardIntervalMultiplier,
+		IntervalModifier:       DefaultIntervalModifier,
+		MaximumInterval:        DefaultMaximumInterval,
+	}
+}
+
+// Update takes a serialized representation of a Item, deserializes it and
+// calculates a modified version according to the review
+func (a *Anki) Update(oldItem []byte, r review.ReviewItem) ([]byte, error) {
+
+	var newItem Item
+	if nil != oldItem {
+		decodedItem, err := decode(oldItem)
+		if err != nil {
+			return nil, err
+		}
+
+		newItem = a.update(decodedItem, r, r.Time(a.clock.Now()))
+	} else {
+		newItem = a.create(r, r.Time(a.clock.Now()))
+	}
+
+	return encode(newItem)
+}
+
+// Due determines if the serialized Item item is overdue.
+func (a *Anki) Due(item []byte, t time.Time) (d review.DueItem) {
+	dec, err := decode(item)
+	if err != nil {
+		return d
+	}
+
+	if dec.Due < t.Unix() {
+		return a.summary(dec)
+	}
+
+	return d
+}
+
+// Summary returns the schedule of the serialized Item item.
+func (a *Anki) Summary(item []byte) (review.DueItem, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return review.DueItem{}, err
+	}
+
+	return a.summary(dec), nil
+}
+
+// Ease returns the Easiness of the serialized Item item.
+func (a *Anki) Ease(item []byte) (float64, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return 0, err
+	}
+
+	return dec.Easiness, nil
+}
+
+// grade translates the review quality to the Anki grade. All incorrect
+// responses are an Again.
+func grade(q review.Quality) Grade {
+	switch {
+	case q <= review.IncorrectEasy:
+		return Again
+	case q == review.CorrectHard:
+		return Hard
+	case q == review.CorrectEffort:
+		return Good
+	default:
+		return Easy
+	}
+}
+
+// create returns a new Item. New cards are due immediately. If the card has
+// already a review quality, it is applied.
+func (a *Anki) create(r review.ReviewItem, now time.Time) Item {
+
+	n := Item{}
+	n.CardId = r.CardId
+	n.State = StateNew
+	n.Easiness = DefaultEasiness
+	n.Due = now.Unix()
+
+	return a.update(n, r, now)
+}
+
+// update calculates the next state of the Item
+func (a *Anki) update(old Item, r review.ReviewItem, now time.Time) Item {
+
+	if r.Quality == review.NoReview {
+		return old
+	}
+
+	n := old
+	n.CardId = r.CardId
+	g := grade(r.Quality)
+
+	switch old.State {
+	case StateNew, StateLearning:
+		return a.learn(n, g, a.LearningSteps, a.GraduatingInterval, now)
+	case StateRelearning:
+		return a.learn(n, g, a.RelearningSteps, old.Interval, now)
+	default:
+		return a.review(n, g, now)
+	}
+}
+
+// learn moves a (re)learning card through the steps. After the last step the
+// card graduates with the graduate interval.
+func (a *Anki) learn(n Item, g Grade, steps []time.Duration, graduate int, now time.Time) Item {
+
+	if n.State == StateNew {
+		n.State = StateLearning
+		n.Step = 0
+	}
+
+	switch g {
+	case Again:
+		n.Step = 0
+	case Hard:
+		// repeat the step. Hard on the first step is the average of the
+		// first two steps
+		if n.Step == 0 && len(steps) > 1 {
+			return a.schedule(n, (steps[0]+steps[1])/2, now)
+		}
+	case Good:
+		n.Step++
+	case Easy:
+		if n.State == StateLearning {
+			graduate = a.EasyInterval
+		}
+
+		return a.graduate(n, graduate, now)
+	}
+
+	if n.Step >= len(steps) {
+		return a.graduate(n, graduate, now)
+	}
+
+	return a.schedule(n, steps[n.Step], now)
+}
+
+// review schedules a review card in days
+func (a *Anki) review(n Item, g Grade, now time.Time) Item {
+
+	// days late, only positive
+	late := math.Max(0, float64(now.Unix()-n.Due)/(24*60*60))
+	cur := float64(n.Interval)
+
+	hard := a.constrain(cur*a.HardIntervalMultiplier, n.Interval)
+	good := a.constrain((cur+late/2)*n.Easiness, hard)
+	easy := a.constrain((cur+late)*n.Easiness*a.EasyBonus, good)
+
+	switch g {
+	case Again:
+		n.Lapses++
+		n.Easiness = easiness(n.Easiness + EasinessAgain)
+		n.Interval = 1
+		if len(a.RelearningSteps) == 0 {
+			return a.graduate(n, n.Interval, now)
+		}
+
+		n.State = StateRelearning
+		n.Step = 0
+		return a.schedule(n, a.RelearningSteps[0], now)
+	case Hard:
+		n.Easiness = easiness(n.Easiness + EasinessHard)
+		n.Interval = hard
+	case Good:
+		n.Interval = good
+	case Easy:
+		n.Easiness = easiness(n.Easiness + EasinessEasy)
+		n.Interval = easy
+	}
+
+	n.Due = now.AddDate(0, 0, n.Interval).Unix()
+	return n
+}
+
+// constrain applies the interval modifier and makes the interval greater
+// than prev and not greater than the maximum interval.
+func (a *Anki) constrain(days float64, prev int) int {
+	i := int(math.Round(days * a.IntervalModifier))
+	if i <= prev {
+		i = prev + 1
+	}
+
+	if i > a.MaximumInterval {
+		return a.MaximumInterval
+	}
+
+	return i
+}
+
+func (a *Anki) graduate(n Item, days int, now time.Time) Item {
+	if days < 1 {
+		days = 1
+	}
+
+	n.State = StateReview
+	n.Step = 0
+	n.Interval = days
+	n.Due = now.AddDate(0, 0, days).Unix()
+	return n
+}
+
+func (a *Anki) schedule(n Item, d time.Duration, now time.Time) Item {
+	n.Due = now.Add(d).Unix()
+	return n
+}
+
+func easiness(e float64) float64 {
+	if e < MinEasiness {
+		return MinEasiness
+	}
+
+	return e
+}
+
+// summary translates the Item to a review.DueItem. The interval of
+// (re)learning cards is their current step.
+func (a *Anki) summary(i Item) review.DueItem {
+	d := review.DueItem{
+		CardId: i.CardId,
+		Due:    time.Unix(i.Due, 0).UTC(),
+	}
+
+	switch i.State {
+	case StateNew:
+		d.State = review.StateNew
+	case StateLearning:
+		d.State = review.StateLearning
+		d.Interval = step(a.LearningSteps, i.Step)
+	case StateRelearning:
+		d.State = review.StateRelearning
+		d.Interval = step(a.RelearningSteps, i.Step)
+	default:
+		d.State = review.StateReview
+		d.Interval = time.Duration(i.Interval) * 24 * time.Hour
+	}
+
+	return d
+}
+
+func step(steps []time.Duration, idx int) time.Duration {
+	if idx < 0 || idx >= len(steps) {
+		return 0
+	}
+
+	return steps[idx]
+}
+
+// deserialize
+func decode(encodedItem []byte) (Item, error) {
+	res := Item{}
+	err := json.Unmarshal(encodedItem, &res)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// serialize
+func encode(item Item) ([]byte, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,301 @@
+package anki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+func ExampleAnki_Update() {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	a := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	item, _ := a.Update(nil, r)
+
+	r = review.ReviewItem{CardId: 1, Quality: review.CorrectEffort}
+	item, _ = a.Update(item, r)
+
+	fmt.Printf("Item is %s\n", item)
+
+	//Output:
+	//Item is {"CardId":1,"State":1,"Step":1,"Easiness":2.5,"Interval":0,"Lapses":0,"Due":1604193000}
+}
+
+func Example_learnReviewLapse() {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	a := New(now)
+
+	reviews := []review.ReviewItem{
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.IncorrectFamiliar},
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.CorrectHard},
+		{CardId: 1, Quality: review.IncorrectBlackout},
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.CorrectEasy},
+	}
+
+	next := a.create(review.ReviewItem{CardId: 1, Quality: review.NoReview}, now)
+	nextTime := time.Unix(next.Due, 0).UTC()
+
+	for _, r := range reviews {
+		next = a.update(next, r, nextTime)
+		nextTime = time.Unix(next.Due, 0).UTC()
+		fmt.Printf("State:%d, Step:%d, Easiness:%.2f, Interval:%d, Due:%s\n", next.State, next.Step, next.Easiness, next.Interval, nextTime.Format("2006-01-02 15:04"))
+	}
+
+	//Output:
+	//State:1, Step:1, Easiness:2.50, Interval:0, Due:2020-11-01 00:10
+	//State:1, Step:0, Easiness:2.50, Interval:0, Due:2020-11-01 00:11
+	//State:1, Step:1, Easiness:2.50, Interval:0, Due:2020-11-01 00:21
+	//State:2, Step:0, Easiness:2.50, Interval:1, Due:2020-11-02 00:21
+	//State:2, Step:0, Easiness:2.50, Interval:3, Due:2020-11-05 00:21
+	//State:2, Step:0, Easiness:2.50, Interval:8, Due:2020-11-13 00:21
+	//State:2, Step:0, Easiness:2.35, Interval:10, Due:2020-11-23 00:21
+	//State:3, Step:0, Easiness:2.15, Interval:1, Due:2020-11-23 00:31
+	//State:2, Step:0, Easiness:2.15, Interval:1, Due:2020-11-24 00:31
+	//State:2, Step:0, Easiness:2.30, Interval:4, Due:2020-11-28 00:31
+}
+
+func TestGrade(t *testing.T) {
+	tests := []struct {
+		quality review.Quality
+		want    Grade
+	}{
+		{quality: review.IncorrectBlackout, want: Again},
+		{quality: review.IncorrectFamiliar, want: Again},
+		{quality: review.IncorrectEasy, want: Again},
+		{quality: review.CorrectHard, want: Hard},
+		{quality: review.CorrectEffort, want: Good},
+		{quality: review.CorrectEasy, want: Easy},
+	}
+
+	for _, tc := range tests {
+		g := grade(tc.quality)
+		if g != tc.want {
+			t.Errorf("\ngot %#v\nwant %#v", g, tc.want)
+		}
+	}
+}
+
+func TestNewCardIsDueNow(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	a := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+
+	want := Item{
+		CardId:   1,
+		State:    StateNew,
+		Easiness: DefaultEasiness,
+		Due:      now.Unix(),
+	}
+
+	have := a.create(r, now)
+	if have != want {
+		t.Errorf("\ngot %#v\nwant %#v", have, want)
+	}
+}
+
+func TestLearningHardFirstStep(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	a := New(now)
+
+	n := a.create(review.ReviewItem{CardId: 1, Quality: review.CorrectHard}, now)
+
+	// average of 1m and 10m
+	want := now.Add(330 * time.Second).Unix()
+	if n.Due != want {
+		t.Errorf("\ngot due %d\nwant due %d", n.Due, want)
+	}
+
+	if n.State != StateLearning || n.Step != 0 {
+		t.Errorf("\ngot state %d step %d\nwant state %d step 0", n.State, n.Step, StateLearning)
+	}
+}
+
+func TestLearningEasyGraduates(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	a := New(now)
+	a.EasyInterval = 7
+
+	n := a.create(review.ReviewItem{CardId: 1, Quality: review.CorrectEasy}, now)
+
+	want := Item{
+		CardId:   1,
+		State:    StateReview,
+		Easiness: DefaultEasiness,
+		Interval: 7,
+		Due:      now.AddDate(0, 0, 7).Unix(),
+	}
+
+	if n != want {
+		t.Errorf("\ngot %#v\nwant %#v", n, want)
+	}
+}
+
+func TestNoLearningSteps(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	a := New(now)
+	a.LearningSteps = nil
+	a.GraduatingInterval = 3
+
+	n := a.create(review.ReviewItem{CardId: 1, Quality: review.CorrectEffort}, now)
+
+	if n.State != StateReview || n.Interval != 3 {
+		t.Errorf("\ngot state %d interval %d\nwant state %d interval 3", n.State, n.Interval, StateReview)
+	}
+}
+
+func TestReviewIntervals(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	old := Item{CardId: 1, State: StateReview, Easiness: 2.5, Interval: 10, Due: now.Unix()}
+
+	tests := []struct {
+		quality  review.Quality
+		modifier float64
+		want     int
+	}{
+		{quality: review.CorrectHard, modifier: 1, want: 12},
+		{quality: review.CorrectEffort, modifier: 1, want: 25},
+		{quality: review.CorrectEasy, modifier: 1, want: 33},
+		{quality: review.CorrectEffort, modifier: 0.8, want: 20},
+	}
+
+	for _, tc := range tests {
+		a := New(now)
+		a.IntervalModifier = tc.modifier
+
+		n := a.update(old, review.ReviewItem{CardId: 1, Quality: tc.quality}, now)
+		if n.Interval != tc.want {
+			t.Errorf("\ngot interval %d\nwant interval %d", n.Interval, tc.want)
+		}
+	}
+}
+
+func TestReviewLapse(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	a := New(now)
+
+	old := Item{CardId: 1, State: StateReview, Easiness: 2.5, Interval: 10, Due: now.Unix()}
+	n := a.update(old, review.ReviewItem{CardId: 1, Quality: review.IncorrectBlackout}, now)
+
+	want := Item{
+		CardId:   1,
+		State:    StateRelearning,
+		Easiness: 2.3,
+		Interval: 1,
+		Lapses:   1,
+		Due:      now.Add(10 * time.Minute).Unix(),
+	}
+
+	if n != want {
+		t.Errorf("\ngot %#v\nwant %#v", n, want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	a := New(now)
+
+	tests := []struct {
+		item Item
+		want review.DueItem
+	}{
+		{
+			item: Item{CardId: 1, State: StateNew, Due: now.Unix()},
+			want: review.DueItem{CardId: 1, Due: now, State: review.StateNew},
+		},
+		{
+			item: Item{CardId: 1, State: StateLearning, Step: 1, Due: now.Unix()},
+			want: review.DueItem{CardId: 1, Due: now, Interval: 10 * time.Minute, State: review.StateLearning},
+		},
+		{
+			item: Item{CardId: 1, State: StateReview, Interval: 3, Due: now.Unix()},
+			want: review.DueItem{CardId: 1, Due: now, Interval: 3 * 24 * time.Hour, State: review.StateReview},
+		},
+		{
+			item: Item{CardId: 1, State: StateRelearning, Interval: 1, Due: now.Unix()},
+			want: review.DueItem{CardId: 1, Due: now, Interval: 10 * time.Minute, State: review.StateRelearning},
+		},
+	}
+
+	for _, tc := range tests {
+		b, _ := encode(tc.item)
+		d, err := a.Summary(b)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if d != tc.want {
+			t.Errorf("\ngot %#v\nwant %#v", d, tc.want)
+		}
+	}
+}
+
+func TestUpdateDecodeError(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	a := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	b := []byte{'g', 'o', 'l', 'a', 'n', 'g'}
+	_, err := a.Update(b, r)
+
+	var e *json.SyntaxError
+	if !errors.As(err, &e) {
+		t.Errorf("\ngot error %s\nwant SyntaxError", err)
+	}
+}
+
+func TestEase(t *testing.T) {
+	a := New(time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC))
+
+	item, _ := a.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+
+	ease, err := a.Ease(item)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if ease != DefaultEasiness {
+		t.Errorf("\ngot %#v\nwant %#v", ease, DefaultEasiness)
+	}
+}
+
+func TestStepsNotShared(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	a := New(now)
+	a.LearningSteps[0] = time.Hour
+	a.RelearningSteps[0] = time.Hour
+
+	if DefaultLearningSteps[0] != time.Minute || New(now).LearningSteps[0] != time.Minute {
+		t.Errorf("\ngot %v\nwant %v", DefaultLearningSteps, []time.Duration{time.Minute, 10 * time.Minute})
+	}
+
+	if DefaultRelearningSteps[0] != 10*time.Minute || New(now).RelearningSteps[0] != 10*time.Minute {
+		t.Errorf("\ngot %v\nwant %v", DefaultRelearningSteps, []time.Duration{10 * time.Minute})
+	}
+}
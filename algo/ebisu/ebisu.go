@@ -0,0 +1,515 @@
+// Package ebisu is an implementation of the Ebisu (v2) Bayesian algorithm.
+// See https://fasiha.github.io/ebisu/
+//
+// Ebisu models the recall probability p of a card t hours after its last
+// review as a Beta distribution:
+//
+//	p_t ~ Beta(α, β)
+//
+// The recall probability after any other elapsed time τ follows from the
+// exponential forgetting curve, p_τ = p_t ^ (τ/t), and its expected value is:
+//
+//	E[p_τ] = B(α + τ/t, β) / B(α, β)
+//
+// Each review updates the posterior of the model (α, β, t) with the result of
+// the quiz, that can be binary, binomial (k successes of n) or soft-binary (a
+// noisy result between 0 and 1). The posterior is rebalanced so that t is
+// (approximately) the half-life of the card.
+//
+// The card is due when the expected recall probability drops below the
+// Target recall.
+package ebisu
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/review"
+)
+
+var ErrInvalidModel = errors.New("invalid ebisu model")
+
+const (
+	DefaultAlpha = 3.0
+	DefaultBeta  = 3.0
+
+	// DefaultHalfLife in hours
+	DefaultHalfLife = 24.0
+
+	DefaultTarget = 0.5
+
+	// BinomialTotal is the number of trials of a Binomial result
+	BinomialTotal = 5
+
+	// minElapsed is the minimum elapsed time (hours) between reviews, to
+	// avoid degenerated models.
+	minElapsed = 1.0 / 60
+)
+
+// Mode is the way the review.Quality is translated to a quiz result.
+type Mode int
+
+const (
+	// Binary results: correct (q ≥ 4) is a success, otherwise a failure.
+	Binary Mode = iota
+
+	// Binomial results: a review.Quality q is q-1 successes of
+	// BinomialTotal trials.
+	Binomial
+
+	// Soft results: the review.Quality is a noisy-binary result between 0
+	// and 1. See SoftResults.
+	Soft
+)
+
+// SoftResults are the soft-binary results for each review.Quality
+var SoftResults = map[review.Quality]float64{
+	review.IncorrectBlackout: 0.0,
+	review.IncorrectFamiliar: 0.1,
+	review.IncorrectEasy:     0.2,
+	review.CorrectHard:       0.8,
+	review.CorrectEffort:     0.9,
+	review.CorrectEasy:       1.0,
+}
+
+type Item struct {
+	CardId int
+
+	Alpha float64
+	Beta  float64
+
+	// T in hours
+	T float64
+
+	// Reviews is the number of reviews with quality
+	Reviews int
+
+	// Unix timestamp
+	LastReview int64
+
+	// Unix timestamp
+	Due int64
+}
+
+type Ebisu struct {
+	clock clock.Clock
+
+	// Prior model of new cards
+	Alpha    float64
+	Beta     float64
+	HalfLife float64
+
+	// Target is the expected recall probability at which the card becomes
+	// due.
+	Target float64
+
+	Mode Mode
+}
+
+// New returns a Ebisu with a clock fixed at now. Long-running processes should
+// use NewWithClock.
+func New(now time.Time) *Ebisu {
+	return NewWithClock(clock.NewFake(now))
+}
+
+// NewWithClock returns a Ebisu that asks c for the current time at each
+// Update.
+func NewWithClock(c clock.Clock) *Ebisu {
+	return &Ebisu{
+		clock:    c,
+		Alpha:    DefaultAlpha,
+		Beta:     DefaultBeta,
+		HalfLife: DefaultHalfLife,
+		Target:   DefaultTarget,
+		Mode:     Binary,
+	}
+}
+
+// Update takes a serialized representation of a Item, deserializes it and
+// calculates the posterior model according to the review
+func (e *Ebisu) Update(oldItem []byte, r review.ReviewItem) ([]byte, error) {
+
+	var newItem Item
+	if nil != oldItem {
+		decodedItem, err := decode(oldItem)
+		if err != nil {
+			return nil, err
+		}
+
+		newItem, err = e.update(decodedItem, r, r.Time(e.clock.Now()))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		newItem, err = e.create(r, r.Time(e.clock.Now()))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return encode(newItem)
+}
+
+// Due determines if the serialized Item item is overdue.
+func (e *Ebisu) Due(item []byte, t time.Time) (d review.DueItem) {
+	dec, err := decode(item)
+	if err != nil {
+		return d
+	}
+
+	if dec.Due < t.Unix() {
+		return summary(dec)
+	}
+
+	return d
+}
+
+// Summary returns the schedule of the serialized Item item.
+func (e *Ebisu) Summary(item []byte) (review.DueItem, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return review.DueItem{}, err
+	}
+
+	return summary(dec), nil
+}
+
+// Recall returns the expected recall probability of the serialized Item at
+// time t. Cards with lower recall are more likely to be forgotten. Cards
+// without review have no recall, the prediction of the prior model is not
+// used.
+func (e *Ebisu) Recall(item []byte, t time.Time) (float64, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return 0, err
+	}
+
+	if dec.Reviews == 0 {
+		return 0, nil
+	}
+
+	elapsed := math.Max(0, t.Sub(time.Unix(dec.LastReview, 0)).Hours())
+	return PredictRecall(dec.Alpha, dec.Beta, dec.T, elapsed), nil
+}
+
+// create returns an Item with the prior model, learned now. If the card has
+// already a review quality, it updates the prior like any other review.
+func (e *Ebisu) create(r review.ReviewItem, now time.Time) (Item, error) {
+
+	n := Item{}
+	n.CardId = r.CardId
+	n.Alpha = e.Alpha
+	n.Beta = e.Beta
+	n.T = e.HalfLife
+	n.LastReview = now.Unix()
+	n.Due = now.Add(e.interval(n)).Unix()
+
+	return e.update(n, r, now)
+}
+
+// update calculates the posterior model after the review
+func (e *Ebisu) update(old Item, r review.ReviewItem, now time.Time) (Item, error) {
+
+	if r.Quality == review.NoReview {
+		return old, nil
+	}
+
+	n := old
+	n.CardId = r.CardId
+
+	elapsed := math.Max(minElapsed, now.Sub(time.Unix(old.LastReview, 0)).Hours())
+
+	var alpha, beta, t float64
+	var err error
+	switch e.Mode {
+	case Binomial:
+		alpha, beta, t, err = UpdateRecall(old.Alpha, old.Beta, old.T, int(r.Quality-1), BinomialTotal, elapsed)
+	case Soft:
+		alpha, beta, t, err = UpdateRecallSoft(old.Alpha, old.Beta, old.T, SoftResults[r.Quality], elapsed)
+	default:
+		successes := 0
+		if r.Quality >= review.CorrectHard {
+			successes = 1
+		}
+
+		alpha, beta, t, err = UpdateRecall(old.Alpha, old.Beta, old.T, successes, 1, elapsed)
+	}
+
+	if err != nil {
+		return old, err
+	}
+
+	n.Alpha, n.Beta, n.T = alpha, beta, t
+	n.Reviews++
+	n.LastReview = now.Unix()
+	n.Due = now.Add(e.interval(n)).Unix()
+
+	return n, nil
+}
+
+// interval is the time until the expected recall drops to the target.
+func (e *Ebisu) interval(n Item) time.Duration {
+	hours := PercentileDecay(n.Alpha, n.Beta, n.T, e.Target)
+	return time.Duration(math.Round(hours*60*60)) * time.Second
+}
+
+// PredictRecall returns the expected recall probability of the model (alpha,
+// beta, t) after elapsed time. elapsed and t have the same units.
+func PredictRecall(alpha, beta, t, elapsed float64) float64 {
+	delta := elapsed / t
+	return math.Exp(betaln(alpha+delta, beta) - betaln(alpha, beta))
+}
+
+// PercentileDecay returns the elapsed time at which the expected recall
+// probability of the model drops to percentile.
+func PercentileDecay(alpha, beta, t, percentile float64) float64 {
+	logBab := betaln(alpha, beta)
+	logPercentile := math.Log(percentile)
+
+	// decreasing in delta
+	f := func(delta float64) float64 {
+		return betaln(alpha+delta, beta) - logBab - logPercentile
+	}
+
+	low, high := findBracket(f, 1)
+	return bisect(f, low, high) * t
+}
+
+// UpdateRecall returns the rebalanced posterior model after a binomial quiz
+// with successes of total trials, elapsed time after the last review.
+func UpdateRecall(alpha, beta, t float64, successes, total int, elapsed float64) (float64, float64, float64, error) {
+
+	if successes < 0 || successes > total || total < 1 {
+		return alpha, beta, t, ErrInvalidModel
+	}
+
+	if total == 1 {
+		return UpdateRecallSoft(alpha, beta, t, float64(successes), elapsed)
+	}
+
+	dt := elapsed / t
+	failures := total - successes
+
+	// log of the unnormalized m-th moment of the posterior, at et =
+	// tback / elapsed
+	logMoment := func(m int, et float64) float64 {
+		terms := make([]float64, failures+1)
+		signs := make([]float64, failures+1)
+		for i := 0; i <= failures; i++ {
+			terms[i] = binomln(failures, i) + betaln(alpha+dt*float64(successes+i)+float64(m)*dt*et, beta)
+			signs[i] = math.Pow(-1, float64(i))
+		}
+
+		v, _ := logSumExp(terms, signs)
+		return v
+	}
+
+	logDenominator := logMoment(0, 0)
+
+	return rebalance(func(m int, et float64) float64 {
+		return math.Exp(logMoment(m, et) - logDenominator)
+	}, dt, elapsed)
+}
+
+// UpdateRecallSoft returns the rebalanced posterior model after a
+// soft-binary quiz with result in [0, 1], elapsed time after the last review.
+// The results 0 and 1 are a binary failure and success.
+func UpdateRecallSoft(alpha, beta, t, result, elapsed float64) (float64, float64, float64, error) {
+
+	if result < 0 || result > 1 {
+		return alpha, beta, t, ErrInvalidModel
+	}
+
+	success := result > 0.5
+	q1 := result
+	if !success {
+		q1 = 1 - result
+	}
+
+	q0 := 1 - q1
+
+	dt := elapsed / t
+
+	var c, d float64
+	if success {
+		c, d = q1-q0, q0
+	} else {
+		c, d = q0-q1, 1-q0
+	}
+
+	// the moments are c·B(α + dt + N·dt·et, β) + d·B(α + N·dt·et, β),
+	// normalized, in log space with signs.
+	logTerms := func(m int, et float64) (float64, float64) {
+		terms := []float64{logAbs(c) + betaln(alpha+dt+float64(m)*dt*et, beta)}
+		signs := []float64{sign(c)}
+		if d != 0 {
+			terms = append(terms, logAbs(d)+betaln(alpha+float64(m)*dt*et, beta))
+			signs = append(signs, sign(d))
+		}
+
+		return logSumExp(terms, signs)
+	}
+
+	logDen, denSign := logTerms(0, 0)
+
+	return rebalance(func(m int, et float64) float64 {
+		v, s := logTerms(m, et)
+		return s * denSign * math.Exp(v-logDen)
+	}, dt, elapsed)
+}
+
+// rebalance finds the time tback at which the posterior mean is 0.5, and
+// returns the Beta distribution matching the posterior moments at tback.
+func rebalance(moment func(m int, et float64) float64, dt, elapsed float64) (float64, float64, float64, error) {
+
+	f := func(et float64) float64 {
+		return moment(1, et) - 0.5
+	}
+
+	low, high := findBracket(f, 1/dt)
+	et := bisect(f, low, high)
+	tback := et * elapsed
+
+	mean := moment(1, et)
+	secondMoment := moment(2, et)
+	variance := secondMoment - mean*mean
+
+	alpha, beta := meanVarToBeta(mean, variance)
+	if !(alpha > 0) || !(beta > 0) || !(tback > 0) {
+		return alpha, beta, tback, ErrInvalidModel
+	}
+
+	return alpha, beta, tback, nil
+}
+
+func meanVarToBeta(mean, variance float64) (float64, float64) {
+	tmp := mean*(1-mean)/variance - 1
+	return mean * tmp, (1 - mean) * tmp
+}
+
+// findBracket returns an interval [low, high] in which the decreasing
+// function f changes sign, growing from init.
+func findBracket(f func(float64) float64, init float64) (float64, float64) {
+	const grow = 2.0
+
+	low := init / grow
+	high := init * grow
+	fLow := f(low)
+	fHigh := f(high)
+
+	for i := 0; i < 1000 && fLow > 0 && fHigh > 0; i++ {
+		low, fLow = high, fHigh
+		high *= grow
+		fHigh = f(high)
+	}
+
+	for i := 0; i < 1000 && fLow < 0 && fHigh < 0; i++ {
+		high, fHigh = low, fLow
+		low /= grow
+		fLow = f(low)
+	}
+
+	return low, high
+}
+
+// bisect finds the root of the decreasing function f in [low, high]
+func bisect(f func(float64) float64, low, high float64) float64 {
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		if f(mid) > 0 {
+			low = mid
+		} else {
+			high = mid
+		}
+
+		if (high-low)/high < 1e-12 {
+			break
+		}
+	}
+
+	return (low + high) / 2
+}
+
+// betaln is the log of the beta function
+func betaln(a, b float64) float64 {
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	return la + lb - lab
+}
+
+// binomln is the log of the binomial coefficient n over k
+func binomln(n, k int) float64 {
+	return -betaln(float64(1+n-k), float64(1+k)) - math.Log(float64(n+1))
+}
+
+// logSumExp returns log|Σ sign_i·exp(a_i)| and the sign of the sum.
+func logSumExp(a, signs []float64) (float64, float64) {
+	max := math.Inf(-1)
+	for _, v := range a {
+		if v > max {
+			max = v
+		}
+	}
+
+	var sum float64
+	for i, v := range a {
+		sum += signs[i] * math.Exp(v-max)
+	}
+
+	return max + math.Log(math.Abs(sum)), sign(sum)
+}
+
+func logAbs(v float64) float64 {
+	return math.Log(math.Abs(v))
+}
+
+func sign(v float64) float64 {
+	if v < 0 {
+		return -1
+	}
+
+	return 1
+}
+
+// summary translates the Item to a review.DueItem. Cards without review are
+// new.
+func summary(i Item) review.DueItem {
+	d := review.DueItem{
+		CardId:   i.CardId,
+		Due:      time.Unix(i.Due, 0).UTC(),
+		Interval: time.Duration(i.Due-i.LastReview) * time.Second,
+		State:    review.StateReview,
+	}
+
+	if i.Reviews == 0 {
+		d.State = review.StateNew
+	}
+
+	return d
+}
+
+// deserialize
+func decode(encodedItem []byte) (Item, error) {
+	res := Item{}
+	err := json.Unmarshal(encodedItem, &res)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// serialize
+func encode(item Item) ([]byte, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
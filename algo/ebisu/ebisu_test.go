@@ -0,0 +1,318 @@
+package ebisu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+func ExampleEbisu_Update() {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	e := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	item, _ := e.Update(nil, r)
+
+	fmt.Printf("Item is %s\n", item)
+
+	//Output:
+	//Item is {"CardId":1,"Alpha":3,"Beta":3,"T":24,"Reviews":0,"LastReview":1604192400,"Due":1604278800}
+}
+
+func Example_allCorrect() {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	e := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.CorrectEffort}
+
+	next, _ := e.create(review.ReviewItem{CardId: 1, Quality: review.NoReview}, now)
+	nextTime := time.Unix(next.Due, 0).UTC()
+
+	for i := 1; i <= 5; i++ {
+		next, _ = e.update(next, r, nextTime)
+		nextTime = time.Unix(next.Due, 0).UTC()
+		fmt.Printf("Alpha:%.2f, Beta:%.2f, T:%.1f, Due:%s\n", next.Alpha, next.Beta, next.T, nextTime.Format("2006-01-02 15:04"))
+	}
+
+	//Output:
+	//Alpha:3.03, Beta:3.03, T:30.4, Due:2020-11-03 06:26
+	//Alpha:3.06, Beta:3.06, T:38.5, Due:2020-11-04 20:55
+	//Alpha:3.10, Beta:3.10, T:48.6, Due:2020-11-06 21:30
+	//Alpha:3.13, Beta:3.13, T:61.2, Due:2020-11-09 10:39
+	//Alpha:3.16, Beta:3.16, T:76.8, Due:2020-11-12 15:27
+}
+
+func ExampleEbisu_Recall() {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	e := New(now)
+
+	item, _ := e.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+
+	// the card has no recall until its first review
+	p, _ := e.Recall(item, now)
+	fmt.Printf("Recall without review: %.3f\n", p)
+
+	item, _ = e.Update(item, review.ReviewItem{CardId: 1, Quality: review.CorrectEasy})
+
+	for _, hours := range []int{0, 12, 24, 48} {
+		p, _ := e.Recall(item, now.Add(time.Duration(hours)*time.Hour))
+		fmt.Printf("Recall after %d hours: %.3f\n", hours, p)
+	}
+
+	//Output:
+	//Recall without review: 0.000
+	//Recall after 0 hours: 1.000
+	//Recall after 12 hours: 0.693
+	//Recall after 24 hours: 0.500
+	//Recall after 48 hours: 0.286
+}
+
+func TestPredictRecallAtHalfLife(t *testing.T) {
+
+	// for a symmetric Beta the recall at t is 0.5
+	for _, ab := range []float64{2, 3, 4, 10} {
+		p := PredictRecall(ab, ab, 24, 24)
+		if !floatEqual(p, 0.5, 1e-9) {
+			t.Errorf("\ngot %#v\nwant %#v", p, 0.5)
+		}
+	}
+}
+
+func TestPercentileDecay(t *testing.T) {
+
+	hours := PercentileDecay(3, 3, 24, 0.5)
+	if !floatEqual(hours, 24, 1e-6) {
+		t.Errorf("\ngot %#v\nwant %#v", hours, 24.0)
+	}
+
+	hours = PercentileDecay(3, 3, 24, 0.8)
+	p := PredictRecall(3, 3, 24, hours)
+	if !floatEqual(p, 0.8, 1e-6) {
+		t.Errorf("\ngot %#v\nwant %#v", p, 0.8)
+	}
+}
+
+// TestUpdateRecallMoments checks the posterior against its moments
+// computed by numerical integration.
+func TestUpdateRecallMoments(t *testing.T) {
+
+	alpha, beta, t0 := 3.0, 4.0, 24.0
+
+	tests := []struct {
+		successes int
+		total     int
+		elapsed   float64
+	}{
+		{successes: 1, total: 1, elapsed: 30},
+		{successes: 0, total: 1, elapsed: 10},
+		{successes: 2, total: 3, elapsed: 48},
+		{successes: 0, total: 2, elapsed: 5},
+	}
+
+	for _, tc := range tests {
+		a, b, tback, err := UpdateRecall(alpha, beta, t0, tc.successes, tc.total, tc.elapsed)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		// posterior of p_t0 ∝ prior(p) · p^(dt·k) · (1 - p^dt)^(n-k)
+		dt := tc.elapsed / t0
+		et := tback / t0
+		density := func(p float64) float64 {
+			return math.Pow(p, alpha-1) * math.Pow(1-p, beta-1) *
+				math.Pow(p, dt*float64(tc.successes)) *
+				math.Pow(1-math.Pow(p, dt), float64(tc.total-tc.successes))
+		}
+
+		norm := integrate(density)
+		mean := integrate(func(p float64) float64 { return density(p) * math.Pow(p, et) }) / norm
+		m2 := integrate(func(p float64) float64 { return density(p) * math.Pow(p, 2*et) }) / norm
+
+		wantMean := a / (a + b)
+		wantM2 := a * (a + 1) / ((a + b) * (a + b + 1))
+
+		if !floatEqual(mean, 0.5, 1e-4) || !floatEqual(wantMean, 0.5, 1e-4) {
+			t.Errorf("\ngot mean %f (model %f)\nwant 0.5", mean, wantMean)
+		}
+
+		if !floatEqual(m2, wantM2, 1e-4) {
+			t.Errorf("\ngot second moment %f\nwant %f", m2, wantM2)
+		}
+	}
+}
+
+func TestUpdateSuccessFailure(t *testing.T) {
+
+	_, _, tSuccess, err := UpdateRecall(3, 3, 24, 1, 1, 24)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	_, _, tFailure, err := UpdateRecall(3, 3, 24, 0, 1, 24)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if tSuccess <= 24 {
+		t.Errorf("\ngot half-life %f after success\nwant > 24", tSuccess)
+	}
+
+	if tFailure >= 24 {
+		t.Errorf("\ngot half-life %f after failure\nwant < 24", tFailure)
+	}
+}
+
+func TestSoftBinaryEqualsBinary(t *testing.T) {
+
+	a1, b1, t1, _ := UpdateRecall(3, 3, 24, 1, 1, 24)
+	a2, b2, t2, _ := UpdateRecallSoft(3, 3, 24, 1.0, 24)
+
+	if a1 != a2 || b1 != b2 || t1 != t2 {
+		t.Errorf("\ngot %f %f %f\nwant %f %f %f", a2, b2, t2, a1, b1, t1)
+	}
+
+	// a noisy success is weaker than a sure success
+	_, _, tSoft, err := UpdateRecallSoft(3, 3, 24, 0.8, 24)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if tSoft >= t1 || tSoft <= 24 {
+		t.Errorf("\ngot half-life %f\nwant between 24 and %f", tSoft, t1)
+	}
+}
+
+func TestUpdateModes(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, mode := range []Mode{Binary, Binomial, Soft} {
+		e := New(now)
+		e.Mode = mode
+
+		old, _ := e.create(review.ReviewItem{CardId: 1}, now)
+		for q := review.IncorrectBlackout; q <= review.CorrectEasy; q++ {
+			n, err := e.update(old, review.ReviewItem{CardId: 1, Quality: q}, now.Add(24*time.Hour))
+			if err != nil {
+				t.Errorf("mode %d quality %d: got unexpected error %s", mode, q, err)
+			}
+
+			if n.Due <= now.Add(24*time.Hour).Unix() {
+				t.Errorf("mode %d quality %d: got due %d in the past", mode, q, n.Due)
+			}
+		}
+	}
+}
+
+// TestCreateWithReview tests that the quality of the first review updates the
+// prior model
+func TestCreateWithReview(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	e := New(now)
+
+	prior, err := e.create(review.ReviewItem{CardId: 1, Quality: review.NoReview}, now)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	failed, err := e.create(review.ReviewItem{CardId: 1, Quality: review.IncorrectBlackout}, now)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	passed, err := e.create(review.ReviewItem{CardId: 1, Quality: review.CorrectEasy}, now)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if prior.Reviews != 0 || failed.Reviews != 1 || passed.Reviews != 1 {
+		t.Errorf("\ngot reviews %d, %d, %d\nwant 0, 1, 1", prior.Reviews, failed.Reviews, passed.Reviews)
+	}
+
+	if !(failed.T < prior.T && prior.T <= passed.T) {
+		t.Errorf("\ngot T failed %f, prior %f, passed %f\nwant failed < prior <= passed", failed.T, prior.T, passed.T)
+	}
+
+	if failed.Due >= passed.Due {
+		t.Errorf("\ngot due failed %d, passed %d\nwant failed before passed", failed.Due, passed.Due)
+	}
+}
+
+func TestInvalidResult(t *testing.T) {
+
+	_, _, _, err := UpdateRecall(3, 3, 24, 3, 2, 24)
+	if err != ErrInvalidModel {
+		t.Errorf("\ngot error %v\nwant ErrInvalidModel", err)
+	}
+
+	_, _, _, err = UpdateRecallSoft(3, 3, 24, 1.5, 24)
+	if err != ErrInvalidModel {
+		t.Errorf("\ngot error %v\nwant ErrInvalidModel", err)
+	}
+}
+
+func TestUpdateDecodeError(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	e := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	b := []byte{'g', 'o', 'l', 'a', 'n', 'g'}
+	_, err := e.Update(b, r)
+
+	var se *json.SyntaxError
+	if !errors.As(err, &se) {
+		t.Errorf("\ngot error %s\nwant SyntaxError", err)
+	}
+}
+
+// integrate f in (0, 1) with the midpoint rule
+func integrate(f func(float64) float64) float64 {
+	const n = 200000
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += f((float64(i) + 0.5) / n)
+	}
+
+	return sum / n
+}
+
+func floatEqual(a, b, precision float64) bool {
+	return math.Abs(a-b) <= precision
+}
+
+func TestSummary(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	e := New(now)
+
+	item, _ := e.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+
+	d, err := e.Summary(item)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	want := review.DueItem{CardId: 1, Due: now.Add(24 * time.Hour), Interval: 24 * time.Hour, State: review.StateNew}
+	if d != want {
+		t.Errorf("\ngot %#v\nwant %#v", d, want)
+	}
+
+	item, _ = e.Update(item, review.ReviewItem{CardId: 1, Quality: review.CorrectEasy})
+
+	d, _ = e.Summary(item)
+	if d.State != review.StateReview {
+		t.Errorf("\ngot state %s\nwant state %s", d.State, review.StateReview)
+	}
+}
@@ -0,0 +1,355 @@
+// Package fsrs is an implementation of the Free Spaced Repetition Scheduler
+// (FSRS v4.5).
+// See https://github.com/open-spaced-repetition/fsrs4anki/wiki/The-Algorithm
+//
+// FSRS models the memory of a card with three variables:
+//
+//	D: difficulty, in the range [1, 10]
+//	S: stability, the interval (days) at which retrievability is 90%
+//	R: retrievability, the probability of recall after t days
+//
+//	R(t, S) = (1 + FACTOR × t / S) ^ DECAY
+//
+// After each review D and S are recalculated from the grade G (Again=1,
+// Hard=2, Good=3, Easy=4) and the current R, and the next interval is the
+// time until R drops to the requested retention.
+//
+// This implementation schedules in whole days, as the sm2 package does, and
+// ignores the FSRS short-term (same day) stability.
+package fsrs
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/review"
+)
+
+const (
+	Decay  = -0.5
+	Factor = 19.0 / 81.0
+
+	MinDifficulty = 1.0
+	MaxDifficulty = 10.0
+
+	DefaultRequestRetention = 0.9
+	DefaultMaximumInterval  = 36500
+)
+
+// DefaultWeights are the FSRS v4.5 default parameters.
+var DefaultWeights = [17]float64{
+	0.4872, 1.4003, 3.7145, 13.8206,
+	5.1618, 1.2298, 0.8975, 0.031,
+	1.6474, 0.1367, 1.0461, 2.1072,
+	0.0793, 0.3246, 1.587, 0.2272,
+	2.8755,
+}
+
+// Grade is the FSRS own review rating.
+type Grade int
+
+const (
+	Again Grade = iota + 1
+	Hard
+	Good
+	Easy
+)
+
+type Item struct {
+	CardId int
+
+	Difficulty float64
+
+	Stability float64
+
+	// number of reviews with quality
+	Reps int
+
+	// number of Again reviews of already reviewed cards
+	Lapses int
+
+	// Unix timestamp
+	LastReview int64
+
+	// Unix timestamp
+	Due int64
+}
+
+type Fsrs struct {
+	clock clock.Clock
+
+	Weights [17]float64
+
+	// RequestRetention is the probability of recall at which the card
+	// becomes due.
+	RequestRetention float64
+
+	// MaximumInterval in days
+	MaximumInterval int
+}
+
+// New returns a Fsrs with a clock fixed at now. Long-running processes should
+// use NewWithClock.
+func New(now time.Time) *Fsrs {
+	return NewWithClock(clock.NewFake(now))
+}
+
+// NewWithClock returns a Fsrs that asks c for the current time at each
+// Update.
+func NewWithClock(c clock.Clock) *Fsrs {
+	return &Fsrs{
+		clock:            c,
+		Weights:          DefaultWeights,
+		RequestRetention: DefaultRequestRetention,
+		MaximumInterval:  DefaultMaximumInterval,
+	}
+}
+
+// Update takes a serialized representation of a Item, deserializes it and
+// calculates a modified version according to the review
+func (f *Fsrs) Update(oldItem []byte, r review.ReviewItem) ([]byte, error) {
+
+	var newItem Item
+	if nil != oldItem {
+		decodedItem, err := decode(oldItem)
+		if err != nil {
+			return nil, err
+		}
+
+		newItem = f.update(decodedItem, r, r.Time(f.clock.Now()))
+	} else {
+		newItem = f.create(r, r.Time(f.clock.Now()))
+	}
+
+	return encode(newItem)
+}
+
+// Due determines if the serialized Item item is overdue.
+func (f *Fsrs) Due(item []byte, t time.Time) (d review.DueItem) {
+	dec, err := decode(item)
+	if err != nil {
+		return d
+	}
+
+	if dec.Due < t.Unix() {
+		return summary(dec)
+	}
+
+	return d
+}
+
+// Summary returns the schedule of the serialized Item item.
+func (f *Fsrs) Summary(item []byte) (review.DueItem, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return review.DueItem{}, err
+	}
+
+	return summary(dec), nil
+}
+
+// Recall returns the retrievability of the serialized Item at time t. Cards
+// without review have no retrievability.
+func (f *Fsrs) Recall(item []byte, t time.Time) (float64, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return 0, err
+	}
+
+	if dec.Reps == 0 {
+		return 0, nil
+	}
+
+	elapsed := math.Max(0, float64(t.Unix()-dec.LastReview)/(24*60*60))
+	return retrievability(elapsed, dec.Stability), nil
+}
+
+// Difficulty returns the Difficulty of the serialized Item item.
+func (f *Fsrs) Difficulty(item []byte) (float64, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return 0, err
+	}
+
+	return dec.Difficulty, nil
+}
+
+// grade translates the review quality to the FSRS grade. All incorrect
+// responses are an Again.
+func grade(q review.Quality) Grade {
+	switch {
+	case q <= review.IncorrectEasy:
+		return Again
+	case q == review.CorrectHard:
+		return Hard
+	case q == review.CorrectEffort:
+		return Good
+	default:
+		return Easy
+	}
+}
+
+// create returns an Item after processing the first review of a card.
+func (f *Fsrs) create(r review.ReviewItem, now time.Time) Item {
+
+	n := Item{}
+	n.CardId = r.CardId
+
+	// A card without review is scheduled for tomorrow, as in sm2. Its memory
+	// state is initialized at its first graded review.
+	if r.Quality == review.NoReview {
+		n.Due = now.AddDate(0, 0, 1).Unix()
+		return n
+	}
+
+	return f.first(n, grade(r.Quality), now)
+}
+
+// first initializes the memory state with the first graded review.
+func (f *Fsrs) first(n Item, g Grade, now time.Time) Item {
+	n.Difficulty = f.initDifficulty(g)
+	n.Stability = f.initStability(g)
+	n.Reps = 1
+	n.LastReview = now.Unix()
+	n.Due = now.AddDate(0, 0, f.interval(n.Stability)).Unix()
+	return n
+}
+
+// update recalculates the memory state of an already created Item.
+func (f *Fsrs) update(old Item, r review.ReviewItem, now time.Time) Item {
+
+	// No review does not change the memory state
+	if r.Quality == review.NoReview {
+		return old
+	}
+
+	g := grade(r.Quality)
+
+	if old.Reps == 0 {
+		return f.first(old, g, now)
+	}
+
+	n := old
+	elapsed := math.Max(0, float64(now.Unix()-old.LastReview)/(24*60*60))
+	ret := retrievability(elapsed, old.Stability)
+
+	n.Difficulty = f.nextDifficulty(old.Difficulty, g)
+	if g == Again {
+		n.Stability = f.forgetStability(old.Difficulty, old.Stability, ret)
+		n.Lapses = old.Lapses + 1
+	} else {
+		n.Stability = f.recallStability(old.Difficulty, old.Stability, ret, g)
+	}
+
+	n.Reps = old.Reps + 1
+	n.LastReview = now.Unix()
+	n.Due = now.AddDate(0, 0, f.interval(n.Stability)).Unix()
+
+	return n
+}
+
+// retrievability is the probability of recall after elapsed days.
+func retrievability(elapsed, stability float64) float64 {
+	return math.Pow(1+Factor*elapsed/stability, Decay)
+}
+
+// interval returns the days until retrievability drops to the requested
+// retention.
+func (f *Fsrs) interval(stability float64) int {
+	days := stability / Factor * (math.Pow(f.RequestRetention, 1/Decay) - 1)
+	i := int(math.Round(days))
+	if i < 1 {
+		return 1
+	}
+
+	if i > f.MaximumInterval {
+		return f.MaximumInterval
+	}
+
+	return i
+}
+
+func (f *Fsrs) initStability(g Grade) float64 {
+	return math.Max(f.Weights[g-1], 0.1)
+}
+
+func (f *Fsrs) initDifficulty(g Grade) float64 {
+	return clampDifficulty(f.Weights[4] - float64(g-3)*f.Weights[5])
+}
+
+func (f *Fsrs) nextDifficulty(d float64, g Grade) float64 {
+	next := d - f.Weights[6]*float64(g-3)
+	// mean reversion to the initial difficulty of a Good grade
+	return clampDifficulty(f.Weights[7]*f.initDifficulty(Good) + (1-f.Weights[7])*next)
+}
+
+func (f *Fsrs) recallStability(d, s, r float64, g Grade) float64 {
+	hardPenalty := 1.0
+	if g == Hard {
+		hardPenalty = f.Weights[15]
+	}
+
+	easyBonus := 1.0
+	if g == Easy {
+		easyBonus = f.Weights[16]
+	}
+
+	return s * (1 + math.Exp(f.Weights[8])*
+		(11-d)*
+		math.Pow(s, -f.Weights[9])*
+		(math.Exp((1-r)*f.Weights[10])-1)*
+		hardPenalty*
+		easyBonus)
+}
+
+func (f *Fsrs) forgetStability(d, s, r float64) float64 {
+	return f.Weights[11] *
+		math.Pow(d, -f.Weights[12]) *
+		(math.Pow(s+1, f.Weights[13]) - 1) *
+		math.Exp((1-r)*f.Weights[14])
+}
+
+func clampDifficulty(d float64) float64 {
+	return math.Min(math.Max(d, MinDifficulty), MaxDifficulty)
+}
+
+// summary translates the Item to a review.DueItem. FSRS does not have
+// learning steps: reviewed cards are always in review.
+func summary(i Item) review.DueItem {
+	d := review.DueItem{
+		CardId: i.CardId,
+		Due:    time.Unix(i.Due, 0).UTC(),
+		State:  review.StateReview,
+	}
+
+	if i.Reps == 0 {
+		d.State = review.StateNew
+		return d
+	}
+
+	d.Interval = time.Duration(i.Due-i.LastReview) * time.Second
+	return d
+}
+
+// deserialize
+func decode(encodedItem []byte) (Item, error) {
+	res := Item{}
+	err := json.Unmarshal(encodedItem, &res)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// serialize
+func encode(item Item) ([]byte, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,320 @@
+package fsrs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+func ExampleFsrs_Due() {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	fsrs := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	item, _ := fsrs.Update(nil, r)
+
+	dueItem := fsrs.Due(item, now.AddDate(0, 0, 2))
+
+	fmt.Printf("Due Item is %#v\n", dueItem)
+
+	//Output:
+	//Due Item is review.DueItem{CardId:1, Due:time.Date(2020, time.November, 2, 1, 0, 0, 0, time.UTC), Interval:0, State:0}
+}
+
+func ExampleFsrs_Update() {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	fsrs := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	item, _ := fsrs.Update(nil, r)
+
+	r = review.ReviewItem{CardId: 1, Quality: review.CorrectEffort}
+	item, _ = fsrs.Update(item, r)
+
+	fmt.Printf("Item is %s\n", item)
+
+	//Output:
+	//Item is {"CardId":1,"Difficulty":5.1618,"Stability":3.7145,"Reps":1,"Lapses":0,"LastReview":1604192400,"Due":1604538000}
+}
+
+func Example_allGood() {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	fmt.Printf("Now is '%s'\n", now.Format("2006-01-02 15:04:05"))
+
+	f := New(now)
+	r := review.ReviewItem{CardId: 1, Quality: review.CorrectEffort}
+
+	next := f.create(r, now)
+	nextTime := time.Unix(next.Due, 0).UTC()
+	fmt.Printf("Difficulty:%.2f, Stability:%.2f, Due:%s\n", next.Difficulty, next.Stability, nextTime.Format("2006-01-02"))
+
+	for i := 1; i <= 6; i++ {
+		next = f.update(next, r, nextTime)
+		nextTime = time.Unix(next.Due, 0).UTC()
+		fmt.Printf("Difficulty:%.2f, Stability:%.2f, Due:%s\n", next.Difficulty, next.Stability, nextTime.Format("2006-01-02"))
+	}
+
+	//Output:
+	//Now is '2020-11-01 00:00:00'
+	//Difficulty:5.16, Stability:3.71, Due:2020-11-05
+	//Difficulty:5.16, Stability:14.81, Due:2020-11-20
+	//Difficulty:5.16, Stability:49.46, Due:2021-01-08
+	//Difficulty:5.16, Stability:145.67, Due:2021-06-03
+	//Difficulty:5.16, Stability:392.70, Due:2022-07-01
+	//Difficulty:5.16, Stability:973.43, Due:2025-02-28
+	//Difficulty:5.16, Stability:2243.57, Due:2031-04-22
+}
+
+func Example_againGood() {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	fmt.Printf("Now is '%s'\n", now.Format("2006-01-02 15:04:05"))
+
+	f := New(now)
+
+	reviews := []review.ReviewItem{
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.IncorrectBlackout},
+		{CardId: 1, Quality: review.CorrectEffort},
+		{CardId: 1, Quality: review.CorrectEasy},
+	}
+
+	next := f.create(review.ReviewItem{CardId: 1, Quality: review.NoReview}, now)
+	nextTime := time.Unix(next.Due, 0).UTC()
+
+	for _, r := range reviews {
+		next = f.update(next, r, nextTime)
+		nextTime = time.Unix(next.Due, 0).UTC()
+		fmt.Printf("Difficulty:%.2f, Stability:%.2f, Lapses:%d, Due:%s\n", next.Difficulty, next.Stability, next.Lapses, nextTime.Format("2006-01-02"))
+	}
+
+	//Output:
+	//Now is '2020-11-01 00:00:00'
+	//Difficulty:5.16, Stability:3.71, Lapses:0, Due:2020-11-06
+	//Difficulty:5.16, Stability:14.81, Lapses:0, Due:2020-11-21
+	//Difficulty:6.90, Stability:3.15, Lapses:1, Due:2020-11-24
+	//Difficulty:6.85, Stability:9.20, Lapses:1, Due:2020-12-03
+	//Difficulty:5.93, Stability:54.74, Lapses:1, Due:2021-01-27
+}
+
+func TestGrade(t *testing.T) {
+	tests := []struct {
+		quality review.Quality
+		want    Grade
+	}{
+		{quality: review.IncorrectBlackout, want: Again},
+		{quality: review.IncorrectFamiliar, want: Again},
+		{quality: review.IncorrectEasy, want: Again},
+		{quality: review.CorrectHard, want: Hard},
+		{quality: review.CorrectEffort, want: Good},
+		{quality: review.CorrectEasy, want: Easy},
+	}
+
+	for _, tc := range tests {
+		g := grade(tc.quality)
+		if g != tc.want {
+			t.Errorf("\ngot %#v\nwant %#v", g, tc.want)
+		}
+	}
+}
+
+func TestInitialStability(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	f := New(now)
+
+	for g := Again; g <= Easy; g++ {
+		s := f.initStability(g)
+		if s != DefaultWeights[g-1] {
+			t.Errorf("\ngot %#v\nwant %#v", s, DefaultWeights[g-1])
+		}
+	}
+}
+
+func TestRetrievabilityAtStability(t *testing.T) {
+
+	// By definition, after S days the retrievability is 90%
+	for _, s := range []float64{0.5, 1, 10, 365} {
+		r := retrievability(s, s)
+		if !floatEqual(r, 0.9) {
+			t.Errorf("\ngot %#v\nwant %#v", r, 0.9)
+		}
+	}
+}
+
+func TestIntervalEqualsStability(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	f := New(now)
+
+	tests := []struct {
+		stability float64
+		want      int
+	}{
+		{stability: 0.2, want: 1},
+		{stability: 3.7, want: 4},
+		{stability: 20, want: 20},
+		{stability: 1000000, want: DefaultMaximumInterval},
+	}
+
+	for _, tc := range tests {
+		i := f.interval(tc.stability)
+		if i != tc.want {
+			t.Errorf("\ngot %#v\nwant %#v", i, tc.want)
+		}
+	}
+}
+
+func TestDifficultyBounds(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	f := New(now)
+
+	d := f.initDifficulty(Again)
+	for i := 0; i < 20; i++ {
+		d = f.nextDifficulty(d, Again)
+	}
+
+	if d > MaxDifficulty {
+		t.Errorf("\ngot %#v\nwant <= %#v", d, MaxDifficulty)
+	}
+
+	d = f.initDifficulty(Easy)
+	for i := 0; i < 20; i++ {
+		d = f.nextDifficulty(d, Easy)
+	}
+
+	if d < MinDifficulty {
+		t.Errorf("\ngot %#v\nwant >= %#v", d, MinDifficulty)
+	}
+}
+
+func TestNewCardWithoutReviewQuality(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	f := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+
+	wantStartItem := Item{
+		CardId: 1,
+		Due:    now.AddDate(0, 0, 1).Unix(),
+	}
+
+	haveItem := f.create(r, now)
+	if haveItem != wantStartItem {
+		t.Errorf("\ngot %#v\nwant %#v", haveItem, wantStartItem)
+	}
+}
+
+func TestForgetLowersStability(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	f := New(now)
+
+	item := f.create(review.ReviewItem{CardId: 1, Quality: review.CorrectEasy}, now)
+	due := time.Unix(item.Due, 0)
+
+	forgotten := f.update(item, review.ReviewItem{CardId: 1, Quality: review.IncorrectFamiliar}, due)
+	if forgotten.Stability >= item.Stability {
+		t.Errorf("\ngot stability %f\nwant < %f", forgotten.Stability, item.Stability)
+	}
+
+	if forgotten.Lapses != 1 {
+		t.Errorf("\ngot lapses %d\nwant %d", forgotten.Lapses, 1)
+	}
+}
+
+func TestUpdateDecodeError(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	fsrs := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	b := []byte{'g', 'o', 'l', 'a', 'n', 'g'}
+	_, err := fsrs.Update(b, r)
+
+	var e *json.SyntaxError
+	if !errors.As(err, &e) {
+		t.Errorf("\ngot error %s\nwant SyntaxError", err)
+	}
+}
+
+func TestSummary(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	f := New(now)
+
+	item, _ := f.Update(nil, review.ReviewItem{CardId: 1, Quality: review.CorrectEffort})
+
+	d, err := f.Summary(item)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// Good stability 3.7 days
+	want := review.DueItem{CardId: 1, Due: now.AddDate(0, 0, 4), Interval: 4 * 24 * time.Hour, State: review.StateReview}
+	if d != want {
+		t.Errorf("\ngot %#v\nwant %#v", d, want)
+	}
+}
+
+func floatEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 0.000000001
+}
+
+func TestRecall(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	f := New(now)
+
+	item, _ := f.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+
+	p, err := f.Recall(item, now)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if p != 0 {
+		t.Errorf("\ngot recall %f\nwant 0", p)
+	}
+
+	item, _ = f.Update(item, review.ReviewItem{CardId: 1, Quality: review.CorrectEffort})
+	dec, _ := decode(item)
+
+	// at the due time the recall is the requested retention
+	p, _ = f.Recall(item, time.Unix(dec.Due, 0))
+	if math.Abs(p-f.RequestRetention) > 0.01 {
+		t.Errorf("\ngot recall %f\nwant %f", p, f.RequestRetention)
+	}
+}
+
+func TestDifficulty(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	f := New(now)
+
+	item, _ := f.Update(nil, review.ReviewItem{CardId: 1, Quality: review.CorrectEffort})
+	dec, _ := decode(item)
+
+	d, err := f.Difficulty(item)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if d != dec.Difficulty || d <= 0 {
+		t.Errorf("\ngot %#v\nwant %#v", d, dec.Difficulty)
+	}
+}
@@ -0,0 +1,273 @@
+// Package hlr is an implementation of the Duolingo half-life regression
+// algorithm.
+// See https://research.duolingo.com/papers/settles.acl16.pdf
+//
+// HLR estimates the half-life h (days) of a card from a feature vector x and
+// a weight vector θ:
+//
+//	h = 2 ^ (θ · x)
+//
+// The predicted recall probability after a lag of Δ days since the last
+// review is:
+//
+//	p = 2 ^ (-Δ / h)
+//
+// The features are the (square root of the) number of correct and incorrect
+// reviews of the card, and a bias term. The next review is scheduled when p
+// drops below the Target recall.
+//
+// The weights θ can be trained offline from the review history (see Trainer)
+// and loaded with LoadWeights.
+package hlr
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/review"
+)
+
+const (
+	// MinHalfLife is 15 minutes, in days
+	MinHalfLife = 15.0 / (24 * 60)
+	MaxHalfLife = 274.0
+
+	DefaultTarget = 0.5
+)
+
+// Weights is the θ vector of the model, one weight for each feature.
+type Weights struct {
+	Right float64
+	Wrong float64
+	Bias  float64
+}
+
+// DefaultWeights give a half-life of 1 day to new cards.
+var DefaultWeights = Weights{
+	Right: 2.0,
+	Wrong: -1.0,
+	Bias:  -1.0,
+}
+
+// LoadWeights decodes trained weights in JSON format
+func LoadWeights(r io.Reader) (Weights, error) {
+	var w Weights
+	err := json.NewDecoder(r).Decode(&w)
+	if err != nil {
+		return w, err
+	}
+
+	return w, nil
+}
+
+// Save encodes the weights in JSON format
+func (w Weights) Save(wr io.Writer) error {
+	return json.NewEncoder(wr).Encode(w)
+}
+
+type Item struct {
+	CardId int
+
+	// Right is the number of correct reviews
+	Right int
+
+	// Wrong is the number of incorrect reviews
+	Wrong int
+
+	// HalfLife in days
+	HalfLife float64
+
+	// Unix timestamp
+	LastReview int64
+
+	// Unix timestamp
+	Due int64
+}
+
+type Hlr struct {
+	clock clock.Clock
+
+	Weights Weights
+
+	// Target is the predicted recall probability at which the card becomes
+	// due.
+	Target float64
+}
+
+// New returns a Hlr with a clock fixed at now. Long-running processes should
+// use NewWithClock.
+func New(now time.Time) *Hlr {
+	return NewWithClock(clock.NewFake(now))
+}
+
+// NewWithClock returns a Hlr that asks c for the current time at each
+// Update.
+func NewWithClock(c clock.Clock) *Hlr {
+	return &Hlr{
+		clock:   c,
+		Weights: DefaultWeights,
+		Target:  DefaultTarget,
+	}
+}
+
+// Update takes a serialized representation of a Item, deserializes it and
+// calculates a modified version according to the review
+func (h *Hlr) Update(oldItem []byte, r review.ReviewItem) ([]byte, error) {
+
+	var old Item
+	if nil != oldItem {
+		decodedItem, err := decode(oldItem)
+		if err != nil {
+			return nil, err
+		}
+
+		old = decodedItem
+	}
+
+	return encode(h.update(old, r, r.Time(h.clock.Now())))
+}
+
+// Due determines if the serialized Item item is overdue.
+func (h *Hlr) Due(item []byte, t time.Time) (d review.DueItem) {
+	dec, err := decode(item)
+	if err != nil {
+		return d
+	}
+
+	if dec.Due < t.Unix() {
+		return h.summary(dec)
+	}
+
+	return d
+}
+
+// Summary returns the schedule of the serialized Item item.
+func (h *Hlr) Summary(item []byte) (review.DueItem, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return review.DueItem{}, err
+	}
+
+	return h.summary(dec), nil
+}
+
+// Recall returns the predicted recall probability of the serialized Item at
+// time t. Cards without review have no recall.
+func (h *Hlr) Recall(item []byte, t time.Time) (float64, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return 0, err
+	}
+
+	if dec.Right+dec.Wrong == 0 {
+		return 0, nil
+	}
+
+	lag := math.Max(0, float64(t.Unix()-dec.LastReview)/(24*60*60))
+	return Recall(dec.HalfLife, lag), nil
+}
+
+// update counts the review and recalculates the half-life
+func (h *Hlr) update(old Item, r review.ReviewItem, now time.Time) Item {
+
+	n := old
+	n.CardId = r.CardId
+
+	switch {
+	case r.Quality == review.NoReview:
+	case r.Quality >= review.CorrectHard:
+		n.Right++
+		n.LastReview = now.Unix()
+	default:
+		n.Wrong++
+		n.LastReview = now.Unix()
+	}
+
+	n.HalfLife = HalfLife(h.Weights, n.Right, n.Wrong)
+
+	interval := h.interval(n.HalfLife)
+	n.Due = now.Add(interval).Unix()
+
+	return n
+}
+
+// interval returns the time until the predicted recall drops to the target.
+//
+//	Δ = -h × log2(target)
+func (h *Hlr) interval(halfLife float64) time.Duration {
+	days := -halfLife * math.Log2(h.Target)
+	return time.Duration(days * 24 * float64(time.Hour))
+}
+
+// HalfLife returns the estimated half-life in days for the review counts
+func HalfLife(w Weights, right, wrong int) float64 {
+	return clipHalfLife(math.Pow(2, w.dot(features(right, wrong))))
+}
+
+// Recall returns the predicted recall probability after lag days.
+func Recall(halfLife, lag float64) float64 {
+	return clipRecall(math.Pow(2, -lag/halfLife))
+}
+
+// features returns the feature vector x for the counts, in the order Right,
+// Wrong, Bias.
+func features(right, wrong int) [3]float64 {
+	return [3]float64{
+		math.Sqrt(1 + float64(right)),
+		math.Sqrt(1 + float64(wrong)),
+		1,
+	}
+}
+
+func (w Weights) dot(x [3]float64) float64 {
+	return w.Right*x[0] + w.Wrong*x[1] + w.Bias*x[2]
+}
+
+func clipHalfLife(h float64) float64 {
+	return math.Min(math.Max(h, MinHalfLife), MaxHalfLife)
+}
+
+func clipRecall(p float64) float64 {
+	return math.Min(math.Max(p, 0.0001), 0.9999)
+}
+
+// summary translates the Item to a review.DueItem. Cards without review are
+// new.
+func (h *Hlr) summary(i Item) review.DueItem {
+	d := review.DueItem{
+		CardId:   i.CardId,
+		Due:      time.Unix(i.Due, 0).UTC(),
+		Interval: h.interval(i.HalfLife),
+		State:    review.StateReview,
+	}
+
+	if i.Right+i.Wrong == 0 {
+		d.State = review.StateNew
+	}
+
+	return d
+}
+
+// deserialize
+func decode(encodedItem []byte) (Item, error) {
+	res := Item{}
+	err := json.Unmarshal(encodedItem, &res)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// serialize
+func encode(item Item) ([]byte, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,187 @@
+package hlr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+func ExampleHlr_Update() {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	h := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	item, _ := h.Update(nil, r)
+
+	fmt.Printf("Item is %s\n", item)
+
+	//Output:
+	//Item is {"CardId":1,"Right":0,"Wrong":0,"HalfLife":1,"LastReview":0,"Due":1604278800}
+}
+
+func Example_allCorrect() {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	h := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.CorrectEffort}
+
+	next := Item{}
+	nextTime := now
+	for i := 1; i <= 6; i++ {
+		next = h.update(next, r, nextTime)
+		nextTime = time.Unix(next.Due, 0).UTC()
+		fmt.Printf("Right:%d, HalfLife:%.2f, Due:%s\n", next.Right, next.HalfLife, nextTime.Format("2006-01-02 15:04"))
+	}
+
+	//Output:
+	//Right:1, HalfLife:1.78, Due:2020-11-02 18:37
+	//Right:2, HalfLife:2.76, Due:2020-11-05 12:49
+	//Right:3, HalfLife:4.00, Due:2020-11-09 12:49
+	//Right:4, HalfLife:5.55, Due:2020-11-15 01:59
+	//Right:5, HalfLife:7.46, Due:2020-11-22 13:00
+	//Right:6, HalfLife:9.79, Due:2020-12-02 08:00
+}
+
+func TestHalfLifeClip(t *testing.T) {
+
+	tests := []struct {
+		weights Weights
+		want    float64
+	}{
+		{weights: Weights{Bias: -100}, want: MinHalfLife},
+		{weights: Weights{Bias: 100}, want: MaxHalfLife},
+		{weights: Weights{Bias: 3}, want: 8},
+	}
+
+	for _, tc := range tests {
+		h := HalfLife(tc.weights, 0, 0)
+		if !floatEqual(h, tc.want) {
+			t.Errorf("\ngot %#v\nwant %#v", h, tc.want)
+		}
+	}
+}
+
+func TestRecallAtHalfLife(t *testing.T) {
+
+	for _, h := range []float64{0.5, 1, 10, 100} {
+		p := Recall(h, h)
+		if !floatEqual(p, 0.5) {
+			t.Errorf("\ngot %#v\nwant %#v", p, 0.5)
+		}
+	}
+}
+
+func TestIntervalTarget(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	h := New(now)
+
+	tests := []struct {
+		target float64
+		want   time.Duration
+	}{
+		{target: 0.5, want: 48 * time.Hour},
+		{target: 0.25, want: 96 * time.Hour},
+	}
+
+	for _, tc := range tests {
+		h.Target = tc.target
+		i := h.interval(2)
+		if i != tc.want {
+			t.Errorf("\ngot %s\nwant %s", i, tc.want)
+		}
+	}
+}
+
+func TestUpdateCounts(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	h := New(now)
+
+	n := h.update(Item{}, review.ReviewItem{CardId: 3, Quality: review.CorrectHard}, now)
+	n = h.update(n, review.ReviewItem{CardId: 3, Quality: review.IncorrectEasy}, now)
+	n = h.update(n, review.ReviewItem{CardId: 3, Quality: review.NoReview}, now)
+
+	if n.Right != 1 || n.Wrong != 1 {
+		t.Errorf("\ngot right %d wrong %d\nwant right 1 wrong 1", n.Right, n.Wrong)
+	}
+
+	if n.LastReview != now.Unix() {
+		t.Errorf("\ngot last review %d\nwant %d", n.LastReview, now.Unix())
+	}
+}
+
+func TestLoadWeights(t *testing.T) {
+
+	w, err := LoadWeights(strings.NewReader(`{"Right": 1.5, "Wrong": -0.25, "Bias": 0.75}`))
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	want := Weights{Right: 1.5, Wrong: -0.25, Bias: 0.75}
+	if w != want {
+		t.Errorf("\ngot %#v\nwant %#v", w, want)
+	}
+
+	var buf bytes.Buffer
+	if err := w.Save(&buf); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	loaded, err := LoadWeights(&buf)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if loaded != want {
+		t.Errorf("\ngot %#v\nwant %#v", loaded, want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	h := New(now)
+
+	item, _ := h.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+
+	d, err := h.Summary(item)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	want := review.DueItem{CardId: 1, Due: now.Add(24 * time.Hour), Interval: 24 * time.Hour, State: review.StateNew}
+	if d != want {
+		t.Errorf("\ngot %#v\nwant %#v", d, want)
+	}
+}
+
+func TestUpdateDecodeError(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	h := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	b := []byte{'g', 'o', 'l', 'a', 'n', 'g'}
+	_, err := h.Update(b, r)
+
+	var e *json.SyntaxError
+	if !errors.As(err, &e) {
+		t.Errorf("\ngot error %s\nwant SyntaxError", err)
+	}
+}
+
+func floatEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 0.000000001
+}
@@ -0,0 +1,190 @@
+package hlr
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+const (
+	DefaultLearningRate   = 0.001
+	DefaultHalfLifeWeight = 0.01
+	DefaultL2Weight       = 0.1
+	DefaultSigma          = 1.0
+	DefaultEpochs         = 10
+)
+
+// HistoryItem is a stored review of a card of a deck
+type HistoryItem struct {
+	DeckId     string
+	CardId     int
+	Quality    review.Quality
+	ReviewedAt time.Time
+}
+
+// Sample is a training instance: the review counts of a card before a
+// review, the lag in days since its previous review, and the observed recall
+// (1 correct, 0 incorrect, or a fraction for aggregated sessions).
+type Sample struct {
+	Right  int
+	Wrong  int
+	Lag    float64
+	Recall float64
+}
+
+// card identifies a card in the history of several decks
+type card struct {
+	deckId string
+	cardId int
+}
+
+// Samples builds the training instances from the review history. The history
+// is ordered chronologically for each card of each deck. The first review of
+// each card has no lag and produces no Sample. Reviews without quality are
+// ignored.
+func Samples(history []HistoryItem) []Sample {
+
+	byCard := map[card][]HistoryItem{}
+	var cards []card
+	for _, hi := range history {
+		if hi.Quality == review.NoReview {
+			continue
+		}
+
+		c := card{deckId: hi.DeckId, cardId: hi.CardId}
+		if _, ok := byCard[c]; !ok {
+			cards = append(cards, c)
+		}
+
+		byCard[c] = append(byCard[c], hi)
+	}
+
+	var samples []Sample
+	for _, c := range cards {
+		items := byCard[c]
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].ReviewedAt.Before(items[j].ReviewedAt)
+		})
+
+		right, wrong := 0, 0
+		for idx, hi := range items {
+			correct := hi.Quality >= review.CorrectHard
+
+			if idx > 0 {
+				s := Sample{Right: right, Wrong: wrong}
+				s.Lag = hi.ReviewedAt.Sub(items[idx-1].ReviewedAt).Hours() / 24
+				if correct {
+					s.Recall = 1
+				}
+
+				samples = append(samples, s)
+			}
+
+			if correct {
+				right++
+			} else {
+				wrong++
+			}
+		}
+	}
+
+	return samples
+}
+
+// LogSamples builds the training instances from the stored review log, for
+// example the DeckLog of the db handler of each deck. See Samples.
+func LogSamples(entries []db.LogEntry) []Sample {
+	history := make([]HistoryItem, len(entries))
+	for i, e := range entries {
+		history[i] = HistoryItem{DeckId: e.DeckId, CardId: e.CardId, Quality: e.Quality, ReviewedAt: e.ReviewedAt}
+	}
+
+	return Samples(history)
+}
+
+// Trainer fits the Weights with stochastic gradient descent, minimizing the
+// squared error of the recall probability and of the half-life, with L2
+// regularization.
+type Trainer struct {
+	LearningRate float64
+
+	// HalfLifeWeight is the weight of the half-life loss term
+	HalfLifeWeight float64
+
+	L2Weight float64
+	Sigma    float64
+
+	Epochs int
+}
+
+func NewTrainer() *Trainer {
+	return &Trainer{
+		LearningRate:   DefaultLearningRate,
+		HalfLifeWeight: DefaultHalfLifeWeight,
+		L2Weight:       DefaultL2Weight,
+		Sigma:          DefaultSigma,
+		Epochs:         DefaultEpochs,
+	}
+}
+
+// Fit returns the weights trained from the initial weights w with the samples.
+func (tr *Trainer) Fit(w Weights, samples []Sample) Weights {
+
+	theta := [3]float64{w.Right, w.Wrong, w.Bias}
+	// number of updates of each feature, for the adaptive learning rate
+	var counts [3]float64
+
+	for epoch := 0; epoch < tr.Epochs; epoch++ {
+		for _, s := range samples {
+			if s.Lag <= 0 {
+				continue
+			}
+
+			x := features(s.Right, s.Wrong)
+
+			dp := theta[0]*x[0] + theta[1]*x[1] + theta[2]*x[2]
+			h := clipHalfLife(math.Pow(2, dp))
+			p := Recall(h, s.Lag)
+
+			recall := clipRecall(s.Recall)
+			// observed half-life
+			hObs := clipHalfLife(-s.Lag / math.Log2(recall))
+
+			dlpDw := 2 * (p - recall) * math.Ln2 * math.Ln2 * p * (s.Lag / h)
+			dlhDw := 2 * (h - hObs) * math.Ln2 * h
+
+			for k := range theta {
+				if x[k] == 0 {
+					continue
+				}
+
+				rate := (1 / (1 + recall)) * tr.LearningRate / math.Sqrt(1+counts[k])
+				theta[k] -= rate * dlpDw * x[k]
+				theta[k] -= rate * tr.HalfLifeWeight * dlhDw * x[k]
+				theta[k] -= rate * tr.L2Weight * theta[k] / (tr.Sigma * tr.Sigma)
+				counts[k]++
+			}
+		}
+	}
+
+	return Weights{Right: theta[0], Wrong: theta[1], Bias: theta[2]}
+}
+
+// Loss returns the mean squared error of the recall probability predicted by
+// the weights w for the samples.
+func Loss(w Weights, samples []Sample) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, s := range samples {
+		p := Recall(HalfLife(w, s.Right, s.Wrong), s.Lag)
+		sum += (p - s.Recall) * (p - s.Recall)
+	}
+
+	return sum / float64(len(samples))
+}
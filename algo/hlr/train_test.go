@@ -0,0 +1,137 @@
+package hlr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+func TestSamples(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	// unordered history of two cards
+	history := []HistoryItem{
+		{CardId: 1, Quality: review.IncorrectBlackout, ReviewedAt: now.AddDate(0, 0, 3)},
+		{CardId: 2, Quality: review.CorrectEasy, ReviewedAt: now},
+		{CardId: 1, Quality: review.CorrectEffort, ReviewedAt: now},
+		{CardId: 1, Quality: review.NoReview, ReviewedAt: now.AddDate(0, 0, 2)},
+		{CardId: 1, Quality: review.CorrectHard, ReviewedAt: now.AddDate(0, 0, 1)},
+	}
+
+	want := []Sample{
+		{Right: 1, Wrong: 0, Lag: 1, Recall: 1},
+		{Right: 2, Wrong: 0, Lag: 2, Recall: 0},
+	}
+
+	have := Samples(history)
+	if len(have) != len(want) {
+		t.Fatalf("\nCheking len:\ngot %d\nwant %d", len(have), len(want))
+	}
+
+	for idx := range want {
+		if have[idx] != want[idx] {
+			t.Errorf("\ngot %#v\nwant %#v", have[idx], want[idx])
+		}
+	}
+}
+
+func TestSamplesByDeck(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	// card 1 of two decks, reviewed on alternate days
+	history := []HistoryItem{
+		{DeckId: "a", CardId: 1, Quality: review.CorrectEffort, ReviewedAt: now},
+		{DeckId: "b", CardId: 1, Quality: review.IncorrectBlackout, ReviewedAt: now.AddDate(0, 0, 1)},
+		{DeckId: "a", CardId: 1, Quality: review.CorrectHard, ReviewedAt: now.AddDate(0, 0, 2)},
+		{DeckId: "b", CardId: 1, Quality: review.CorrectEasy, ReviewedAt: now.AddDate(0, 0, 4)},
+	}
+
+	want := []Sample{
+		{Right: 1, Wrong: 0, Lag: 2, Recall: 1},
+		{Right: 0, Wrong: 1, Lag: 3, Recall: 1},
+	}
+
+	have := Samples(history)
+	if len(have) != len(want) {
+		t.Fatalf("\nCheking len:\ngot %d\nwant %d", len(have), len(want))
+	}
+
+	for idx := range want {
+		if have[idx] != want[idx] {
+			t.Errorf("\ngot %#v\nwant %#v", have[idx], want[idx])
+		}
+	}
+}
+
+func TestLogSamples(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	entries := []db.LogEntry{
+		{DeckId: "a", CardId: 1, Quality: review.NoReview, ReviewedAt: now},
+		{DeckId: "a", CardId: 1, Quality: review.CorrectEffort, ReviewedAt: now.AddDate(0, 0, 1)},
+		{DeckId: "a", CardId: 1, Quality: review.IncorrectFamiliar, ReviewedAt: now.AddDate(0, 0, 3)},
+	}
+
+	want := []Sample{{Right: 1, Wrong: 0, Lag: 2, Recall: 0}}
+
+	have := LogSamples(entries)
+	if len(have) != len(want) {
+		t.Fatalf("\nCheking len:\ngot %d\nwant %d", len(have), len(want))
+	}
+
+	if have[0] != want[0] {
+		t.Errorf("\ngot %#v\nwant %#v", have[0], want[0])
+	}
+}
+
+func TestFitReducesLoss(t *testing.T) {
+
+	// Samples generated with known weights, with the exact recall
+	// probability as observed recall.
+	truth := Weights{Right: 1.5, Wrong: -0.5, Bias: 0.5}
+
+	var samples []Sample
+	for right := 0; right < 8; right++ {
+		for wrong := 0; wrong < 4; wrong++ {
+			for _, lag := range []float64{0.5, 1, 3, 7, 15} {
+				p := Recall(HalfLife(truth, right, wrong), lag)
+				samples = append(samples, Sample{Right: right, Wrong: wrong, Lag: lag, Recall: p})
+			}
+		}
+	}
+
+	tr := NewTrainer()
+	tr.LearningRate = 0.01
+	tr.Epochs = 50
+
+	start := Weights{}
+	fitted := tr.Fit(start, samples)
+
+	before := Loss(start, samples)
+	after := Loss(fitted, samples)
+
+	t.Logf("loss before %f, after %f, weights %#v", before, after, fitted)
+
+	if after >= before {
+		t.Errorf("\ngot loss %f\nwant < %f", after, before)
+	}
+
+	if fitted.Right <= 0 {
+		t.Errorf("\ngot Right weight %f\nwant > 0", fitted.Right)
+	}
+}
+
+func TestFitNoSamples(t *testing.T) {
+
+	tr := NewTrainer()
+	fitted := tr.Fit(DefaultWeights, nil)
+
+	if fitted != DefaultWeights {
+		t.Errorf("\ngot %#v\nwant %#v", fitted, DefaultWeights)
+	}
+}
@@ -0,0 +1,230 @@
+// Package leitner is an implementation of the Leitner box system.
+// See https://en.wikipedia.org/wiki/Leitner_system
+//
+// Cards are kept in numbered boxes, starting at box 1. Each box has its own
+// review interval, growing with the box number:
+//
+//	correct response (q ≥ 4):   the card moves to the next box
+//	incorrect response (q < 4): the card moves back to box 1, or one box
+//	                            down, depending on the Demotion policy
+//
+// The card is due after the interval of its (new) box.
+package leitner
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/review"
+)
+
+// Demotion is the policy applied to a card after an incorrect response.
+type Demotion int
+
+const (
+	// DemoteToFirst moves the card back to box 1.
+	DemoteToFirst Demotion = iota
+
+	// DemoteOneBox moves the card one box down.
+	DemoteOneBox
+)
+
+const FirstBox = 1
+
+// DefaultIntervals are the intervals in days of the boxes 1 to 5.
+var DefaultIntervals = []int{1, 2, 4, 8, 16}
+
+type Item struct {
+	CardId int
+
+	Box int
+
+	// Reps is the number of reviews of the card
+	Reps int
+
+	// Unix timestamp
+	Due int64
+}
+
+type Leitner struct {
+	clock clock.Clock
+
+	// Intervals contains the review interval in days for each box. Box n
+	// uses Intervals[n-1].
+	Intervals []int
+
+	Demotion Demotion
+}
+
+// New returns a Leitner with a clock fixed at now. Long-running processes should
+// use NewWithClock.
+func New(now time.Time) *Leitner {
+	return NewWithClock(clock.NewFake(now))
+}
+
+// NewWithClock returns a Leitner that asks c for the current time at each
+// Update.
+func NewWithClock(c clock.Clock) *Leitner {
+	return &Leitner{
+		clock:     c,
+		Intervals: append([]int(nil), DefaultIntervals...),
+		Demotion:  DemoteToFirst,
+	}
+}
+
+// Update takes a serialized representation of a Item, deserializes it and
+// moves it to another box according to the review
+func (l *Leitner) Update(oldItem []byte, r review.ReviewItem) ([]byte, error) {
+
+	var newItem Item
+	if nil != oldItem {
+		decodedItem, err := decode(oldItem)
+		if err != nil {
+			return nil, err
+		}
+
+		newItem = l.update(decodedItem, r, r.Time(l.clock.Now()))
+	} else {
+		newItem = l.create(r, r.Time(l.clock.Now()))
+	}
+
+	return encode(newItem)
+}
+
+// Due determines if the serialized Item item is overdue.
+func (l *Leitner) Due(item []byte, t time.Time) (d review.DueItem) {
+	dec, err := decode(item)
+	if err != nil {
+		return d
+	}
+
+	if dec.Due < t.Unix() {
+		return l.summary(dec)
+	}
+
+	return d
+}
+
+// Summary returns the schedule of the serialized Item item.
+func (l *Leitner) Summary(item []byte) (review.DueItem, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return review.DueItem{}, err
+	}
+
+	return l.summary(dec), nil
+}
+
+// create puts a new card in the first box. If the card has already a review
+// quality, it is applied.
+func (l *Leitner) create(r review.ReviewItem, now time.Time) Item {
+
+	n := Item{}
+	n.CardId = r.CardId
+	n.Box = FirstBox
+
+	if r.Quality == review.NoReview {
+		n.Due = now.AddDate(0, 0, l.interval(n.Box)).Unix()
+		return n
+	}
+
+	return l.update(n, r, now)
+}
+
+// update moves the card to its next box.
+func (l *Leitner) update(old Item, r review.ReviewItem, now time.Time) Item {
+
+	n := Item{}
+	n.CardId = r.CardId
+	n.Box = old.Box
+	n.Reps = old.Reps
+
+	switch {
+	case r.Quality == review.NoReview:
+	case r.Quality >= review.CorrectHard:
+		n.Box = l.promote(old.Box)
+		n.Reps++
+	default:
+		n.Box = l.demote(old.Box)
+		n.Reps++
+	}
+
+	n.Due = now.AddDate(0, 0, l.interval(n.Box)).Unix()
+	return n
+}
+
+func (l *Leitner) promote(box int) int {
+	if box >= len(l.Intervals) {
+		return len(l.Intervals)
+	}
+
+	return box + 1
+}
+
+func (l *Leitner) demote(box int) int {
+	if l.Demotion == DemoteOneBox && box > FirstBox {
+		return box - 1
+	}
+
+	return FirstBox
+}
+
+// interval returns the days of the box. Boxes out of range use the nearest
+// box.
+func (l *Leitner) interval(box int) int {
+	if len(l.Intervals) == 0 {
+		return 1
+	}
+
+	if box < FirstBox {
+		box = FirstBox
+	}
+
+	if box > len(l.Intervals) {
+		box = len(l.Intervals)
+	}
+
+	return l.Intervals[box-1]
+}
+
+// summary translates the Item to a review.DueItem. Cards without review are
+// new, reviewed cards in the first box are learning.
+func (l *Leitner) summary(i Item) review.DueItem {
+	d := review.DueItem{
+		CardId:   i.CardId,
+		Due:      time.Unix(i.Due, 0).UTC(),
+		Interval: time.Duration(l.interval(i.Box)) * 24 * time.Hour,
+		State:    review.StateReview,
+	}
+
+	switch {
+	case i.Reps == 0:
+		d.State = review.StateNew
+	case i.Box <= FirstBox:
+		d.State = review.StateLearning
+	}
+
+	return d
+}
+
+// deserialize
+func decode(encodedItem []byte) (Item, error) {
+	res := Item{}
+	err := json.Unmarshal(encodedItem, &res)
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// serialize
+func encode(item Item) ([]byte, error) {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,190 @@
+package leitner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+func ExampleLeitner_Update() {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	l := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	item, _ := l.Update(nil, r)
+
+	r = review.ReviewItem{CardId: 1, Quality: review.CorrectHard}
+	item, _ = l.Update(item, r)
+
+	fmt.Printf("Item is %s\n", item)
+
+	//Output:
+	//Item is {"CardId":1,"Box":2,"Reps":1,"Due":1604365200}
+}
+
+func Example_allCorrect() {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	l := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.CorrectEffort}
+
+	next := l.create(review.ReviewItem{CardId: 1, Quality: review.NoReview}, now)
+	nextTime := time.Unix(next.Due, 0).UTC()
+	fmt.Printf("Box:%d, Due:%s\n", next.Box, nextTime.Format("2006-01-02"))
+
+	for i := 1; i <= 6; i++ {
+		next = l.update(next, r, nextTime)
+		nextTime = time.Unix(next.Due, 0).UTC()
+		fmt.Printf("Box:%d, Due:%s\n", next.Box, nextTime.Format("2006-01-02"))
+	}
+
+	//Output:
+	//Box:1, Due:2020-11-02
+	//Box:2, Due:2020-11-04
+	//Box:3, Due:2020-11-08
+	//Box:4, Due:2020-11-16
+	//Box:5, Due:2020-12-02
+	//Box:5, Due:2020-12-18
+	//Box:5, Due:2021-01-03
+}
+
+func TestDemotion(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		demotion Demotion
+		box      int
+		want     int
+	}{
+		{demotion: DemoteToFirst, box: 4, want: 1},
+		{demotion: DemoteToFirst, box: 1, want: 1},
+		{demotion: DemoteOneBox, box: 4, want: 3},
+		{demotion: DemoteOneBox, box: 1, want: 1},
+	}
+
+	for _, tc := range tests {
+		l := New(now)
+		l.Demotion = tc.demotion
+
+		old := Item{CardId: 1, Box: tc.box}
+		n := l.update(old, review.ReviewItem{CardId: 1, Quality: review.IncorrectFamiliar}, now)
+		if n.Box != tc.want {
+			t.Errorf("\ngot box %d\nwant box %d", n.Box, tc.want)
+		}
+
+		wantDue := now.AddDate(0, 0, l.Intervals[tc.want-1]).Unix()
+		if n.Due != wantDue {
+			t.Errorf("\ngot due %d\nwant due %d", n.Due, wantDue)
+		}
+	}
+}
+
+func TestCustomIntervals(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	l := New(now)
+	l.Intervals = []int{2, 10}
+
+	n := l.create(review.ReviewItem{CardId: 1, Quality: review.CorrectEasy}, now)
+
+	want := Item{CardId: 1, Box: 2, Reps: 1, Due: now.AddDate(0, 0, 10).Unix()}
+	if n != want {
+		t.Errorf("\ngot %#v\nwant %#v", n, want)
+	}
+
+	// Last box is kept
+	n = l.update(n, review.ReviewItem{CardId: 1, Quality: review.CorrectEasy}, now)
+	want.Reps = 2
+	if n != want {
+		t.Errorf("\ngot %#v\nwant %#v", n, want)
+	}
+}
+
+func TestDue(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	l := New(now)
+
+	item, _ := l.Update(nil, review.ReviewItem{CardId: 7, Quality: review.NoReview})
+
+	if d := l.Due(item, now.AddDate(0, 0, 1)); d.CardId != 0 {
+		t.Errorf("\ngot %#v\nwant not due", d)
+	}
+
+	if d := l.Due(item, now.AddDate(0, 0, 1).Add(time.Second)); d.CardId != 7 {
+		t.Errorf("\ngot %#v\nwant card 7 due", d)
+	}
+}
+
+func TestUpdateDecodeError(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+
+	l := New(now)
+
+	r := review.ReviewItem{CardId: 1, Quality: review.NoReview}
+	b := []byte{'g', 'o', 'l', 'a', 'n', 'g'}
+	_, err := l.Update(b, r)
+
+	var e *json.SyntaxError
+	if !errors.As(err, &e) {
+		t.Errorf("\ngot error %s\nwant SyntaxError", err)
+	}
+}
+
+func TestSummary(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	l := New(now)
+
+	item, _ := l.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+
+	d, err := l.Summary(item)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	want := review.DueItem{CardId: 1, Due: now.AddDate(0, 0, 1), Interval: 24 * time.Hour, State: review.StateNew}
+	if d != want {
+		t.Errorf("\ngot %#v\nwant %#v", d, want)
+	}
+
+	// a failed first review keeps the card in the first box
+	item, _ = l.Update(item, review.ReviewItem{CardId: 1, Quality: review.IncorrectBlackout})
+
+	d, _ = l.Summary(item)
+	want = review.DueItem{CardId: 1, Due: now.AddDate(0, 0, 1), Interval: 24 * time.Hour, State: review.StateLearning}
+	if d != want {
+		t.Errorf("\ngot %#v\nwant %#v", d, want)
+	}
+
+	item, _ = l.Update(item, review.ReviewItem{CardId: 1, Quality: review.CorrectEasy})
+
+	d, _ = l.Summary(item)
+	want = review.DueItem{CardId: 1, Due: now.AddDate(0, 0, 2), Interval: 2 * 24 * time.Hour, State: review.StateReview}
+	if d != want {
+		t.Errorf("\ngot %#v\nwant %#v", d, want)
+	}
+}
+
+// TestIntervalsNotShared tests that the Intervals of a Leitner are not the
+// DefaultIntervals
+func TestIntervalsNotShared(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	l := New(now)
+	l.Intervals[0] = 3
+
+	if DefaultIntervals[0] != 1 || New(now).Intervals[0] != 1 {
+		t.Errorf("\ngot %v\nwant %v", DefaultIntervals, []int{1, 2, 4, 8, 16})
+	}
+}
@@ -48,6 +48,7 @@ import (
 	"math"
 	"time"
 
+	"github.com/revelaction/go-srs/clock"
 	"github.com/revelaction/go-srs/review"
 )
 
@@ -70,17 +71,30 @@ type Item struct {
 
 	ConsecutiveCorrectAnswers int
 
+	// Interval in days until Due. It is 0 for new cards without review and
+	// for Items stored before the field was added.
+	Interval int
+
 	// Unix timestamp
 	Due int64
 }
 
 type Sm2 struct {
-	// UTC
-	now time.Time
+	clock clock.Clock
 }
 
+// New returns a Sm2 with a clock fixed at now. Long-running processes should
+// use NewWithClock.
 func New(now time.Time) *Sm2 {
-	return &Sm2{now: now}
+	return NewWithClock(clock.NewFake(now))
+}
+
+// NewWithClock returns a Sm2 that asks c for the current time at each
+// Update.
+func NewWithClock(c clock.Clock) *Sm2 {
+	return &Sm2{
+		clock: c,
+	}
 }
 
 // Update takes a serialized representation of a Item, deserializes it and
@@ -98,9 +112,9 @@ func (s *Sm2) Update(oldItem []byte, r review.ReviewItem) ([]byte, error) {
 			return nil, err
 		}
 
-		newItem = update(decodedItem, r, s.now)
+		newItem = update(decodedItem, r, r.Time(s.clock.Now()))
 	} else {
-		newItem = create(r, s.now)
+		newItem = create(r, r.Time(s.clock.Now()))
 	}
 
 	encodedItem, err := encode(newItem)
@@ -119,7 +133,52 @@ func (s *Sm2) Due(item []byte, t time.Time) (d review.DueItem) {
 	}
 	if dec.Due < t.Unix() {
 		//gives unix time stamp in utc decItem.Due}
-		return review.DueItem{CardId: dec.CardId}
+		return summary(dec)
+	}
+
+	return d
+}
+
+// Summary returns the schedule of the serialized Item item.
+func (s *Sm2) Summary(item []byte) (review.DueItem, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return review.DueItem{}, err
+	}
+
+	return summary(dec), nil
+}
+
+// Ease returns the Easiness of the serialized Item item.
+func (s *Sm2) Ease(item []byte) (float64, error) {
+	dec, err := decode(item)
+	if err != nil {
+		return 0, err
+	}
+
+	return dec.Easiness, nil
+}
+
+// summary translates the Item to a review.DueItem. Cards with a correct last
+// review are in review. Otherwise cards without review are new and cards with
+// a failed last review are relearning.
+//
+// The state does not depend on Interval, that older Items do not have: a
+// failed review always changes the default Easiness of a new card.
+func summary(i Item) review.DueItem {
+	d := review.DueItem{
+		CardId:   i.CardId,
+		Due:      time.Unix(i.Due, 0).UTC(),
+		Interval: time.Duration(i.Interval) * 24 * time.Hour,
+		State:    review.StateReview,
+	}
+
+	switch {
+	case i.ConsecutiveCorrectAnswers > 0:
+	case i.Interval == 0 && i.Easiness == DefaultEasiness:
+		d.State = review.StateNew
+	default:
+		d.State = review.StateRelearning
 	}
 
 	return d
@@ -138,6 +197,7 @@ func create(r review.ReviewItem, now time.Time) Item {
 		// this is the first review for a new card
 		n.Easiness = easiness(DefaultEasiness, quality(r.Quality))
 		n.ConsecutiveCorrectAnswers = 1
+		n.Interval = 1
 	}
 
 	n.Due = now.AddDate(0, 0, 1).Unix()
@@ -177,11 +237,13 @@ func update(old Item, r review.ReviewItem, now time.Time) Item {
 	// old. wikipedia is correct here (increase days after)
 	if r.Quality >= review.CorrectHard {
 		days := float64(DueDateStartDays) * math.Pow(old.Easiness, float64(old.ConsecutiveCorrectAnswers-1))
-		n.Due = now.AddDate(0, 0, int(math.Round(days))).Unix()
+		n.Interval = int(math.Round(days))
 	} else {
-		n.Due = now.AddDate(0, 0, 1).Unix()
+		n.Interval = 1
 	}
 
+	n.Due = now.AddDate(0, 0, n.Interval).Unix()
+
 	// ConsecutiveCorrectAnswers
 	if r.Quality >= review.CorrectHard {
 		n.ConsecutiveCorrectAnswers = old.ConsecutiveCorrectAnswers + 1
@@ -192,6 +254,17 @@ func update(old Item, r review.ReviewItem, now time.Time) Item {
 	return n
 }
 
+// Encode serializes the Item as it is stored by the db handlers, for example
+// to write imported cards with db.Handler.PutCards.
+func Encode(item Item) ([]byte, error) {
+	return encode(item)
+}
+
+// Decode returns the Item of the serialized item.
+func Decode(item []byte) (Item, error) {
+	return decode(item)
+}
+
 // deserialize
 func decode(encodedItem []byte) (Item, error) {
 	res := Item{}
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/revelaction/go-srs/clock"
 	"github.com/revelaction/go-srs/review"
 )
 
@@ -25,7 +26,7 @@ func ExampleUpdateNoReviewDue() {
 	fmt.Printf("Due Item is %#v\n", dueItem)
 
 	//Output:
-	//Due Item is review.DueItem{CardId:1}
+	//Due Item is review.DueItem{CardId:1, Due:time.Date(2020, time.November, 2, 1, 0, 0, 0, time.UTC), Interval:0, State:0}
 }
 
 func ExampleDue() {
@@ -43,7 +44,7 @@ func ExampleDue() {
 	fmt.Printf("Item is %s\n", item)
 
 	//Output:
-	//Item is {"CardId":1,"Easiness":2.72,"ConsecutiveCorrectAnswers":1,"Due":1604365200}
+	//Item is {"CardId":1,"Easiness":2.72,"ConsecutiveCorrectAnswers":1,"Interval":2,"Due":1604365200}
 }
 
 func ExampleAllCorrectHard() {
@@ -250,6 +251,7 @@ func TestNewCardWithReviewQualityBlackout(t *testing.T) {
 		CardId:                    1,
 		Easiness:                  1.7,
 		ConsecutiveCorrectAnswers: 1,
+		Interval:                  1,
 		Due:                       now.AddDate(0, 0, 1).Unix(),
 	}
 
@@ -288,3 +290,144 @@ func floatEqual(a, b float64) bool {
 
 	return percent <= precision
 }
+
+func TestUpdateWithClock(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+
+	sm2 := NewWithClock(c)
+
+	item, err := sm2.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// the same Sm2 uses the new time of the clock
+	c.Advance(24 * time.Hour)
+
+	item, err = sm2.Update(item, review.ReviewItem{CardId: 1, Quality: review.IncorrectBlackout})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	dec, _ := decode(item)
+	want := now.AddDate(0, 0, 2).Unix()
+	if dec.Due != want {
+		t.Errorf("\ngot due %d\nwant due %d", dec.Due, want)
+	}
+}
+
+func TestUpdateReviewedAt(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	sm2 := New(now)
+
+	// review made offline 5 hours ago
+	reviewedAt := now.Add(-5 * time.Hour)
+	r := review.ReviewItem{CardId: 1, Quality: review.IncorrectBlackout, ReviewedAt: reviewedAt}
+
+	item, err := sm2.Update(nil, r)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	dec, _ := decode(item)
+	want := reviewedAt.AddDate(0, 0, 1).Unix()
+	if dec.Due != want {
+		t.Errorf("\ngot due %d\nwant due %d", dec.Due, want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC)
+	sm2 := New(now)
+
+	item, _ := sm2.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+
+	d, err := sm2.Summary(item)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if d.Interval != 0 || d.State != review.StateNew {
+		t.Errorf("\ngot interval %s state %s\nwant interval 0s state new", d.Interval, d.State)
+	}
+
+	tests := []struct {
+		quality  review.Quality
+		interval time.Duration
+		state    review.State
+	}{
+		{quality: review.CorrectEasy, interval: 2 * 24 * time.Hour, state: review.StateReview},
+		{quality: review.IncorrectBlackout, interval: 24 * time.Hour, state: review.StateRelearning},
+	}
+
+	for _, tc := range tests {
+		item, _ = sm2.Update(item, review.ReviewItem{CardId: 1, Quality: tc.quality})
+
+		d, err := sm2.Summary(item)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if d.Interval != tc.interval || d.State != tc.state {
+			t.Errorf("\ngot interval %s state %s\nwant interval %s state %s", d.Interval, d.State, tc.interval, tc.state)
+		}
+
+		if !d.Due.Equal(now.Add(d.Interval)) {
+			t.Errorf("\ngot due %s\nwant due %s", d.Due, now.Add(d.Interval))
+		}
+	}
+}
+
+// TestSummaryWithoutInterval tests the state of Items stored before Interval
+// was added
+func TestSummaryWithoutInterval(t *testing.T) {
+
+	tests := []struct {
+		item string
+		want review.State
+	}{
+		{item: `{"CardId":1,"Easiness":2.5,"ConsecutiveCorrectAnswers":0,"Due":1604278800}`, want: review.StateNew},
+		{item: `{"CardId":1,"Easiness":2.6,"ConsecutiveCorrectAnswers":1,"Due":1604278800}`, want: review.StateReview},
+		{item: `{"CardId":1,"Easiness":2.36,"ConsecutiveCorrectAnswers":3,"Due":1604278800}`, want: review.StateReview},
+		{item: `{"CardId":1,"Easiness":1.7,"ConsecutiveCorrectAnswers":0,"Due":1604278800}`, want: review.StateRelearning},
+	}
+
+	sm2 := New(time.Date(2020, time.November, 1, 1, 0, 0, 0, time.UTC))
+
+	for _, tc := range tests {
+		d, err := sm2.Summary([]byte(tc.item))
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if d.State != tc.want {
+			t.Errorf("\n%s\ngot state %s\nwant %s", tc.item, d.State, tc.want)
+		}
+	}
+}
+
+func TestEase(t *testing.T) {
+	sm := New(time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC))
+
+	item, _ := sm.Update(nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+	item, _ = sm.Update(item, review.ReviewItem{CardId: 1, Quality: review.IncorrectBlackout})
+
+	dec, _ := decode(item)
+
+	ease, err := sm.Ease(item)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if ease != dec.Easiness || ease >= DefaultEasiness {
+		t.Errorf("\ngot %#v\nwant %#v", ease, dec.Easiness)
+	}
+
+	if _, err := sm.Ease([]byte("{")); err == nil {
+		t.Errorf("got no error for an invalid item")
+	}
+}
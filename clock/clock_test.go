@@ -0,0 +1,37 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/clock"
+)
+
+func TestFake(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+
+	if got := c.Now(); !got.Equal(now) {
+		t.Errorf("\ngot %s\nwant %s", got, now)
+	}
+
+	c.Advance(36 * time.Hour)
+	want := now.Add(36 * time.Hour)
+	if got := c.Now(); !got.Equal(want) {
+		t.Errorf("\ngot %s\nwant %s", got, want)
+	}
+
+	c.Set(now)
+	if got := c.Now(); !got.Equal(now) {
+		t.Errorf("\ngot %s\nwant %s", got, now)
+	}
+}
+
+func TestRealIsUTC(t *testing.T) {
+
+	var c clock.Clock = clock.Real{}
+	if loc := c.Now().Location(); loc != time.UTC {
+		t.Errorf("\ngot location %s\nwant UTC", loc)
+	}
+}
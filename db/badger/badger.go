@@ -2,13 +2,15 @@
 package badger
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"errors"
 	badger "github.com/outcaste-io/badger/v3"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/clock"
 	"github.com/revelaction/go-srs/db"
 	"github.com/revelaction/go-srs/review"
 )
@@ -19,168 +21,1206 @@ import (
 // (for example Update must read from the db, decode, compute new values
 // according to the review, and write back to db)
 type Handler struct {
-	Db   *badger.DB
-	Algo algo.Algo
+	Db    *badger.DB
+	Algo  algo.Algo
+	Clock clock.Clock
+
+	// UndoDepth is the number of Update calls per deck that can be undone.
+	// Zero disables Undo.
+	UndoDepth int
+
+	// err is the error of the schema migration of NewWithClock, returned by
+	// every operation
+	err error
+}
+
+// ErrDeckDeleting is returned by the writes to a deck whose DeleteDeck did not
+// finish. DeleteDeck can be called again to finish it.
+var ErrDeckDeleting = errors.New("deck is being deleted")
+
+// DefaultUndoDepth is the UndoDepth of new Handlers
+const DefaultUndoDepth = 10
+
+// New returns a Handler with the system clock
+func New(db *badger.DB, algo algo.Algo) *Handler {
+	return NewWithClock(db, algo, clock.Real{})
+}
+
+// NewWithClock returns a Handler that asks c for the current time. The
+// algo should share the same clock.
+//
+// The version is written in a new empty db, and databases written by older
+// versions are migrated to the current SchemaVersion. If that fails, every
+// operation of the Handler returns the error, for example ErrSchemaVersion for
+// a db of a newer version. Open returns it.
+func NewWithClock(db *badger.DB, algo algo.Algo, c clock.Clock) *Handler {
+	h := &Handler{
+		Db:        db,
+		Algo:      algo,
+		Clock:     c,
+		UndoDepth: DefaultUndoDepth,
+	}
+
+	h.err = h.initSchema()
+	if h.err == nil {
+		h.err = h.Migrate()
+	}
+
+	return h
+}
+
+// Insert runs the Algo on a all cards of a Review, and saves the result in the
+// db.  It returns a slice of review Due structs containing the Due Date,
+// interval and state for each of the card ids. There are two cases:
+//
+// 1) New cards for existing DeckId: this requires a lookup of the last CardId in the db
+// 2) New cards for new DeckId created in this session, index starts at 0.
+//
+// The undo snapshots of the deck are cleared.
+//
+// Insert is atomic
+func (h *Handler) Insert(r review.Review, deckId string) (res review.Due, err error) {
+	return h.InsertContext(context.Background(), r, deckId)
+}
+
+// InsertContext is Insert with a context. The transaction is discarded if ctx
+// is done before the commit.
+func (h *Handler) InsertContext(ctx context.Context, r review.Review, deckId string) (res review.Due, err error) {
+	if h.err != nil {
+		return review.Due{}, h.err
+	}
+
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	var maxCardIdInDb int
+
+	if deckId != "" {
+		// New deck
+		r.DeckId = deckId
+		if !db.ValidDeckId(r.DeckId) {
+			return res, db.ErrInvalidDeckId
+		}
+
+		// a deck id created outside could already exist
+		maxCardIdInDb, err = findMaxCardId(txn, r.DeckId)
+		if err != nil && err != db.ErrDeckIdNotExists {
+			return res, err
+		}
+	} else {
+		// Existent deckId
+		// Look up the last card id and insert after
+		maxCardIdInDb, err = findMaxCardId(txn, r.DeckId)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	res, err = h.insertAfter(ctx, txn, maxCardIdInDb, r)
+	if err != nil {
+		return res, err
+	}
+
+	err = writeDeckMeta(txn, r.DeckId, deckMeta{MaxCardId: maxCardIdInDb + len(r.Items)})
+	if err != nil {
+		return res, err
+	}
+
+	if err := clearUndo(txn, r.DeckId); err != nil {
+		return res, err
+	}
+
+	// Commit the transaction and check for error.
+	if err := commit(ctx, txn); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// Update looks up in the db the (must) existing cards in the review r, run the
+// srs algo on them, and saves the updated result in the db.  It returns a
+// slice of reviews containing the Due Date, interval and state for each of
+// the card ids.
+//
+// The function is atomic
+func (h *Handler) Update(r review.Review) (due review.Due, err error) {
+	return h.UpdateContext(context.Background(), r)
+}
+
+// UpdateContext is Update with a context. The transaction is discarded if ctx
+// is done before the commit.
+func (h *Handler) UpdateContext(ctx context.Context, r review.Review) (review.Due, error) {
+	due, _, err := h.UpdateLeechesContext(ctx, r, db.LeechRule{})
+	return due, err
+}
+
+// UpdateLeeches is Update that also marks as leech the updated cards that
+// reach the threshold of rule, and returns them.
+//
+// The function is atomic
+func (h *Handler) UpdateLeeches(r review.Review, rule db.LeechRule) (review.Due, []int, error) {
+	return h.UpdateLeechesContext(context.Background(), r, rule)
+}
+
+// UpdateLeechesContext is UpdateLeeches with a context.
+func (h *Handler) UpdateLeechesContext(ctx context.Context, r review.Review, rule db.LeechRule) (due review.Due, leeches []int, err error) {
+	if h.err != nil {
+		return review.Due{}, nil, h.err
+	}
+
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	due, snap, err := h.updateBetween(ctx, txn, r)
+	if err != nil {
+		return due, nil, err
+	}
+
+	leeches, err = markLeeches(txn, r.DeckId, snap, rule)
+	if err != nil {
+		return due, nil, err
+	}
+
+	if err := h.pushSnapshot(txn, r.DeckId, snap); err != nil {
+		return due, nil, err
+	}
+
+	if err := commit(ctx, txn); err != nil {
+		return due, nil, err
+	}
+
+	return due, leeches, nil
+}
+
+// Due returs th Due cards for the time t, most overdue first. A zero t means
+// the current time of the Handler Clock.
+func (h *Handler) Due(deckId string, t time.Time) (due review.Due, err error) {
+	return h.DueQueryContext(context.Background(), db.Query{DeckId: deckId, T: t})
+}
+
+// DueContext is Due with a context.
+func (h *Handler) DueContext(ctx context.Context, deckId string, t time.Time) (due review.Due, err error) {
+	return h.DueQueryContext(ctx, db.Query{DeckId: deckId, T: t})
+}
+
+// DueQuery returns the page of due cards of the query q.
+//
+// The cards are looked up in the due index, ordered by due time, so only the
+// due cards are read and decoded. Queries in OrderOverdue stop reading at the
+// end of the page.
+func (h *Handler) DueQuery(q db.Query) (due review.Due, err error) {
+	return h.DueQueryContext(context.Background(), q)
+}
+
+// DueQueryContext is DueQuery with a context. The scan of the due index stops
+// when ctx is done.
+func (h *Handler) DueQueryContext(ctx context.Context, q db.Query) (due review.Due, err error) {
+	if h.err != nil {
+		return review.Due{}, h.err
+	}
+
+	due.DeckId = q.DeckId
+
+	t := q.T
+	if t.IsZero() {
+		t = h.Clock.Now()
+	}
+
+	// create transaction
+	txn := h.Db.NewTransaction(false)
+	defer txn.Discard()
+
+	// iterate for the index prefix, until the first not due key
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := deckPrefix(nsDue, q.DeckId)
+
+	// the index is in overdue order
+	paged := q.Order == db.OrderOverdue
+	skipped := 0
+
+	var items []review.DueItem
+	recall := map[int]float64{}
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return due, err
+		}
+
+		dueUnix, cardId, err := parseDueKey(it.Item().Key(), prefix)
+		if err != nil {
+			return due, err
+		}
+
+		if dueUnix >= t.Unix() {
+			break
+		}
+
+		flags, err := readFlags(txn, q.DeckId, cardId)
+		if err != nil {
+			return due, err
+		}
+
+		if flags.hidden(t) {
+			continue
+		}
+
+		if paged && skipped < q.Offset {
+			skipped++
+			continue
+		}
+
+		if paged && q.Limit > 0 && len(items) == q.Limit {
+			break
+		}
+
+		v, err := h.card(txn, q.DeckId, cardId)
+		if err != nil {
+			return due, err
+		}
+
+		dueItem, err := h.Algo.Summary(v)
+		if err != nil {
+			return due, err
+		}
+
+		if q.Order == db.OrderRetrievability {
+			recall[cardId], err = algo.Recall(h.Algo, v, t)
+			if err != nil {
+				return due, err
+			}
+		}
+
+		items = append(items, dueItem)
+	}
+
+	if paged {
+		due.Items = items
+		return due, nil
+	}
+
+	q.Sort(items, recall)
+	due.Items = q.Page(items)
+
+	return due, nil
+}
+
+// DueTimes returns the times the cards of the deck due in [from, to) are
+// shown. A zero from means the start of the deck.
+//
+// Only the range of the due index and the flags of its cards are read.
+func (h *Handler) DueTimes(deckId string, from, to time.Time) ([]time.Time, error) {
+	return h.DueTimesContext(context.Background(), deckId, from, to)
+}
+
+// DueTimesContext is DueTimes with a context. The scan of the due index stops
+// when ctx is done.
+func (h *Handler) DueTimesContext(ctx context.Context, deckId string, from, to time.Time) ([]time.Time, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	txn := h.Db.NewTransaction(false)
+	defer txn.Discard()
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := deckPrefix(nsDue, deckId)
+
+	seek := prefix
+	if !from.IsZero() {
+		seek = dueKey(deckId, from, 0)
+	}
+
+	times := []time.Time{}
+	for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		dueUnix, cardId, err := parseDueKey(it.Item().Key(), prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		if dueUnix >= to.Unix() {
+			break
+		}
+
+		flags, err := readFlags(txn, deckId, cardId)
+		if err != nil {
+			return nil, err
+		}
+
+		shown, ok := flags.shownAt(dueUnix)
+		if !ok || shown >= to.Unix() {
+			continue
+		}
+
+		times = append(times, time.Unix(shown, 0).UTC())
+	}
+
+	db.SortTimes(times)
+	return times, nil
+}
+
+// DeleteCards deletes the cards of the deck and their due index keys. All the
+// cards must exist. The review log of the cards is kept.
+//
+// DeleteCards is atomic
+func (h *Handler) DeleteCards(deckId string, cardIds []int) error {
+	return h.DeleteCardsContext(context.Background(), deckId, cardIds)
+}
+
+// DeleteCardsContext is DeleteCards with a context. The transaction is
+// discarded if ctx is done before the commit.
+func (h *Handler) DeleteCardsContext(ctx context.Context, deckId string, cardIds []int) error {
+	if h.err != nil {
+		return h.err
+	}
+
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	if err := checkDeck(txn, deckId); err != nil {
+		return err
+	}
+
+	for _, cardId := range cardIds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		v, err := h.card(txn, deckId, cardId)
+		if err == badger.ErrKeyNotFound {
+			return db.ErrCardIdNotExists
+		}
+
+		if err != nil {
+			return err
+		}
+
+		dueItem, err := h.Algo.Summary(v)
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(cardKey(deckId, cardId)); err != nil {
+			return err
+		}
+
+		if err := txn.Delete(dueKey(deckId, dueItem.Due, cardId)); err != nil {
+			return err
+		}
+
+		if err := txn.Delete(flagsKey(deckId, cardId)); err != nil {
+			return err
+		}
+
+		if err := txn.Delete(lapsesKey(deckId, cardId)); err != nil {
+			return err
+		}
+	}
+
+	return commit(ctx, txn)
+}
+
+// DeleteDeck deletes all the cards of the deck, their flags, lapses and review
+// log, its due index, undo snapshots and meta.
+//
+// The deck meta is first marked as deleted, in a transaction that conflicts
+// with the writes to the deck running at the same time. From then on the
+// writes to the deck return ErrDeckDeleting, so no key of the deck is added
+// while the keys are deleted, in as many transactions as needed for the size
+// of the deck. The deck meta is deleted last. A DeleteDeck that fails can be
+// called again.
+func (h *Handler) DeleteDeck(deckId string) error {
+	return h.DeleteDeckContext(context.Background(), deckId)
+}
+
+// DeleteDeckContext is DeleteDeck with a context. The deck is kept if ctx is
+// done before it is marked as deleted. Later, the deletion stops and the deck
+// stays marked.
+func (h *Handler) DeleteDeckContext(ctx context.Context, deckId string) error {
+	if h.err != nil {
+		return h.err
+	}
+
+	if err := h.markDeleted(ctx, deckId); err != nil {
+		return err
+	}
+
+	for _, ns := range []byte{nsCard, nsDue, nsFlags, nsLapses, nsLog, nsUndo} {
+		if err := h.deletePrefix(ctx, deckPrefix(ns, deckId)); err != nil {
+			return err
+		}
+	}
+
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	if err := txn.Delete(deckKey(deckId)); err != nil {
+		return err
+	}
+
+	return commit(ctx, txn)
+}
+
+// markDeleted sets Deleted in the deck meta. A deck already marked is not an
+// error, so an interrupted DeleteDeck can run again.
+func (h *Handler) markDeleted(ctx context.Context, deckId string) error {
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	meta, err := readDeckMeta(txn, deckId)
+	if err == ErrDeckDeleting {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	meta.Deleted = true
+	if err := writeDeckMeta(txn, deckId, meta); err != nil {
+		return err
+	}
+
+	return commit(ctx, txn)
+}
+
+// Suspend hides the cards from the due queries until Unsuspend. The cards
+// keep their schedule.
+//
+// Suspend is atomic
+func (h *Handler) Suspend(deckId string, cardIds []int) error {
+	return h.SuspendContext(context.Background(), deckId, cardIds)
+}
+
+// SuspendContext is Suspend with a context. The transaction is discarded if
+// ctx is done before the commit.
+func (h *Handler) SuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	if h.err != nil {
+		return h.err
+	}
+
+	return h.updateFlags(ctx, deckId, cardIds, func(f *cardFlags) {
+		f.Suspended = true
+	})
+}
+
+// Unsuspend shows again suspended or buried cards in the due queries.
+//
+// Unsuspend is atomic
+func (h *Handler) Unsuspend(deckId string, cardIds []int) error {
+	return h.UnsuspendContext(context.Background(), deckId, cardIds)
+}
+
+// UnsuspendContext is Unsuspend with a context. The transaction is discarded
+// if ctx is done before the commit.
+func (h *Handler) UnsuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	if h.err != nil {
+		return h.err
+	}
+
+	return h.updateFlags(ctx, deckId, cardIds, func(f *cardFlags) {
+		*f = cardFlags{}
+	})
+}
+
+// Bury hides the cards from the due queries until the time until. A zero
+// until means the start of the next day of the Handler Clock.
+//
+// Bury is atomic
+func (h *Handler) Bury(deckId string, cardIds []int, until time.Time) error {
+	return h.BuryContext(context.Background(), deckId, cardIds, until)
+}
+
+// BuryContext is Bury with a context. The transaction is discarded if ctx is
+// done before the commit.
+func (h *Handler) BuryContext(ctx context.Context, deckId string, cardIds []int, until time.Time) error {
+	if h.err != nil {
+		return h.err
+	}
+
+	if until.IsZero() {
+		now := h.Clock.Now()
+		until = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	}
+
+	return h.updateFlags(ctx, deckId, cardIds, func(f *cardFlags) {
+		f.BuriedUntil = until.Unix()
+	})
+}
+
+// updateFlags applies fn to the flags of the existing cards
+func (h *Handler) updateFlags(ctx context.Context, deckId string, cardIds []int, fn func(f *cardFlags)) error {
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	if err := checkDeck(txn, deckId); err != nil {
+		return err
+	}
+
+	for _, cardId := range cardIds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, err := txn.Get(cardKey(deckId, cardId))
+		if err == badger.ErrKeyNotFound {
+			return db.ErrCardIdNotExists
+		}
+
+		if err != nil {
+			return err
+		}
+
+		flags, err := readFlags(txn, deckId, cardId)
+		if err != nil {
+			return err
+		}
+
+		fn(&flags)
+
+		if err := writeFlags(txn, deckId, cardId, flags); err != nil {
+			return err
+		}
+	}
+
+	return commit(ctx, txn)
+}
+
+// CardLog returns the review log of the card, in review order.
+func (h *Handler) CardLog(deckId string, cardId int) ([]db.LogEntry, error) {
+	return h.CardLogContext(context.Background(), deckId, cardId)
+}
+
+// CardLogContext is CardLog with a context.
+func (h *Handler) CardLogContext(ctx context.Context, deckId string, cardId int) ([]db.LogEntry, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	txn := h.Db.NewTransaction(false)
+	defer txn.Discard()
+
+	entries, err := readLog(ctx, txn, logPrefix(deckId, cardId))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SortLog(entries)
+	return entries, nil
+}
+
+// DeckLog returns the review log of all the cards of the deck, in review
+// order.
+func (h *Handler) DeckLog(deckId string) ([]db.LogEntry, error) {
+	return h.DeckLogContext(context.Background(), deckId)
+}
+
+// DeckLogContext is DeckLog with a context. The scan of the log stops when
+// ctx is done.
+func (h *Handler) DeckLogContext(ctx context.Context, deckId string) ([]db.LogEntry, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	txn := h.Db.NewTransaction(false)
+	defer txn.Discard()
+
+	entries, err := readLog(ctx, txn, deckPrefix(nsLog, deckId))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SortLog(entries)
+	return entries, nil
+}
+
+// DeckLogRange returns the entries of the review log of the deck reviewed in
+// the range [from, to), a zero from being the start of the log, in review
+// order. The log has no time index: the whole log of the deck
+// is read.
+func (h *Handler) DeckLogRange(deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	return h.DeckLogRangeContext(context.Background(), deckId, from, to)
+}
+
+// DeckLogRangeContext is DeckLogRange with a context.
+func (h *Handler) DeckLogRangeContext(ctx context.Context, deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	entries, err := h.DeckLogContext(ctx, deckId)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.LogRange(entries, from, to), nil
 }
 
-func New(db *badger.DB, algo algo.Algo) *Handler {
-	return &Handler{
-		Db:   db,
-		Algo: algo,
+// Cards returns the stored state of all the cards of the deck, in card id
+// order.
+func (h *Handler) Cards(deckId string) ([]db.Card, error) {
+	return h.CardsContext(context.Background(), deckId)
+}
+
+// CardsContext is Cards with a context. The scan of the cards stops when ctx
+// is done.
+func (h *Handler) CardsContext(ctx context.Context, deckId string) ([]db.Card, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	txn := h.Db.NewTransaction(false)
+	defer txn.Discard()
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	prefix := deckPrefix(nsCard, deckId)
+
+	cards := []db.Card{}
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		item := it.Item()
+
+		_, cardId, err := parseCardKey(item.Key())
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		dueItem, err := h.Algo.Summary(v)
+		if err != nil {
+			return nil, err
+		}
+
+		flags, err := readFlags(txn, deckId, cardId)
+		if err != nil {
+			return nil, err
+		}
+
+		lapses, err := readLapses(txn, deckId, cardId)
+		if err != nil {
+			return nil, err
+		}
+
+		c := db.Card{DueItem: dueItem, Item: v, Suspended: flags.Suspended, Lapses: lapses}
+		if flags.BuriedUntil != 0 {
+			c.BuriedUntil = time.Unix(flags.BuriedUntil, 0).UTC()
+		}
+
+		cards = append(cards, c)
 	}
+
+	return cards, nil
 }
 
-// Insert runs the Algo on a all cards of a Review, and saves the result in the
-// db.  It returns a slice of review Due structs containing the Due Date for
-// each of the card ids. There are two cases:
+// PutCards writes the cards in the deck, creating it if it does not exist.
+// Cards with the same ids are replaced.
 //
-// 1) New cards for existing DeckId: this requires a lookup of the last CardId in the db
-// 2) New cards for new DeckId created in this session, which do no require
-// lookup, index starts at 0.
-//
-// Insert is atomic
-func (h *Handler) Insert(r review.Review, deckId string) (res review.Due, err error) {
-	txn := h.Db.NewTransaction(true)
-	defer txn.Discard()
+// The cards are written in as many transactions as needed for their size,
+// each with all the keys of its cards and the deck meta, so every card is
+// written atomically. A PutCards that fails can be called again.
+func (h *Handler) PutCards(deckId string, cards []db.Card) error {
+	return h.PutCardsContext(context.Background(), deckId, cards)
+}
 
-	// New deck
-	if deckId != "" {
-		r.DeckId = deckId
-		res, err = h.insertAfter(txn, 0, r)
+// PutCardsContext is PutCards with a context. The writing stops when ctx is
+// done, and the cards of the committed transactions are kept.
+func (h *Handler) PutCardsContext(ctx context.Context, deckId string, cards []db.Card) error {
+	if h.err != nil {
+		return h.err
+	}
+
+	if !db.ValidDeckId(deckId) {
+		return db.ErrInvalidDeckId
+	}
+
+	// all the cards are checked before the first commit
+	items := make([]review.DueItem, len(cards))
+	for i, c := range cards {
+		dueItem, err := h.Algo.Summary(c.Item)
 		if err != nil {
-			return res, err
+			return err
 		}
 
-		if err := txn.Commit(); err != nil {
-			return res, err
+		if c.CardId < 1 || dueItem.CardId != c.CardId {
+			return db.ErrInvalidCardId
 		}
 
-		return res, nil
+		items[i] = dueItem
 	}
 
-	// Existent deckId
-	// Look up the last card id and insert after
-	var maxCardIdInDb int
+	for len(cards) > 0 {
+		size := len(cards)
+		for {
+			n, err := h.putBatch(ctx, deckId, cards[:size], items[:size])
+			if err != badger.ErrTxnTooBig {
+				if err != nil {
+					return err
+				}
 
-	maxCardIdInDb, err = findMaxCardId(txn, r.DeckId)
-	if err != nil {
-		return res, err
+				break
+			}
+
+			// the batch is written again with the cards that fit, without
+			// the partial keys of the last one. The deck meta did not fit if
+			// all the cards did.
+			if n == size {
+				n--
+			}
+
+			if n == 0 {
+				return err
+			}
+
+			size = n
+		}
+
+		cards, items = cards[size:], items[size:]
+	}
+
+	return nil
+}
+
+// PutLog appends the entries to the review log of the deck, and clears its
+// undo snapshots. The cards are not changed.
+//
+// The entries are written in as many transactions as needed for their size,
+// the first one also clearing the snapshots. A PutLog that fails can leave
+// part of the entries in the log.
+func (h *Handler) PutLog(deckId string, entries []db.LogEntry) error {
+	return h.PutLogContext(context.Background(), deckId, entries)
+}
+
+// PutLogContext is PutLog with a context. The current transaction is
+// discarded if ctx is done before its commit.
+func (h *Handler) PutLogContext(ctx context.Context, deckId string, entries []db.LogEntry) error {
+	if h.err != nil {
+		return h.err
 	}
 
-	res, err = h.insertAfter(txn, maxCardIdInDb, r)
+	// all the entries are checked before the first commit
+	txn := h.Db.NewTransaction(false)
+	maxCardId, err := findMaxCardId(txn, deckId)
+	txn.Discard()
+
 	if err != nil {
-		return res, err
+		return err
 	}
 
-	// Commit the transaction and check for error.
-	if err := txn.Commit(); err != nil {
-		return res, err
+	for _, e := range entries {
+		if e.CardId < 1 || e.CardId > maxCardId {
+			return db.ErrCardIdNotExists
+		}
 	}
 
-	return res, nil
+	for first := true; first || len(entries) > 0; first = false {
+		n, err := h.putLogBatch(ctx, deckId, entries, first)
+		if err != nil {
+			return err
+		}
+
+		entries = entries[n:]
+	}
+
+	return nil
 }
 
-// Update looks up in the db the (must) existing cards in the review r, run the
-// srs algo on them, and saves the updated result in the db.  It returns a
-// slice of reviews containing the Due Date for each of the card ids.
-//
-// The function is atomic
-func (h *Handler) Update(r review.Review) (due review.Due, err error) {
+// putLogBatch appends the entries that fit in one transaction, and returns
+// their number. The first batch also clears the undo snapshots of the deck.
+func (h *Handler) putLogBatch(ctx context.Context, deckId string, entries []db.LogEntry, first bool) (int, error) {
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	// the deck meta is read so that the transaction conflicts with DeleteDeck
+	if _, err := findMaxCardId(txn, deckId); err != nil {
+		return 0, err
+	}
+
+	if first {
+		if err := clearUndo(txn, deckId); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		e.DeckId = deckId
 
+		// each entry is a single key: the transaction is committed without
+		// the entry that did not fit
+		_, err := appendLog(txn, e)
+		if err == badger.ErrTxnTooBig && n > 0 {
+			break
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		n++
+	}
+
+	return n, commit(ctx, txn)
+}
+
+// putBatch writes the cards, with their summaries items, and the deck meta in
+// one transaction. If the transaction is too big, it is discarded and the
+// number of cards written before is returned with badger.ErrTxnTooBig.
+func (h *Handler) putBatch(ctx context.Context, deckId string, cards []db.Card, items []review.DueItem) (int, error) {
 	txn := h.Db.NewTransaction(true)
 	defer txn.Discard()
 
-	due, err = h.updateBetween(txn, r)
-	if err != nil {
-		return due, err
+	maxCardId, err := findMaxCardId(txn, deckId)
+	if err != nil && err != db.ErrDeckIdNotExists {
+		return 0, err
+	}
+
+	meta := deckMeta{MaxCardId: maxCardId}
+
+	for i, c := range cards {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+
+		if err := h.putCard(txn, deckId, c, items[i]); err != nil {
+			return i, err
+		}
+
+		if c.CardId > meta.MaxCardId {
+			meta.MaxCardId = c.CardId
+		}
 	}
 
-	if err := txn.Commit(); err != nil {
-		return due, err
+	if err := writeDeckMeta(txn, deckId, meta); err != nil {
+		return len(cards), err
 	}
 
-	return due, nil
+	return len(cards), commit(ctx, txn)
 }
 
-// Due returs th Due cards for the time t
-func (h *Handler) Due(deckId string, t time.Time) (due review.Due, err error) {
+// putCard writes the card, its due index key, flags and lapses, and removes
+// the due index key of the replaced card
+func (h *Handler) putCard(txn *badger.Txn, deckId string, c db.Card, dueItem review.DueItem) error {
+	old, err := h.card(txn, deckId, c.CardId)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	if err == nil {
+		oldDueItem, err := h.Algo.Summary(old)
+		if err != nil {
+			return err
+		}
 
-	due.DeckId = deckId
+		if err := txn.Delete(dueKey(deckId, oldDueItem.Due, c.CardId)); err != nil {
+			return err
+		}
+	}
 
-	// create transaction
-	txn := h.Db.NewTransaction(true)
+	if err := txn.Set(cardKey(deckId, c.CardId), c.Item); err != nil {
+		return err
+	}
+
+	if err := txn.Set(dueKey(deckId, dueItem.Due, c.CardId), nil); err != nil {
+		return err
+	}
+
+	flags := cardFlags{Suspended: c.Suspended}
+	if !c.BuriedUntil.IsZero() {
+		flags.BuriedUntil = c.BuriedUntil.Unix()
+	}
+
+	if err := writeFlags(txn, deckId, c.CardId, flags); err != nil {
+		return err
+	}
+
+	return writeLapses(txn, deckId, c.CardId, c.Lapses)
+}
+
+// Reindex rebuilds the due index of all decks from the card keys. The index
+// keys are committed whenever they do not fit in a transaction.
+func (h *Handler) Reindex() error {
+	if h.err != nil {
+		return h.err
+	}
+
+	// delete the current index
+	err := h.Db.DropPrefix(namespacePrefix(nsDue))
+	if err != nil {
+		return err
+	}
+
+	txn := h.Db.NewTransaction(false)
 	defer txn.Discard()
 
-	// iterate for the prefix
+	wb := h.Db.NewWriteBatch()
+	defer wb.Cancel()
+
 	opts := badger.DefaultIteratorOptions
 	it := txn.NewIterator(opts)
 	defer it.Close()
 
-	prefixDeckId := []byte(deckId)
+	prefix := namespacePrefix(nsCard)
 
-	for it.Seek(prefixDeckId); it.ValidForPrefix(prefixDeckId); it.Next() {
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 		item := it.Item()
-		err := item.Value(func(v []byte) error {
 
-			// This func with val would only be called if item.Value encounters no error.
-			dueItem := h.Algo.Due(v, t)
-			if dueItem.CardId > 0 {
-				due.Items = append(due.Items, dueItem)
-			}
+		deckId, cardId, err := parseCardKey(item.Key())
+		if err != nil {
+			return err
+		}
 
-			return nil
-		})
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
 
+		dueItem, err := h.Algo.Summary(v)
 		if err != nil {
-			return due, err
+			return err
+		}
+
+		if err := wb.Set(dueKey(deckId, dueItem.Due, cardId), nil); err != nil {
+			return err
 		}
 	}
 
-	return due, nil
+	return wb.Flush()
 }
 
-func findMaxCardId(txn *badger.Txn, deckId string) (int, error) {
+// card reads the serialized algo parameters of the card
+func (h *Handler) card(txn *badger.Txn, deckId string, cardId int) ([]byte, error) {
+	v, err := txn.Get(cardKey(deckId, cardId))
+	if err != nil {
+		return nil, err
+	}
+
+	return v.ValueCopy(nil)
+}
+
+// commit commits the transaction if ctx is not done
+func commit(ctx context.Context, txn *badger.Txn) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// deletePrefix deletes all the keys with the prefix. The deletes are
+// committed whenever they do not fit in a transaction.
+func (h *Handler) deletePrefix(ctx context.Context, prefix []byte) error {
+	txn := h.Db.NewTransaction(false)
+	defer txn.Discard()
+
+	wb := h.Db.NewWriteBatch()
+	defer wb.Cancel()
 
 	opts := badger.DefaultIteratorOptions
-	opts.Reverse = true
 	opts.PrefetchValues = false
 	it := txn.NewIterator(opts)
 	defer it.Close()
 
-	var valCopy []byte
-	prefixDeckId := []byte(deckId)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := wb.Delete(it.Item().KeyCopy(nil)); err != nil {
+			return err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return wb.Flush()
+}
+
+// appendLog saves the entry after the last log entry of its card, and
+// returns its sequence number.
+func appendLog(txn *badger.Txn, entry db.LogEntry) (int, error) {
+	seq, err := lastSeq(txn, logPrefix(entry.DeckId, entry.CardId))
+	if err != nil {
+		return 0, err
+	}
+
+	seq++
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	return seq, txn.Set(logKey(entry.DeckId, entry.CardId, seq), b)
+}
+
+// lastSeq returns the sequence number of the last key with the prefix, or 0
+// if there is none. The keys are the prefix followed by the sequence number.
+func lastSeq(txn *badger.Txn, prefix []byte) (int, error) {
 
-	// Reverse seek a prefix
-	//
-	// https://discuss.dgraph.io/t/reverse-seek-prefix-not-working-as-expected/8635/4
+	// Reverse seek a prefix, see
 	// https://github.com/dgraph-io/badger/issues/436
-	// https://github.com/dgraph-io/badger/issues/347
-	// https://dgraph.io/docs/badger/faq/
-	prefixSeek := []byte(deckId + "1000000")
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = true
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+
+	// only one iterator can be open in a read-write transaction
+	defer it.Close()
+
+	it.Seek(append(append([]byte{}, prefix...), 0xff))
+	if !it.ValidForPrefix(prefix) {
+		return 0, nil
+	}
 
-	it.Seek(prefixSeek)
-	if !it.ValidForPrefix(prefixDeckId) {
-		return 0, db.ErrDeckIdNotExists
+	return strconv.Atoi(string(it.Item().Key()[len(prefix):]))
+}
+
+// readLog decodes the log entries with the prefix, in key order
+func readLog(ctx context.Context, txn *badger.Txn, prefix []byte) ([]db.LogEntry, error) {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	entries := []db.LogEntry{}
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var entry db.LogEntry
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &entry)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// readFlags returns the flags of the card. Cards without flags key have zero
+// flags.
+func readFlags(txn *badger.Txn, deckId string, cardId int) (flags cardFlags, err error) {
+	v, err := txn.Get(flagsKey(deckId, cardId))
+	if err == badger.ErrKeyNotFound {
+		return flags, nil
+	}
+
+	if err != nil {
+		return flags, err
+	}
+
+	err = v.Value(func(val []byte) error {
+		return json.Unmarshal(val, &flags)
+	})
+
+	return flags, err
+}
+
+// writeFlags saves the flags of the card. Zero flags delete the key.
+func writeFlags(txn *badger.Txn, deckId string, cardId int, flags cardFlags) error {
+	if flags.isZero() {
+		return txn.Delete(flagsKey(deckId, cardId))
+	}
+
+	b, err := json.Marshal(flags)
+	if err != nil {
+		return err
 	}
 
-	valCopy = it.Item().KeyCopy(nil)
+	return txn.Set(flagsKey(deckId, cardId), b)
+}
 
-	num, err := numberFromPaddedKey(valCopy)
+// findMaxCardId returns the highest card id ever inserted in the deck, also
+// if the card was deleted
+func findMaxCardId(txn *badger.Txn, deckId string) (int, error) {
+	meta, err := readDeckMeta(txn, deckId)
 	if err != nil {
 		return 0, err
 	}
 
-	return num, nil
+	return meta.MaxCardId, nil
+}
+
+func readDeckMeta(txn *badger.Txn, deckId string) (meta deckMeta, err error) {
+	v, err := txn.Get(deckKey(deckId))
+	if err == badger.ErrKeyNotFound {
+		return meta, db.ErrDeckIdNotExists
+	}
+
+	if err != nil {
+		return meta, err
+	}
+
+	err = v.Value(func(val []byte) error {
+		return json.Unmarshal(val, &meta)
+	})
+
+	if err == nil && meta.Deleted {
+		return meta, ErrDeckDeleting
+	}
+
+	return meta, err
+}
+
+// checkDeck reads the deck meta, so that the transaction conflicts with a
+// DeleteDeck of the deck, and returns ErrDeckDeleting for a deck being
+// deleted. Decks that do not exist are not an error.
+func checkDeck(txn *badger.Txn, deckId string) error {
+	_, err := readDeckMeta(txn, deckId)
+	if err == db.ErrDeckIdNotExists {
+		return nil
+	}
+
+	return err
+}
+
+func writeDeckMeta(txn *badger.Txn, deckId string, meta deckMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return txn.Set(deckKey(deckId), b)
 }
 
 // Insert after max the cards that are new
 // cardId start at 1 (no 0) for new cards
-func (h *Handler) insertAfter(txn *badger.Txn, max int, r review.Review) (res review.Due, err error) {
+func (h *Handler) insertAfter(ctx context.Context, txn *badger.Txn, max int, r review.Review) (res review.Due, err error) {
 
 	res = review.Due{}
 	res.DeckId = r.DeckId
 
 	for idx, ri := range r.Items {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
 
 		cardId := max + idx + 1
 
@@ -191,42 +1231,84 @@ func (h *Handler) insertAfter(txn *badger.Txn, max int, r review.Review) (res re
 			return res, err
 		}
 
-		key := buildKey(r.DeckId, cardId)
+		key := cardKey(r.DeckId, cardId)
 
 		if err := txn.Set(key, b); err != nil {
 			return res, err
 		}
 
-		// add new Card Id to response
-		res.Items = append(res.Items, review.DueItem{CardId: cardId})
+		dueItem, err := h.Algo.Summary(b)
+		if err != nil {
+			return res, err
+		}
+
+		if err := txn.Set(dueKey(r.DeckId, dueItem.Due, cardId), nil); err != nil {
+			return res, err
+		}
+
+		if err := writeLapses(txn, r.DeckId, cardId, db.Lapses{}.Review(ri.Quality)); err != nil {
+			return res, err
+		}
+
+		entry := db.NewLogEntry(r.DeckId, ri, ri.Time(h.Clock.Now()), review.DueItem{}, dueItem)
+		if _, err := appendLog(txn, entry); err != nil {
+			return res, err
+		}
+
+		// add new Card to response
+		res.Items = append(res.Items, dueItem)
 	}
 
 	return res, nil
 }
 
-// Update cards not new. All must exists
-func (h *Handler) updateBetween(txn *badger.Txn, r review.Review) (due review.Due, err error) {
+// Update cards not new. All must exists. The returned snapshot contains
+// the old algo parameters of the updated cards.
+func (h *Handler) updateBetween(ctx context.Context, txn *badger.Txn, r review.Review) (due review.Due, snap snapshot, err error) {
 
 	due = review.Due{}
 	due.DeckId = r.DeckId
 
-	for _, ri := range r.Items {
+	if err := checkDeck(txn, r.DeckId); err != nil {
+		return due, snap, err
+	}
+
+	seen := map[int]bool{}
 
-		key := buildKey(r.DeckId, ri.CardId)
+	due.Items = make([]review.DueItem, len(r.Items))
+
+	// Items of the same card are applied in the order they were reviewed,
+	// the response keeps the order of the review
+	for _, i := range r.ChronologicalOrder() {
+		ri := r.Items[i]
+
+		if err := ctx.Err(); err != nil {
+			return due, snap, err
+		}
+
+		key := cardKey(r.DeckId, ri.CardId)
 		v, err := txn.Get(key)
-		// ErrKeyNotFound
+		if err == badger.ErrKeyNotFound {
+			return due, snap, db.ErrCardIdNotExists
+		}
+
 		if err != nil {
-			return due, err
+			return due, snap, err
 		}
 
 		valCopy, err := v.ValueCopy(nil)
 		if err != nil {
-			return due, err
+			return due, snap, err
+		}
+
+		oldDueItem, err := h.Algo.Summary(valCopy)
+		if err != nil {
+			return due, snap, err
 		}
 
 		b, err := h.Algo.Update(valCopy, ri)
 		if err != nil {
-			return due, err
+			return due, snap, err
 		}
 
 		if err := txn.Set(key, b); err != nil {
@@ -234,31 +1316,47 @@ func (h *Handler) updateBetween(txn *badger.Txn, r review.Review) (due review.Du
 			// writes/deletes in the transaction exceeds a certain limit. In
 			// that case, it is best to commit the transaction and start a new
 			// transaction immediately
-			return due, err
+			return due, snap, err
 		}
 
-		// add new Card Id to response
-		due.Items = append(due.Items, review.DueItem{CardId: ri.CardId})
-	}
+		dueItem, err := h.Algo.Summary(b)
+		if err != nil {
+			return due, snap, err
+		}
 
-	return due, nil
-}
+		// move the card in the due index
+		if err := txn.Delete(dueKey(r.DeckId, oldDueItem.Due, ri.CardId)); err != nil {
+			return due, snap, err
+		}
 
-func buildKey(boxId string, cardId int) []byte {
-	return []byte(boxId + fmt.Sprintf("%06d", cardId))
-}
+		if err := txn.Set(dueKey(r.DeckId, dueItem.Due, ri.CardId), nil); err != nil {
+			return due, snap, err
+		}
 
-func numberFromPaddedKey(key []byte) (int, error) {
-	// get the last TODO len
-	// remove the leading 0
-	keyStr := string(key)
-	numStr := strings.TrimLeft(keyStr[len(keyStr)-6:], "0")
+		lapses, err := readLapses(txn, r.DeckId, ri.CardId)
+		if err != nil {
+			return due, snap, err
+		}
 
-	// convert to int
-	s, err := strconv.Atoi(numStr)
-	if err != nil {
-		return 0, err
+		if err := writeLapses(txn, r.DeckId, ri.CardId, lapses.Review(ri.Quality)); err != nil {
+			return due, snap, err
+		}
+
+		entry := db.NewLogEntry(r.DeckId, ri, ri.Time(h.Clock.Now()), oldDueItem, dueItem)
+		seq, err := appendLog(txn, entry)
+		if err != nil {
+			return due, snap, err
+		}
+
+		// only the state before the first review of the card is restored
+		if !seen[ri.CardId] {
+			seen[ri.CardId] = true
+			snap.Cards = append(snap.Cards, snapshotCard{CardId: ri.CardId, Item: valCopy, Lapses: lapses, LogSeq: seq})
+		}
+
+		// add updated Card to response
+		due.Items[i] = dueItem
 	}
 
-	return s, nil
+	return due, snap, nil
 }
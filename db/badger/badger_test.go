@@ -1,14 +1,20 @@
 package badger_test
 
 import (
+	"errors"
+	"fmt"
 	badger "github.com/outcaste-io/badger/v3"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/revelaction/go-srs/algo"
 	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
 	"github.com/revelaction/go-srs/db"
 	bdg "github.com/revelaction/go-srs/db/badger"
+	"github.com/revelaction/go-srs/db/dbtest"
 	"github.com/revelaction/go-srs/review"
 )
 
@@ -335,3 +341,1054 @@ func TestInsertAndUdpate(t *testing.T) {
 		t.Errorf("\nCheking len:\ngot %d\nwant %d", len(dueUpdateAfter.Items), wantLenUpdateAfter)
 	}
 }
+
+// TestDueWithClock tests that a single handler follows the time of a shared
+// clock.
+func TestDueWithClock(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	// Algo and Db share the clock
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	dbh := bdg.NewWithClock(bad, sm2.NewWithClock(c), c)
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: 4},
+		{Quality: 3},
+	}
+
+	deckId := "hi"
+
+	_, err = dbh.Insert(r, deckId)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	// zero time is the clock time: nothing is due now
+	due, err := dbh.Due(deckId, time.Time{})
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 0)
+	}
+
+	c.Advance(24*time.Hour + time.Second)
+
+	due, err = dbh.Due(deckId, time.Time{})
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 2)
+	}
+
+	// Update the cards with a bad review with the same handler: they are
+	// due one day after the advanced clock time.
+	r.DeckId = deckId
+	r.Items = []review.ReviewItem{
+		{CardId: 1, Quality: 2},
+		{CardId: 2, Quality: 2},
+	}
+
+	_, err = dbh.Update(r)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	due, err = dbh.Due(deckId, time.Time{})
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 0)
+	}
+
+	c.Advance(24*time.Hour + time.Second)
+
+	due, err = dbh.Due(deckId, time.Time{})
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 2)
+	}
+}
+
+// TestUpdateReviewedAtOrder tests that items of the same card are applied in
+// chronological order
+func TestUpdateReviewedAtOrder(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	dbh := bdg.New(bad, sm2.New(now))
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+	}
+
+	deckId := "hi"
+
+	_, err = dbh.Insert(r, deckId)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	// Offline reviews uploaded out of order: the last one is a failure, so
+	// the card is due one day after it.
+	first := now.Add(2 * time.Hour)
+	last := now.Add(5 * time.Hour)
+
+	r.DeckId = deckId
+	r.Items = []review.ReviewItem{
+		{CardId: 1, Quality: review.IncorrectBlackout, ReviewedAt: last},
+		{CardId: 1, Quality: review.CorrectEasy, ReviewedAt: first},
+	}
+
+	_, err = dbh.Update(r)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	due, err := dbh.Due(deckId, last.AddDate(0, 0, 1))
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 0)
+	}
+
+	due, err = dbh.Due(deckId, last.AddDate(0, 0, 1).Add(time.Second))
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 1 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 1)
+	}
+}
+
+// TestUpdateReturnsSchedule tests that Insert, Update and Due return the due
+// time, interval and state of the cards
+func TestUpdateReturnsSchedule(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	dbh := bdg.NewWithClock(bad, sm2.NewWithClock(c), c)
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+	}
+
+	deckId := "hi"
+
+	res, err := dbh.Insert(r, deckId)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	want := review.DueItem{CardId: 1, Due: now.AddDate(0, 0, 1), State: review.StateNew}
+	if res.Items[0] != want {
+		t.Errorf("\ngot %#v\nwant %#v", res.Items[0], want)
+	}
+
+	c.Advance(24 * time.Hour)
+
+	r.DeckId = deckId
+	r.Items = []review.ReviewItem{
+		{CardId: 1, Quality: review.IncorrectFamiliar},
+	}
+
+	res, err = dbh.Update(r)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	want = review.DueItem{CardId: 1, Due: now.AddDate(0, 0, 2), Interval: 24 * time.Hour, State: review.StateRelearning}
+	if res.Items[0] != want {
+		t.Errorf("\ngot %#v\nwant %#v", res.Items[0], want)
+	}
+
+	due, err := dbh.Due(deckId, now.AddDate(0, 0, 3))
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 1 || due.Items[0] != want {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items, want)
+	}
+
+	r.Items = []review.ReviewItem{
+		{CardId: 5, Quality: review.IncorrectFamiliar},
+	}
+
+	if _, err := dbh.Update(r); err != db.ErrCardIdNotExists {
+		t.Errorf("\ngot error %v\nwant %v", err, db.ErrCardIdNotExists)
+	}
+}
+
+// TestDueIndexOrder tests that Due returns the cards ordered by due time, and
+// that updated cards are moved in the due index
+func TestDueIndexOrder(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	dbh := bdg.NewWithClock(bad, sm2.NewWithClock(c), c)
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+		{Quality: review.NoReview},
+		{Quality: review.NoReview},
+	}
+
+	deckId := "hi"
+
+	_, err = dbh.Insert(r, deckId)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	// Card 1 is reviewed one hour later: it is due after cards 2 and 3
+	c.Advance(time.Hour)
+
+	r.DeckId = deckId
+	r.Items = []review.ReviewItem{
+		{CardId: 1, Quality: review.IncorrectFamiliar},
+	}
+
+	_, err = dbh.Update(r)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	due, err := dbh.Due(deckId, now.AddDate(0, 0, 2))
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	wantIds := []int{2, 3, 1}
+	if len(due.Items) != len(wantIds) {
+		t.Fatalf("\nChecking len:\ngot %d\nwant %d", len(due.Items), len(wantIds))
+	}
+
+	for idx, item := range due.Items {
+		if item.CardId != wantIds[idx] {
+			t.Errorf("\ngot cardId %d\nwant cardId %d", item.CardId, wantIds[idx])
+		}
+	}
+
+	// Only cards 2 and 3 are due before the review of card 1
+	due, err = dbh.Due(deckId, now.AddDate(0, 0, 1).Add(time.Minute))
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 2)
+	}
+}
+
+// TestOpenMigrate tests that cards saved in the version 0 key layout are
+// migrated on Open
+func TestOpenMigrate(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	algo := sm2.New(now)
+
+	// Cards written directly in the version 0 layout, deck id followed by
+	// the card id
+	err = bad.Update(func(txn *badger.Txn) error {
+		for _, key := range []string{"user1000001", "user1000002", "user12000001"} {
+			b, err := algo.Update(nil, review.ReviewItem{Quality: review.NoReview})
+			if err != nil {
+				return err
+			}
+
+			if err := txn.Set([]byte(key), b); err != nil {
+				return err
+			}
+		}
+
+		// deck meta of an interrupted migration, after the deletion of
+		// the cards 2 to 7
+		return txn.Set([]byte("d\x00user12"), []byte(`{"MaxCardId":7}`))
+	})
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	dbh, err := bdg.Open(bad, algo, clock.NewFake(now))
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	after := now.AddDate(0, 0, 1).Add(time.Second)
+
+	for deckId, want := range map[string]int{"user1": 2, "user12": 1} {
+		due, err := dbh.Due(deckId, after)
+		if err != nil {
+			t.Errorf("got unexpected error %s", err)
+		}
+
+		if len(due.Items) != want {
+			t.Errorf("\nChecking len %s:\ngot %d\nwant %d", deckId, len(due.Items), want)
+		}
+	}
+
+	// New cards are inserted after the migrated ones
+	r := review.Review{DeckId: "user1"}
+	r.Items = []review.ReviewItem{{Quality: review.NoReview}}
+
+	res, err := dbh.Insert(r, "")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if res.Items[0].CardId != 3 {
+		t.Errorf("\ngot cardId %d\nwant cardId %d", res.Items[0].CardId, 3)
+	}
+
+	// The ids of deleted cards are not reused
+	res, err = dbh.Insert(review.Review{DeckId: "user12", Items: r.Items}, "")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if res.Items[0].CardId != 8 {
+		t.Errorf("\ngot cardId %d\nwant cardId %d", res.Items[0].CardId, 8)
+	}
+
+	// Opening again does nothing
+	if _, err := bdg.Open(bad, algo, clock.NewFake(now)); err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+}
+
+// TestNewMigrate tests that New migrates a version 0 db, and that a db of a
+// newer version is refused by every operation
+func TestNewMigrate(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	algo := sm2.New(now)
+
+	b, err := algo.Update(nil, review.ReviewItem{Quality: review.NoReview})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := bad.Update(func(txn *badger.Txn) error { return txn.Set([]byte("deckA000001"), b) }); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	dbh := bdg.New(bad, algo)
+
+	due, err := dbh.Due("deckA", now.AddDate(0, 0, 1).Add(time.Second))
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 1 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 1)
+	}
+
+	// a newer version
+	if err := bad.Update(func(txn *badger.Txn) error { return txn.Set([]byte("s\x00version"), []byte("99")) }); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	dbh = bdg.New(bad, algo)
+
+	if _, err := dbh.Due("deckA", now.AddDate(0, 0, 1).Add(time.Second)); !errors.Is(err, bdg.ErrSchemaVersion) {
+		t.Errorf("\ngot error %v\nwant %v", err, bdg.ErrSchemaVersion)
+	}
+
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}}}
+	if _, err := dbh.Insert(r, "hi"); !errors.Is(err, bdg.ErrSchemaVersion) {
+		t.Errorf("\ngot error %v\nwant %v", err, bdg.ErrSchemaVersion)
+	}
+
+	if _, err := bdg.Open(bad, algo, clock.NewFake(now)); !errors.Is(err, bdg.ErrSchemaVersion) {
+		t.Errorf("\ngot error %v\nwant %v", err, bdg.ErrSchemaVersion)
+	}
+}
+
+// TestSchemaVersion tests that a new db is written in the current
+// SchemaVersion, and that Open does not migrate it
+func TestSchemaVersion(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	dbh := bdg.NewWithClock(bad, sm2.NewWithClock(c), c)
+
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}, {Quality: review.NoReview}}}
+	if _, err := dbh.Insert(r, "hi"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := dbh.DeleteCards("hi", []int{2}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	var version string
+	err = bad.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte("s\x00version"))
+		if err != nil {
+			return err
+		}
+
+		b, err := item.ValueCopy(nil)
+		version = string(b)
+		return err
+	})
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if want := fmt.Sprint(bdg.SchemaVersion); version != want {
+		t.Errorf("\ngot version %s\nwant %s", version, want)
+	}
+
+	dbh, err = bdg.Open(bad, sm2.NewWithClock(c), c)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	res, err := dbh.Insert(review.Review{DeckId: "hi", Items: r.Items[:1]}, "")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if res.Items[0].CardId != 3 {
+		t.Errorf("\ngot cardId %d\nwant cardId %d", res.Items[0].CardId, 3)
+	}
+}
+
+// TestReindex tests that the due index is rebuilt from the cards
+func TestReindex(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	dbh := bdg.New(bad, sm2.New(now))
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+		{Quality: review.NoReview},
+	}
+
+	_, err = dbh.Insert(r, "hi")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	// drop the index namespace
+	if err := bad.DropPrefix([]byte{'i', 0}); err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	after := now.AddDate(0, 0, 1).Add(time.Second)
+
+	due, err := dbh.Due("hi", after)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 0)
+	}
+
+	if err := dbh.Reindex(); err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	due, err = dbh.Due("hi", after)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 2)
+	}
+}
+
+// hookAlgo runs hook in the first Update of the algo
+type hookAlgo struct {
+	algo.Algo
+	hook func()
+}
+
+func (a *hookAlgo) Update(old []byte, r review.ReviewItem) ([]byte, error) {
+	if a.hook != nil {
+		a.hook()
+		a.hook = nil
+	}
+
+	return a.Algo.Update(old, r)
+}
+
+// deckKeys returns the number of keys of the deck in all the namespaces
+func deckKeys(t *testing.T, bad *badger.DB, deckId string) int {
+	t.Helper()
+
+	n := 0
+	err := bad.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			key := it.Item().Key()
+			if len(key) > 2 && strings.HasPrefix(string(key[2:]), deckId) {
+				n++
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return n
+}
+
+// TestDeleteDeckConcurrent tests that a write to the deck running during
+// DeleteDeck fails, and that an interrupted DeleteDeck blocks the writes until
+// it is called again
+func TestDeleteDeckConcurrent(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	a := &hookAlgo{Algo: sm2.NewWithClock(c)}
+	dbh := bdg.NewWithClock(bad, a, c)
+
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}, {Quality: review.NoReview}}}
+	if _, err := dbh.Insert(r, "hi"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// the deck is deleted while the Update computes the cards
+	a.hook = func() {
+		if err := dbh.DeleteDeck("hi"); err != nil {
+			t.Errorf("got unexpected error %s", err)
+		}
+	}
+
+	c.Advance(24 * time.Hour)
+	r = review.Review{DeckId: "hi", Items: []review.ReviewItem{{CardId: 1, Quality: review.CorrectEasy}}}
+	if _, err := dbh.Update(r); err != badger.ErrConflict {
+		t.Errorf("\ngot error %v\nwant %v", err, badger.ErrConflict)
+	}
+
+	if n := deckKeys(t, bad, "hi"); n != 0 {
+		t.Errorf("\ngot %d keys\nwant no keys", n)
+	}
+
+	// an interrupted DeleteDeck leaves the deck meta marked
+	r = review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}, {Quality: review.NoReview}}}
+	if _, err := dbh.Insert(r, "hi"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	err = bad.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("d\x00hi"), []byte(`{"MaxCardId":2,"Deleted":true}`))
+	})
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if _, err := dbh.Insert(r, "hi"); err != bdg.ErrDeckDeleting {
+		t.Errorf("\ngot error %v\nwant %v", err, bdg.ErrDeckDeleting)
+	}
+
+	r.DeckId = "hi"
+	if _, err := dbh.Insert(r, ""); err != bdg.ErrDeckDeleting {
+		t.Errorf("\ngot error %v\nwant %v", err, bdg.ErrDeckDeleting)
+	}
+
+	if _, err := dbh.Update(review.Review{DeckId: "hi", Items: []review.ReviewItem{{CardId: 1, Quality: review.CorrectEasy}}}); err != bdg.ErrDeckDeleting {
+		t.Errorf("\ngot error %v\nwant %v", err, bdg.ErrDeckDeleting)
+	}
+
+	if err := dbh.Suspend("hi", []int{1}); err != bdg.ErrDeckDeleting {
+		t.Errorf("\ngot error %v\nwant %v", err, bdg.ErrDeckDeleting)
+	}
+
+	if err := dbh.DeleteDeck("hi"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if n := deckKeys(t, bad, "hi"); n != 0 {
+		t.Errorf("\ngot %d keys\nwant no keys", n)
+	}
+
+	// the deck id can be used again
+	res, err := dbh.Insert(r, "hi")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if res.Items[0].CardId != 1 {
+		t.Errorf("\ngot cardId %d\nwant cardId %d", res.Items[0].CardId, 1)
+	}
+}
+
+// TestLargeDeck tests that Reindex and DeleteDeck work on decks that do not
+// fit in one transaction
+func TestLargeDeck(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// small transactions
+	opts := badger.DefaultOptions(dir).WithMemTableSize(1 << 20).WithValueThreshold(1 << 10)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	dbh := bdg.New(bad, sm2.New(now))
+
+	r := review.Review{Items: make([]review.ReviewItem, 100)}
+
+	const inserts = 40
+	for i := 0; i < inserts; i++ {
+		deckId := ""
+		if i == 0 {
+			deckId = "big"
+		}
+
+		r.DeckId = "big"
+		if _, err := dbh.Insert(r, deckId); err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+	}
+
+	if _, err := dbh.Insert(r, "hi"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if int64(inserts*len(r.Items)) < bad.MaxBatchCount() {
+		t.Fatalf("\ngot %d cards\nwant more than %d", inserts*len(r.Items), bad.MaxBatchCount())
+	}
+
+	after := now.AddDate(0, 0, 1).Add(time.Second)
+
+	if err := bad.DropPrefix([]byte{'i', 0}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := dbh.Reindex(); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	due, err := dbh.Due("big", after)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != inserts*len(r.Items) {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), inserts*len(r.Items))
+	}
+
+	if err := dbh.DeleteDeck("big"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := dbh.DeleteDeck("big"); err != db.ErrDeckIdNotExists {
+		t.Errorf("\ngot error %v\nwant %v", err, db.ErrDeckIdNotExists)
+	}
+
+	for deckId, want := range map[string]int{"big": 0, "hi": len(r.Items)} {
+		due, err := dbh.Due(deckId, after)
+		if err != nil {
+			t.Errorf("got unexpected error %s", err)
+		}
+
+		if len(due.Items) != want {
+			t.Errorf("\nChecking len %s:\ngot %d\nwant %d", deckId, len(due.Items), want)
+		}
+	}
+}
+
+// TestLargePutCards tests that PutCards writes decks that do not fit in one
+// transaction
+func TestLargePutCards(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// small transactions
+	opts := badger.DefaultOptions(dir).WithMemTableSize(1 << 20).WithValueThreshold(1 << 10)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	dbh := bdg.New(bad, sm2.New(now))
+
+	r := review.Review{Items: make([]review.ReviewItem, 100)}
+
+	const inserts = 40
+	for i := 0; i < inserts; i++ {
+		deckId := ""
+		if i == 0 {
+			deckId = "big"
+		}
+
+		r.DeckId = "big"
+		if _, err := dbh.Insert(r, deckId); err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+	}
+
+	cards, err := dbh.Cards("big")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if int64(2*len(cards)) < bad.MaxBatchCount() {
+		t.Fatalf("\ngot %d cards\nwant more than %d", len(cards), bad.MaxBatchCount()/2)
+	}
+
+	// odd cards are suspended, to write also their flags
+	for i := range cards {
+		cards[i].Suspended = cards[i].CardId%2 == 1
+	}
+
+	// a new deck, and the replaced cards of the same deck
+	for _, deckId := range []string{"copy", "big"} {
+		if err := dbh.PutCards(deckId, cards); err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		got, err := dbh.Cards(deckId)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(cards) {
+			t.Errorf("\n%s: got %d cards\nwant the %d written cards", deckId, len(got), len(cards))
+		}
+
+		due, err := dbh.Due(deckId, now.AddDate(0, 0, 1).Add(time.Second))
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if len(due.Items) != len(cards)/2 {
+			t.Errorf("\nChecking len %s:\ngot %d\nwant %d", deckId, len(due.Items), len(cards)/2)
+		}
+	}
+
+	// the deck meta has the last card id
+	r = review.Review{DeckId: "copy", Items: make([]review.ReviewItem, 1)}
+	due, err := dbh.Insert(r, "")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if due.Items[0].CardId != len(cards)+1 {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items[0].CardId, len(cards)+1)
+	}
+
+	// the log is also written in several transactions
+	log, err := dbh.DeckLog("big")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := dbh.PutLog("copy", log); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	got, err := dbh.DeckLog("copy")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(got) != len(log)+1 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(got), len(log)+1)
+	}
+}
+
+// TestDeckIdPrefix tests that a deck id that is a prefix of another deck id
+// does not see its cards
+func TestDeckIdPrefix(t *testing.T) {
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+
+	bad, err := badger.Open(opts)
+
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	defer bad.Close()
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	dbh := bdg.New(bad, sm2.New(now))
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+		{Quality: review.NoReview},
+	}
+
+	_, err = dbh.Insert(r, "user12")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	r.Items = r.Items[:1]
+	_, err = dbh.Insert(r, "user1")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	due, err := dbh.Due("user1", now.AddDate(0, 0, 2))
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 1 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 1)
+	}
+
+	// next card of user1 is 2
+	r.DeckId = "user1"
+	res, err := dbh.Insert(r, "")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if res.Items[0].CardId != 2 {
+		t.Errorf("\ngot cardId %d\nwant cardId %d", res.Items[0].CardId, 2)
+	}
+
+	// deck ids with the key separator are rejected
+	_, err = dbh.Insert(r, "user\x00")
+	if err != db.ErrInvalidDeckId {
+		t.Errorf("\ngot error %v\nwant %v", err, db.ErrInvalidDeckId)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, a algo.Algo, c clock.Clock) db.Handler {
+		opts := badger.DefaultOptions(t.TempDir())
+		opts.Logger = nil
+
+		bad, err := badger.Open(opts)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		t.Cleanup(func() { bad.Close() })
+
+		dbh, err := bdg.Open(bad, a, c)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		return dbh
+	}, dbtest.Algos...)
+}
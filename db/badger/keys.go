@@ -0,0 +1,171 @@
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SchemaVersion is the version of the key layout written by this package.
+//
+// Every key starts with a namespace byte and a separator:
+//
+//	s 0x00 version                            schema version
+//	c 0x00 deckId 0x00 cardId                 card algo parameters
+//	d 0x00 deckId                             deck meta
+//	i 0x00 deckId 0x00 due cardId             due index, no value
+//	f 0x00 deckId 0x00 cardId                 card flags, only if any is set
+//	p 0x00 deckId 0x00 cardId                 card lapses, only if any is set
+//	l 0x00 deckId 0x00 cardId seq             review log entry
+//	u 0x00 deckId 0x00 seq                    undo snapshot
+//
+// Numbers are zero padded decimals, so keys sort numerically. Deck ids can not
+// contain the separator.
+const SchemaVersion = 1
+
+const (
+	nsSchema byte = 's'
+	nsCard   byte = 'c'
+	nsDeck   byte = 'd'
+	nsDue    byte = 'i'
+	nsFlags  byte = 'f'
+	nsLapses byte = 'p'
+	nsLog    byte = 'l'
+	nsUndo   byte = 'u'
+
+	sep byte = 0x00
+
+	cardIdWidth = 10
+	dueWidth    = 20
+	seqWidth    = 10
+)
+
+var schemaKey = []byte{nsSchema, sep, 'v', 'e', 'r', 's', 'i', 'o', 'n'}
+
+// deckMeta is the value of the deck key
+type deckMeta struct {
+	// MaxCardId is the highest card id ever inserted in the deck
+	MaxCardId int
+
+	// Deleted is set by DeleteDeck until all the keys of the deck are
+	// deleted
+	Deleted bool `json:",omitempty"`
+}
+
+// cardFlags is the value of the flags key. Flags hide the card from the due
+// queries without changing its algo parameters.
+type cardFlags struct {
+	Suspended bool
+
+	// BuriedUntil is a Unix timestamp
+	BuriedUntil int64
+}
+
+// hidden reports if the card is excluded from the due cards at time t
+func (f cardFlags) hidden(t time.Time) bool {
+	return f.Suspended || f.BuriedUntil > t.Unix()
+}
+
+// shownAt returns the Unix time a card due at dueUnix is shown, the end of
+// its burial if that is later, and false if the card is suspended
+func (f cardFlags) shownAt(dueUnix int64) (int64, bool) {
+	if f.Suspended {
+		return 0, false
+	}
+
+	if f.BuriedUntil > dueUnix {
+		return f.BuriedUntil, true
+	}
+
+	return dueUnix, true
+}
+
+func (f cardFlags) isZero() bool {
+	return f == cardFlags{}
+}
+
+// namespacePrefix is the prefix of all keys of the namespace
+func namespacePrefix(ns byte) []byte {
+	return []byte{ns, sep}
+}
+
+// deckPrefix is the prefix of the keys of the deck in the namespace ns
+func deckPrefix(ns byte, deckId string) []byte {
+	b := namespacePrefix(ns)
+	b = append(b, deckId...)
+	return append(b, sep)
+}
+
+func cardKey(deckId string, cardId int) []byte {
+	return append(deckPrefix(nsCard, deckId), fmt.Sprintf("%0*d", cardIdWidth, cardId)...)
+}
+
+// parseCardKey returns the deck id and card id of a card key
+func parseCardKey(key []byte) (string, int, error) {
+	rest := key[len(namespacePrefix(nsCard)):]
+
+	idx := bytes.IndexByte(rest, sep)
+	if idx < 0 || len(rest)-idx-1 != cardIdWidth {
+		return "", 0, fmt.Errorf("invalid card key %q", key)
+	}
+
+	cardId, err := strconv.Atoi(string(rest[idx+1:]))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return string(rest[:idx]), cardId, nil
+}
+
+func flagsKey(deckId string, cardId int) []byte {
+	return append(deckPrefix(nsFlags, deckId), fmt.Sprintf("%0*d", cardIdWidth, cardId)...)
+}
+
+func lapsesKey(deckId string, cardId int) []byte {
+	return append(deckPrefix(nsLapses, deckId), fmt.Sprintf("%0*d", cardIdWidth, cardId)...)
+}
+
+// logPrefix is the prefix of the review log keys of the card
+func logPrefix(deckId string, cardId int) []byte {
+	return append(deckPrefix(nsLog, deckId), fmt.Sprintf("%0*d", cardIdWidth, cardId)...)
+}
+
+// logKey builds the key of the seq-th review log entry of the card
+func logKey(deckId string, cardId int, seq int) []byte {
+	return append(logPrefix(deckId, cardId), fmt.Sprintf("%0*d", seqWidth, seq)...)
+}
+
+func undoKey(deckId string, seq int) []byte {
+	return append(deckPrefix(nsUndo, deckId), fmt.Sprintf("%0*d", seqWidth, seq)...)
+}
+
+func deckKey(deckId string) []byte {
+	return append(namespacePrefix(nsDeck), deckId...)
+}
+
+// dueKey builds a key ordered by due time and card id for the deck. The key
+// has no value.
+func dueKey(deckId string, due time.Time, cardId int) []byte {
+	return append(deckPrefix(nsDue, deckId), fmt.Sprintf("%0*d%0*d", dueWidth, due.Unix(), cardIdWidth, cardId)...)
+}
+
+// parseDueKey returns the due unix time and card id of the index key
+func parseDueKey(key, prefix []byte) (int64, int, error) {
+	suffix := string(key[len(prefix):])
+	if len(suffix) != dueWidth+cardIdWidth {
+		return 0, 0, fmt.Errorf("invalid due index key %q", key)
+	}
+
+	dueUnix, err := strconv.ParseInt(suffix[:dueWidth], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	cardId, err := strconv.Atoi(suffix[dueWidth:])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return dueUnix, cardId, nil
+}
@@ -0,0 +1,239 @@
+package badger
+
+import (
+	"context"
+	"encoding/json"
+	badger "github.com/outcaste-io/badger/v3"
+	"strconv"
+
+	"github.com/revelaction/go-srs/db"
+)
+
+// MarkLeeches marks as leech the cards with at least threshold lapses, and
+// returns the cards that were not marked before. All the cards must exist.
+//
+// MarkLeeches is atomic
+func (h *Handler) MarkLeeches(deckId string, cardIds []int, threshold int) ([]int, error) {
+	return h.MarkLeechesContext(context.Background(), deckId, cardIds, threshold)
+}
+
+// MarkLeechesContext is MarkLeeches with a context. The transaction is
+// discarded if ctx is done before the commit.
+func (h *Handler) MarkLeechesContext(ctx context.Context, deckId string, cardIds []int, threshold int) ([]int, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	var marked []int
+	err := h.updateLapses(ctx, txn, deckId, cardIds, func(cardId int, l *db.Lapses) {
+		if l.Leech || l.Count < threshold {
+			return
+		}
+
+		l.Leech = true
+		marked = append(marked, cardId)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := commit(ctx, txn); err != nil {
+		return nil, err
+	}
+
+	return marked, nil
+}
+
+// UnmarkLeeches clears the leech mark and the lapse count of the cards. All
+// the cards must exist.
+//
+// UnmarkLeeches is atomic
+func (h *Handler) UnmarkLeeches(deckId string, cardIds []int) error {
+	return h.UnmarkLeechesContext(context.Background(), deckId, cardIds)
+}
+
+// UnmarkLeechesContext is UnmarkLeeches with a context. The transaction is
+// discarded if ctx is done before the commit.
+func (h *Handler) UnmarkLeechesContext(ctx context.Context, deckId string, cardIds []int) error {
+	if h.err != nil {
+		return h.err
+	}
+
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	err := h.updateLapses(ctx, txn, deckId, cardIds, func(cardId int, l *db.Lapses) {
+		*l = db.Lapses{Correct: l.Correct}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return commit(ctx, txn)
+}
+
+// Leeches returns the cards of the deck marked as leech, in card id order.
+func (h *Handler) Leeches(deckId string) ([]db.Leech, error) {
+	return h.LeechesContext(context.Background(), deckId)
+}
+
+// LeechesContext is Leeches with a context. The scan stops when ctx is done.
+func (h *Handler) LeechesContext(ctx context.Context, deckId string) ([]db.Leech, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	txn := h.Db.NewTransaction(false)
+	defer txn.Discard()
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	prefix := deckPrefix(nsLapses, deckId)
+
+	leeches := []db.Leech{}
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var lapses db.Lapses
+		err := it.Item().Value(func(val []byte) error {
+			return json.Unmarshal(val, &lapses)
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !lapses.Leech {
+			continue
+		}
+
+		cardId, err := strconv.Atoi(string(it.Item().Key()[len(prefix):]))
+		if err != nil {
+			return nil, err
+		}
+
+		leeches = append(leeches, db.Leech{CardId: cardId, Lapses: lapses.Count})
+	}
+
+	return leeches, nil
+}
+
+// markLeeches applies the rule to the cards of the snapshot of an Update, and
+// returns the new leeches. The snapshot records the suspended cards.
+func markLeeches(txn *badger.Txn, deckId string, snap snapshot, rule db.LeechRule) (leeches []int, err error) {
+	for k, sc := range snap.Cards {
+		lapses, err := readLapses(txn, deckId, sc.CardId)
+		if err != nil {
+			return nil, err
+		}
+
+		if !rule.Marks(lapses) {
+			continue
+		}
+
+		lapses.Leech = true
+		if err := writeLapses(txn, deckId, sc.CardId, lapses); err != nil {
+			return nil, err
+		}
+
+		leeches = append(leeches, sc.CardId)
+
+		flags, err := readFlags(txn, deckId, sc.CardId)
+		if err != nil {
+			return nil, err
+		}
+
+		if !rule.Suspend || flags.Suspended {
+			continue
+		}
+
+		flags.Suspended = true
+		if err := writeFlags(txn, deckId, sc.CardId, flags); err != nil {
+			return nil, err
+		}
+
+		snap.Cards[k].Suspended = true
+	}
+
+	return leeches, nil
+}
+
+// updateLapses applies fn to the lapses of the existing cards
+func (h *Handler) updateLapses(ctx context.Context, txn *badger.Txn, deckId string, cardIds []int, fn func(cardId int, l *db.Lapses)) error {
+	if err := checkDeck(txn, deckId); err != nil {
+		return err
+	}
+
+	for _, cardId := range cardIds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, err := txn.Get(cardKey(deckId, cardId))
+		if err == badger.ErrKeyNotFound {
+			return db.ErrCardIdNotExists
+		}
+
+		if err != nil {
+			return err
+		}
+
+		lapses, err := readLapses(txn, deckId, cardId)
+		if err != nil {
+			return err
+		}
+
+		fn(cardId, &lapses)
+
+		if err := writeLapses(txn, deckId, cardId, lapses); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readLapses returns the lapses of the card. Cards without lapses key have
+// zero lapses.
+func readLapses(txn *badger.Txn, deckId string, cardId int) (lapses db.Lapses, err error) {
+	v, err := txn.Get(lapsesKey(deckId, cardId))
+	if err == badger.ErrKeyNotFound {
+		return lapses, nil
+	}
+
+	if err != nil {
+		return lapses, err
+	}
+
+	err = v.Value(func(val []byte) error {
+		return json.Unmarshal(val, &lapses)
+	})
+
+	return lapses, err
+}
+
+// writeLapses saves the lapses of the card. Zero lapses delete the key.
+func writeLapses(txn *badger.Txn, deckId string, cardId int, lapses db.Lapses) error {
+	if lapses == (db.Lapses{}) {
+		return txn.Delete(lapsesKey(deckId, cardId))
+	}
+
+	b, err := json.Marshal(lapses)
+	if err != nil {
+		return err
+	}
+
+	return txn.Set(lapsesKey(deckId, cardId), b)
+}
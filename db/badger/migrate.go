@@ -0,0 +1,233 @@
+package badger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	badger "github.com/outcaste-io/badger/v3"
+	"strconv"
+	"strings"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/clock"
+)
+
+// legacyDueNamespace prefixes the due index keys of the version 0 layout
+var legacyDueNamespace = []byte{0, 'd', 'u', 'e', 0}
+
+// legacyCardIdWidth is the card id width of the version 0 layout
+const legacyCardIdWidth = 6
+
+// ErrSchemaVersion is returned for databases written by a newer version of
+// the package
+var ErrSchemaVersion = errors.New("unknown schema version")
+
+// Open returns a Handler that asks c for the current time, after migrating
+// the db to the current SchemaVersion. It returns the error of the migration
+// of NewWithClock.
+func Open(db *badger.DB, algo algo.Algo, c clock.Clock) (*Handler, error) {
+	h := NewWithClock(db, algo, c)
+
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	return h, nil
+}
+
+// Migrate rewrites the keys of the db to the current SchemaVersion. It does
+// nothing for up to date databases.
+func (h *Handler) Migrate() error {
+
+	version, err := h.schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	switch version {
+	case SchemaVersion:
+		return nil
+	case 0:
+		return h.migrateV0()
+	default:
+		return fmt.Errorf("%w %d", ErrSchemaVersion, version)
+	}
+}
+
+// initSchema writes the SchemaVersion in a db without keys
+func (h *Handler) initSchema() error {
+	return h.Db.Update(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		if it.Rewind(); it.Valid() {
+			return nil
+		}
+
+		return txn.Set(schemaKey, []byte(strconv.Itoa(SchemaVersion)))
+	})
+}
+
+// schemaVersion reads the version of the key layout. Databases without
+// version are in version 0.
+func (h *Handler) schemaVersion() (version int, err error) {
+	err = h.Db.View(func(txn *badger.Txn) error {
+		v, err := txn.Get(schemaKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return v.Value(func(val []byte) error {
+			version, err = strconv.Atoi(string(val))
+			return err
+		})
+	})
+
+	return version, err
+}
+
+// migrateV0 rewrites the card keys of the version 0 layout (the deck id
+// followed by the card id) and rebuilds the due index and the deck meta. The
+// MaxCardId of the deck meta is never lowered.
+//
+// Keys already in the current layout are kept, so an interrupted migration
+// can run again.
+func (h *Handler) migrateV0() error {
+
+	txn := h.Db.NewTransaction(false)
+	defer txn.Discard()
+
+	wb := h.Db.NewWriteBatch()
+	defer wb.Cancel()
+
+	maxCardIds := map[string]int{}
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.KeyCopy(nil)
+
+		// version 0 due index
+		if bytes.HasPrefix(key, legacyDueNamespace) {
+			if err := wb.Delete(key); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		// current layout
+		if bytes.IndexByte(key, sep) >= 0 {
+			// deck meta of an interrupted migration, its max can be higher
+			// than the max card after deletes
+			if key[0] == nsDeck {
+				var meta deckMeta
+				err := item.Value(func(val []byte) error {
+					return json.Unmarshal(val, &meta)
+				})
+
+				if err != nil {
+					return err
+				}
+
+				deckId := string(key[len(namespacePrefix(nsDeck)):])
+				if meta.MaxCardId > maxCardIds[deckId] {
+					maxCardIds[deckId] = meta.MaxCardId
+				}
+
+				continue
+			}
+
+			if key[0] != nsCard {
+				continue
+			}
+
+			deckId, cardId, err := parseCardKey(key)
+			if err != nil {
+				return err
+			}
+
+			if cardId > maxCardIds[deckId] {
+				maxCardIds[deckId] = cardId
+			}
+
+			continue
+		}
+
+		deckId, cardId, err := parseLegacyKey(key)
+		if err != nil {
+			return err
+		}
+
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		dueItem, err := h.Algo.Summary(v)
+		if err != nil {
+			return err
+		}
+
+		if err := wb.Set(cardKey(deckId, cardId), v); err != nil {
+			return err
+		}
+
+		if err := wb.Set(dueKey(deckId, dueItem.Due, cardId), nil); err != nil {
+			return err
+		}
+
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+
+		if cardId > maxCardIds[deckId] {
+			maxCardIds[deckId] = cardId
+		}
+	}
+
+	for deckId, max := range maxCardIds {
+		b, err := json.Marshal(deckMeta{MaxCardId: max})
+		if err != nil {
+			return err
+		}
+
+		if err := wb.Set(deckKey(deckId), b); err != nil {
+			return err
+		}
+	}
+
+	if err := wb.Set(schemaKey, []byte(strconv.Itoa(SchemaVersion))); err != nil {
+		return err
+	}
+
+	return wb.Flush()
+}
+
+// parseLegacyKey returns the deck id and card id of a version 0 card key
+func parseLegacyKey(key []byte) (string, int, error) {
+	if len(key) <= legacyCardIdWidth {
+		return "", 0, fmt.Errorf("invalid version 0 key %q", key)
+	}
+
+	keyStr := string(key)
+	idx := len(keyStr) - legacyCardIdWidth
+
+	// remove the leading 0
+	cardId, err := strconv.Atoi(strings.TrimLeft(keyStr[idx:], "0"))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return keyStr[:idx], cardId, nil
+}
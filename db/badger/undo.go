@@ -0,0 +1,240 @@
+package badger
+
+import (
+	"context"
+	"encoding/json"
+	badger "github.com/outcaste-io/badger/v3"
+
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+// snapshot is the value of an undo key. It contains the state of the cards
+// before an Update.
+type snapshot struct {
+	Cards []snapshotCard
+}
+
+type snapshotCard struct {
+	CardId int
+
+	// Item is the serialized algo parameters before the Update
+	Item []byte
+
+	// Lapses before the Update
+	Lapses db.Lapses
+
+	// LogSeq is the first review log entry of the card written by the Update
+	LogSeq int
+
+	// Suspended reports if the Update suspended the card as leech
+	Suspended bool `json:",omitempty"`
+}
+
+// Undo restores the algo parameters of the cards of the last Update of the
+// deck, and deletes the review log entries written by it. Cards deleted
+// after the Update are not restored. It returns the restored cards.
+//
+// Undo is atomic
+func (h *Handler) Undo(deckId string) (review.Due, error) {
+	return h.UndoContext(context.Background(), deckId)
+}
+
+// UndoContext is Undo with a context. The transaction is discarded if ctx is
+// done before the commit.
+func (h *Handler) UndoContext(ctx context.Context, deckId string) (due review.Due, err error) {
+	if h.err != nil {
+		return review.Due{}, h.err
+	}
+
+	due.DeckId = deckId
+
+	txn := h.Db.NewTransaction(true)
+	defer txn.Discard()
+
+	if err := checkDeck(txn, deckId); err != nil {
+		return due, err
+	}
+
+	seq, err := lastSeq(txn, deckPrefix(nsUndo, deckId))
+	if err != nil {
+		return due, err
+	}
+
+	if seq == 0 {
+		return due, db.ErrNothingToUndo
+	}
+
+	snap, err := readSnapshot(txn, deckId, seq)
+	if err != nil {
+		return due, err
+	}
+
+	for _, sc := range snap.Cards {
+		if err := ctx.Err(); err != nil {
+			return due, err
+		}
+
+		current, err := h.card(txn, deckId, sc.CardId)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+
+		if err != nil {
+			return due, err
+		}
+
+		currentDueItem, err := h.Algo.Summary(current)
+		if err != nil {
+			return due, err
+		}
+
+		dueItem, err := h.Algo.Summary(sc.Item)
+		if err != nil {
+			return due, err
+		}
+
+		if err := txn.Set(cardKey(deckId, sc.CardId), sc.Item); err != nil {
+			return due, err
+		}
+
+		// move the card in the due index
+		if err := txn.Delete(dueKey(deckId, currentDueItem.Due, sc.CardId)); err != nil {
+			return due, err
+		}
+
+		if err := txn.Set(dueKey(deckId, dueItem.Due, sc.CardId), nil); err != nil {
+			return due, err
+		}
+
+		if err := writeLapses(txn, deckId, sc.CardId, sc.Lapses); err != nil {
+			return due, err
+		}
+
+		if sc.Suspended {
+			flags, err := readFlags(txn, deckId, sc.CardId)
+			if err != nil {
+				return due, err
+			}
+
+			flags.Suspended = false
+			if err := writeFlags(txn, deckId, sc.CardId, flags); err != nil {
+				return due, err
+			}
+		}
+
+		if err := deleteLogFrom(txn, deckId, sc.CardId, sc.LogSeq); err != nil {
+			return due, err
+		}
+
+		due.Items = append(due.Items, dueItem)
+	}
+
+	if err := txn.Delete(undoKey(deckId, seq)); err != nil {
+		return due, err
+	}
+
+	if err := commit(ctx, txn); err != nil {
+		return due, err
+	}
+
+	return due, nil
+}
+
+// pushSnapshot saves the snapshot of an Update, and deletes the snapshots
+// older than UndoDepth.
+func (h *Handler) pushSnapshot(txn *badger.Txn, deckId string, snap snapshot) error {
+	if h.UndoDepth <= 0 {
+		return nil
+	}
+
+	seq, err := lastSeq(txn, deckPrefix(nsUndo, deckId))
+	if err != nil {
+		return err
+	}
+
+	seq++
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Set(undoKey(deckId, seq), b); err != nil {
+		return err
+	}
+
+	// snapshots are pushed one at a time: only one can fall out of depth,
+	// unless the depth was reduced
+	for old := seq - h.UndoDepth; old > 0; old-- {
+		_, err := txn.Get(undoKey(deckId, old))
+		if err == badger.ErrKeyNotFound {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(undoKey(deckId, old)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearUndo deletes all the snapshots of the deck. The snapshots have
+// consecutive sequence numbers up to the last one.
+func clearUndo(txn *badger.Txn, deckId string) error {
+	seq, err := lastSeq(txn, deckPrefix(nsUndo, deckId))
+	if err != nil {
+		return err
+	}
+
+	for ; seq > 0; seq-- {
+		_, err := txn.Get(undoKey(deckId, seq))
+		if err == badger.ErrKeyNotFound {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := txn.Delete(undoKey(deckId, seq)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readSnapshot(txn *badger.Txn, deckId string, seq int) (snap snapshot, err error) {
+	v, err := txn.Get(undoKey(deckId, seq))
+	if err != nil {
+		return snap, err
+	}
+
+	err = v.Value(func(val []byte) error {
+		return json.Unmarshal(val, &snap)
+	})
+
+	return snap, err
+}
+
+// deleteLogFrom deletes the review log entries of the card from seq on
+func deleteLogFrom(txn *badger.Txn, deckId string, cardId int, seq int) error {
+	last, err := lastSeq(txn, logPrefix(deckId, cardId))
+	if err != nil {
+		return err
+	}
+
+	for ; seq <= last; seq++ {
+		if err := txn.Delete(logKey(deckId, cardId, seq)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,942 @@
+// Package bolt implements the srs/db.Handler interface with a bbolt backend
+//
+// bbolt keeps the db in a single file with a single writer, without
+// background compaction.
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+// Handler is a bbolt client.
+//
+// It accepts an Algo to allow for atomic operations. Every method runs in a
+// single bbolt transaction.
+type Handler struct {
+	Db    *bolt.DB
+	Algo  algo.Algo
+	Clock clock.Clock
+
+	// UndoDepth is the number of Update calls per deck that can be undone.
+	// Zero disables Undo.
+	UndoDepth int
+}
+
+// DefaultUndoDepth is the UndoDepth of new Handlers
+const DefaultUndoDepth = 10
+
+// New returns a Handler with the system clock
+func New(db *bolt.DB, algo algo.Algo) *Handler {
+	return NewWithClock(db, algo, clock.Real{})
+}
+
+// NewWithClock returns a Handler that asks c for the current time. The
+// algo should share the same clock.
+func NewWithClock(db *bolt.DB, algo algo.Algo, c clock.Clock) *Handler {
+	return &Handler{
+		Db:        db,
+		Algo:      algo,
+		Clock:     c,
+		UndoDepth: DefaultUndoDepth,
+	}
+}
+
+// Insert runs the Algo on all cards of a Review, and saves them after the
+// last card id of the deck. A non empty deckId creates the deck bucket if it
+// does not exist; otherwise r.DeckId must exist. It clears the undo snapshots
+// of the deck.
+//
+// Insert is atomic
+func (h *Handler) Insert(r review.Review, deckId string) (review.Due, error) {
+	return h.InsertContext(context.Background(), r, deckId)
+}
+
+// InsertContext is Insert with a context. The transaction is rolled back if
+// ctx is done before the commit.
+func (h *Handler) InsertContext(ctx context.Context, r review.Review, deckId string) (res review.Due, err error) {
+
+	if deckId != "" {
+		if !db.ValidDeckId(deckId) {
+			return res, db.ErrInvalidDeckId
+		}
+
+		r.DeckId = deckId
+	}
+
+	res.DeckId = r.DeckId
+
+	err = h.Db.Update(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(r.DeckId))
+		if deck == nil {
+			if deckId == "" {
+				return db.ErrDeckIdNotExists
+			}
+
+			var err error
+			deck, err = createDeck(tx, r.DeckId)
+			if err != nil {
+				return err
+			}
+		}
+
+		cards := deck.Bucket(bucketCards)
+		max := int(cards.Sequence())
+
+		for idx, ri := range r.Items {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// cardId must be injected, to be properly encoded in the Algo native struct
+			ri.CardId = max + idx + 1
+
+			b, err := h.Algo.Update(nil, ri)
+			if err != nil {
+				return err
+			}
+
+			dueItem, err := h.Algo.Summary(b)
+			if err != nil {
+				return err
+			}
+
+			if err := cards.Put(cardKey(ri.CardId), b); err != nil {
+				return err
+			}
+
+			if err := deck.Bucket(bucketDue).Put(dueKey(dueItem.Due, ri.CardId), nil); err != nil {
+				return err
+			}
+
+			if err := writeLapses(deck, ri.CardId, db.Lapses{}.Review(ri.Quality)); err != nil {
+				return err
+			}
+
+			entry := db.NewLogEntry(r.DeckId, ri, ri.Time(h.Clock.Now()), review.DueItem{}, dueItem)
+			if _, err := appendLog(deck, entry); err != nil {
+				return err
+			}
+
+			res.Items = append(res.Items, dueItem)
+		}
+
+		if err := cards.SetSequence(uint64(max + len(r.Items))); err != nil {
+			return err
+		}
+
+		if err := clearUndo(deck); err != nil {
+			return err
+		}
+
+		return ctx.Err()
+	})
+
+	return res, err
+}
+
+// Update looks up in the db the (must) existing cards in the review r, run the
+// srs algo on them, and saves the updated result in the db. Items of the same
+// card are applied in the order they were reviewed.
+//
+// Update is atomic
+func (h *Handler) Update(r review.Review) (review.Due, error) {
+	return h.UpdateContext(context.Background(), r)
+}
+
+// UpdateContext is Update with a context. The transaction is rolled back if
+// ctx is done before the commit.
+func (h *Handler) UpdateContext(ctx context.Context, r review.Review) (review.Due, error) {
+	due, _, err := h.UpdateLeechesContext(ctx, r, db.LeechRule{})
+	return due, err
+}
+
+// UpdateLeeches is Update that also marks as leech the updated cards that
+// reach the threshold of rule, and returns them.
+//
+// UpdateLeeches is atomic
+func (h *Handler) UpdateLeeches(r review.Review, rule db.LeechRule) (review.Due, []int, error) {
+	return h.UpdateLeechesContext(context.Background(), r, rule)
+}
+
+// UpdateLeechesContext is UpdateLeeches with a context.
+func (h *Handler) UpdateLeechesContext(ctx context.Context, r review.Review, rule db.LeechRule) (due review.Due, leeches []int, err error) {
+
+	due.DeckId = r.DeckId
+
+	err = h.Db.Update(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(r.DeckId))
+		if deck == nil {
+			return db.ErrCardIdNotExists
+		}
+
+		var snap snapshot
+		seen := map[int]bool{}
+
+		due.Items = make([]review.DueItem, len(r.Items))
+
+		// Items of the same card are applied in the order they were reviewed,
+		// the response keeps the order of the review
+		for _, i := range r.ChronologicalOrder() {
+			ri := r.Items[i]
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			old := card(deck, ri.CardId)
+			if old == nil {
+				return db.ErrCardIdNotExists
+			}
+
+			oldDueItem, err := h.Algo.Summary(old)
+			if err != nil {
+				return err
+			}
+
+			b, err := h.Algo.Update(old, ri)
+			if err != nil {
+				return err
+			}
+
+			dueItem, err := h.Algo.Summary(b)
+			if err != nil {
+				return err
+			}
+
+			if err := putCard(deck, ri.CardId, oldDueItem, b, dueItem); err != nil {
+				return err
+			}
+
+			lapses, err := readLapses(deck, ri.CardId)
+			if err != nil {
+				return err
+			}
+
+			if err := writeLapses(deck, ri.CardId, lapses.Review(ri.Quality)); err != nil {
+				return err
+			}
+
+			entry := db.NewLogEntry(r.DeckId, ri, ri.Time(h.Clock.Now()), oldDueItem, dueItem)
+			seq, err := appendLog(deck, entry)
+			if err != nil {
+				return err
+			}
+
+			// only the state before the first review of the card is restored
+			if !seen[ri.CardId] {
+				seen[ri.CardId] = true
+				snap.Cards = append(snap.Cards, snapshotCard{CardId: ri.CardId, Item: old, Lapses: lapses, LogSeq: seq})
+			}
+
+			due.Items[i] = dueItem
+		}
+
+		leeches, err = markLeeches(deck, snap, rule)
+		if err != nil {
+			return err
+		}
+
+		if err := h.pushSnapshot(deck, snap); err != nil {
+			return err
+		}
+
+		return ctx.Err()
+	})
+
+	if err != nil {
+		return due, nil, err
+	}
+
+	return due, leeches, nil
+}
+
+// Due returns the due cards for the time t, most overdue first. A zero t
+// means the current time of the Handler Clock.
+func (h *Handler) Due(deckId string, t time.Time) (review.Due, error) {
+	return h.DueQueryContext(context.Background(), db.Query{DeckId: deckId, T: t})
+}
+
+// DueContext is Due with a context.
+func (h *Handler) DueContext(ctx context.Context, deckId string, t time.Time) (review.Due, error) {
+	return h.DueQueryContext(ctx, db.Query{DeckId: deckId, T: t})
+}
+
+// DueQuery returns the page of due cards of the query q. Only the due cards
+// are read from the due index. Queries in OrderOverdue stop reading at the end
+// of the page.
+func (h *Handler) DueQuery(q db.Query) (review.Due, error) {
+	return h.DueQueryContext(context.Background(), q)
+}
+
+// DueQueryContext is DueQuery with a context. The scan of the due index stops
+// when ctx is done.
+func (h *Handler) DueQueryContext(ctx context.Context, q db.Query) (due review.Due, err error) {
+
+	due.DeckId = q.DeckId
+
+	t := q.T
+	if t.IsZero() {
+		t = h.Clock.Now()
+	}
+
+	// the index is in overdue order
+	paged := q.Order == db.OrderOverdue
+	skipped := 0
+
+	var items []review.DueItem
+	recall := map[int]float64{}
+
+	err = h.Db.View(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(q.DeckId))
+		if deck == nil {
+			return nil
+		}
+
+		c := deck.Bucket(bucketDue).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			dueUnix, cardId := parseDueKey(k)
+			if dueUnix >= t.Unix() {
+				break
+			}
+
+			flags, err := readFlags(deck, cardId)
+			if err != nil {
+				return err
+			}
+
+			if flags.hidden(t) {
+				continue
+			}
+
+			if paged && skipped < q.Offset {
+				skipped++
+				continue
+			}
+
+			if paged && q.Limit > 0 && len(items) == q.Limit {
+				break
+			}
+
+			v := card(deck, cardId)
+
+			dueItem, err := h.Algo.Summary(v)
+			if err != nil {
+				return err
+			}
+
+			if q.Order == db.OrderRetrievability {
+				recall[cardId], err = algo.Recall(h.Algo, v, t)
+				if err != nil {
+					return err
+				}
+			}
+
+			items = append(items, dueItem)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return due, err
+	}
+
+	if paged {
+		due.Items = items
+		return due, nil
+	}
+
+	q.Sort(items, recall)
+	due.Items = q.Page(items)
+
+	return due, nil
+}
+
+// DueTimes returns the times the cards of the deck due in [from, to) are
+// shown. A zero from means the start of the deck. Only the range of the due
+// index and the flags of its cards are read.
+func (h *Handler) DueTimes(deckId string, from, to time.Time) ([]time.Time, error) {
+	return h.DueTimesContext(context.Background(), deckId, from, to)
+}
+
+// DueTimesContext is DueTimes with a context. The scan of the due index stops
+// when ctx is done.
+func (h *Handler) DueTimesContext(ctx context.Context, deckId string, from, to time.Time) ([]time.Time, error) {
+	times := []time.Time{}
+
+	err := h.Db.View(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return nil
+		}
+
+		c := deck.Bucket(bucketDue).Cursor()
+
+		k, _ := c.First()
+		if !from.IsZero() {
+			k, _ = c.Seek(dueKey(from, 0))
+		}
+
+		for ; k != nil; k, _ = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			dueUnix, cardId := parseDueKey(k)
+			if dueUnix >= to.Unix() {
+				break
+			}
+
+			flags, err := readFlags(deck, cardId)
+			if err != nil {
+				return err
+			}
+
+			shown, ok := flags.shownAt(dueUnix)
+			if !ok || shown >= to.Unix() {
+				continue
+			}
+
+			times = append(times, time.Unix(shown, 0).UTC())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.SortTimes(times)
+	return times, nil
+}
+
+// DeleteCards deletes the cards of the deck and their due index keys. All the
+// cards must exist. The review log of the cards is kept.
+//
+// DeleteCards is atomic
+func (h *Handler) DeleteCards(deckId string, cardIds []int) error {
+	return h.DeleteCardsContext(context.Background(), deckId, cardIds)
+}
+
+// DeleteCardsContext is DeleteCards with a context. The transaction is
+// rolled back if ctx is done before the commit.
+func (h *Handler) DeleteCardsContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.Db.Update(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return db.ErrCardIdNotExists
+		}
+
+		for _, cardId := range cardIds {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			v := card(deck, cardId)
+			if v == nil {
+				return db.ErrCardIdNotExists
+			}
+
+			dueItem, err := h.Algo.Summary(v)
+			if err != nil {
+				return err
+			}
+
+			if err := deck.Bucket(bucketCards).Delete(cardKey(cardId)); err != nil {
+				return err
+			}
+
+			if err := deck.Bucket(bucketDue).Delete(dueKey(dueItem.Due, cardId)); err != nil {
+				return err
+			}
+
+			if err := deck.Bucket(bucketFlags).Delete(cardKey(cardId)); err != nil {
+				return err
+			}
+
+			if err := writeLapses(deck, cardId, db.Lapses{}); err != nil {
+				return err
+			}
+		}
+
+		return ctx.Err()
+	})
+}
+
+// DeleteDeck deletes the bucket of the deck, with all its cards, flags,
+// lapses, review log, due index and undo snapshots.
+//
+// DeleteDeck is atomic
+func (h *Handler) DeleteDeck(deckId string) error {
+	return h.DeleteDeckContext(context.Background(), deckId)
+}
+
+// DeleteDeckContext is DeleteDeck with a context. The transaction is rolled
+// back if ctx is done before the commit.
+func (h *Handler) DeleteDeckContext(ctx context.Context, deckId string) error {
+	return h.Db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(deckId)) == nil {
+			return db.ErrDeckIdNotExists
+		}
+
+		if err := tx.DeleteBucket([]byte(deckId)); err != nil {
+			return err
+		}
+
+		return ctx.Err()
+	})
+}
+
+// Suspend hides the cards from the due queries until Unsuspend. The cards
+// keep their schedule.
+//
+// Suspend is atomic
+func (h *Handler) Suspend(deckId string, cardIds []int) error {
+	return h.SuspendContext(context.Background(), deckId, cardIds)
+}
+
+// SuspendContext is Suspend with a context. The transaction is rolled back if
+// ctx is done before the commit.
+func (h *Handler) SuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateFlags(ctx, deckId, cardIds, func(f *cardFlags) {
+		f.Suspended = true
+	})
+}
+
+// Unsuspend shows again suspended or buried cards in the due queries.
+//
+// Unsuspend is atomic
+func (h *Handler) Unsuspend(deckId string, cardIds []int) error {
+	return h.UnsuspendContext(context.Background(), deckId, cardIds)
+}
+
+// UnsuspendContext is Unsuspend with a context. The transaction is rolled
+// back if ctx is done before the commit.
+func (h *Handler) UnsuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateFlags(ctx, deckId, cardIds, func(f *cardFlags) {
+		*f = cardFlags{}
+	})
+}
+
+// Bury hides the cards from the due queries until the time until. A zero
+// until means the start of the next day of the Handler Clock.
+//
+// Bury is atomic
+func (h *Handler) Bury(deckId string, cardIds []int, until time.Time) error {
+	return h.BuryContext(context.Background(), deckId, cardIds, until)
+}
+
+// BuryContext is Bury with a context. The transaction is rolled back if ctx
+// is done before the commit.
+func (h *Handler) BuryContext(ctx context.Context, deckId string, cardIds []int, until time.Time) error {
+	if until.IsZero() {
+		now := h.Clock.Now()
+		until = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	}
+
+	return h.updateFlags(ctx, deckId, cardIds, func(f *cardFlags) {
+		f.BuriedUntil = until.Unix()
+	})
+}
+
+// updateFlags applies fn to the flags of the existing cards
+func (h *Handler) updateFlags(ctx context.Context, deckId string, cardIds []int, fn func(f *cardFlags)) error {
+	return h.Db.Update(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return db.ErrCardIdNotExists
+		}
+
+		for _, cardId := range cardIds {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if card(deck, cardId) == nil {
+				return db.ErrCardIdNotExists
+			}
+
+			flags, err := readFlags(deck, cardId)
+			if err != nil {
+				return err
+			}
+
+			fn(&flags)
+
+			if err := writeFlags(deck, cardId, flags); err != nil {
+				return err
+			}
+		}
+
+		return ctx.Err()
+	})
+}
+
+// CardLog returns the review log of the card, in review order.
+func (h *Handler) CardLog(deckId string, cardId int) ([]db.LogEntry, error) {
+	return h.CardLogContext(context.Background(), deckId, cardId)
+}
+
+// CardLogContext is CardLog with a context.
+func (h *Handler) CardLogContext(ctx context.Context, deckId string, cardId int) ([]db.LogEntry, error) {
+	entries, err := h.readLog(ctx, deckId, cardKey(cardId))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SortLog(entries)
+	return entries, nil
+}
+
+// DeckLog returns the review log of all the cards of the deck, in review
+// order.
+func (h *Handler) DeckLog(deckId string) ([]db.LogEntry, error) {
+	return h.DeckLogContext(context.Background(), deckId)
+}
+
+// DeckLogContext is DeckLog with a context. The scan of the log stops when
+// ctx is done.
+func (h *Handler) DeckLogContext(ctx context.Context, deckId string) ([]db.LogEntry, error) {
+	entries, err := h.readLog(ctx, deckId, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SortLog(entries)
+	return entries, nil
+}
+
+// DeckLogRange returns the entries of the review log of the deck reviewed in
+// the range [from, to), a zero from being the start of the log, in review
+// order. The log has no time index: the whole log of the deck
+// is read.
+func (h *Handler) DeckLogRange(deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	return h.DeckLogRangeContext(context.Background(), deckId, from, to)
+}
+
+// DeckLogRangeContext is DeckLogRange with a context.
+func (h *Handler) DeckLogRangeContext(ctx context.Context, deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	entries, err := h.DeckLogContext(ctx, deckId)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.LogRange(entries, from, to), nil
+}
+
+// Cards returns the stored state of all the cards of the deck, in card id
+// order.
+func (h *Handler) Cards(deckId string) ([]db.Card, error) {
+	return h.CardsContext(context.Background(), deckId)
+}
+
+// CardsContext is Cards with a context. The scan of the cards stops when ctx
+// is done.
+func (h *Handler) CardsContext(ctx context.Context, deckId string) ([]db.Card, error) {
+	cards := []db.Card{}
+
+	err := h.Db.View(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return nil
+		}
+
+		c := deck.Bucket(bucketCards).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			cardId := parseCardKey(k)
+
+			dueItem, err := h.Algo.Summary(v)
+			if err != nil {
+				return err
+			}
+
+			flags, err := readFlags(deck, cardId)
+			if err != nil {
+				return err
+			}
+
+			lapses, err := readLapses(deck, cardId)
+			if err != nil {
+				return err
+			}
+
+			card := db.Card{DueItem: dueItem, Item: append([]byte{}, v...), Suspended: flags.Suspended, Lapses: lapses}
+			if flags.BuriedUntil != 0 {
+				card.BuriedUntil = time.Unix(flags.BuriedUntil, 0).UTC()
+			}
+
+			cards = append(cards, card)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cards, nil
+}
+
+// PutCards writes the cards in the deck, creating it if it does not exist.
+// Cards with the same ids are replaced.
+//
+// PutCards is atomic
+func (h *Handler) PutCards(deckId string, cards []db.Card) error {
+	return h.PutCardsContext(context.Background(), deckId, cards)
+}
+
+// PutCardsContext is PutCards with a context. The transaction is rolled back
+// if ctx is done before the commit.
+func (h *Handler) PutCardsContext(ctx context.Context, deckId string, cards []db.Card) error {
+	if !db.ValidDeckId(deckId) {
+		return db.ErrInvalidDeckId
+	}
+
+	return h.Db.Update(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			var err error
+			deck, err = createDeck(tx, deckId)
+			if err != nil {
+				return err
+			}
+		}
+
+		bucket := deck.Bucket(bucketCards)
+		max := bucket.Sequence()
+
+		for _, c := range cards {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			dueItem, err := h.Algo.Summary(c.Item)
+			if err != nil {
+				return err
+			}
+
+			if c.CardId < 1 || dueItem.CardId != c.CardId {
+				return db.ErrInvalidCardId
+			}
+
+			// replaced cards leave the due index
+			var old review.DueItem
+			if b := card(deck, c.CardId); b != nil {
+				old, err = h.Algo.Summary(b)
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := putCard(deck, c.CardId, old, c.Item, dueItem); err != nil {
+				return err
+			}
+
+			flags := cardFlags{Suspended: c.Suspended}
+			if !c.BuriedUntil.IsZero() {
+				flags.BuriedUntil = c.BuriedUntil.Unix()
+			}
+
+			if err := writeFlags(deck, c.CardId, flags); err != nil {
+				return err
+			}
+
+			if err := writeLapses(deck, c.CardId, c.Lapses); err != nil {
+				return err
+			}
+
+			if uint64(c.CardId) > max {
+				max = uint64(c.CardId)
+			}
+		}
+
+		if err := bucket.SetSequence(max); err != nil {
+			return err
+		}
+
+		return ctx.Err()
+	})
+}
+
+// PutLog appends the entries to the review log of the deck, and clears its
+// undo snapshots. The cards are not changed.
+//
+// PutLog is atomic
+func (h *Handler) PutLog(deckId string, entries []db.LogEntry) error {
+	return h.PutLogContext(context.Background(), deckId, entries)
+}
+
+// PutLogContext is PutLog with a context. The transaction is rolled back if
+// ctx is done before the commit.
+func (h *Handler) PutLogContext(ctx context.Context, deckId string, entries []db.LogEntry) error {
+	return h.Db.Update(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return db.ErrDeckIdNotExists
+		}
+
+		max := deck.Bucket(bucketCards).Sequence()
+
+		for _, e := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if e.CardId < 1 || uint64(e.CardId) > max {
+				return db.ErrCardIdNotExists
+			}
+
+			e.DeckId = deckId
+			if _, err := appendLog(deck, e); err != nil {
+				return err
+			}
+		}
+
+		if err := clearUndo(deck); err != nil {
+			return err
+		}
+
+		return ctx.Err()
+	})
+}
+
+// readLog decodes the log entries of the deck with the prefix, in key order
+func (h *Handler) readLog(ctx context.Context, deckId string, prefix []byte) ([]db.LogEntry, error) {
+	entries := []db.LogEntry{}
+
+	err := h.Db.View(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return nil
+		}
+
+		c := deck.Bucket(bucketLog).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var entry db.LogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// createDeck creates the bucket of the deck and its nested buckets
+func createDeck(tx *bolt.Tx, deckId string) (*bolt.Bucket, error) {
+	deck, err := tx.CreateBucket([]byte(deckId))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range deckBuckets {
+		if _, err := deck.CreateBucket(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return deck, nil
+}
+
+// card returns a copy of the algo parameters of the card, or nil if it does
+// not exist
+func card(deck *bolt.Bucket, cardId int) []byte {
+	v := deck.Bucket(bucketCards).Get(cardKey(cardId))
+	if v == nil {
+		return nil
+	}
+
+	// values are only valid during the transaction
+	return append([]byte{}, v...)
+}
+
+// putCard saves the algo parameters of the card and moves it in the due
+// index
+func putCard(deck *bolt.Bucket, cardId int, old review.DueItem, b []byte, dueItem review.DueItem) error {
+	if err := deck.Bucket(bucketCards).Put(cardKey(cardId), b); err != nil {
+		return err
+	}
+
+	index := deck.Bucket(bucketDue)
+	if err := index.Delete(dueKey(old.Due, cardId)); err != nil {
+		return err
+	}
+
+	return index.Put(dueKey(dueItem.Due, cardId), nil)
+}
+
+// appendLog saves the entry in the log of the deck, and returns its sequence
+// number.
+func appendLog(deck *bolt.Bucket, entry db.LogEntry) (uint64, error) {
+	log := deck.Bucket(bucketLog)
+
+	seq, err := log.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	return seq, log.Put(logKey(entry.CardId, seq), b)
+}
+
+// readFlags returns the flags of the card. Cards without flags key have zero
+// flags.
+func readFlags(deck *bolt.Bucket, cardId int) (flags cardFlags, err error) {
+	v := deck.Bucket(bucketFlags).Get(cardKey(cardId))
+	if v == nil {
+		return flags, nil
+	}
+
+	err = json.Unmarshal(v, &flags)
+	return flags, err
+}
+
+// writeFlags saves the flags of the card. Zero flags delete the key.
+func writeFlags(deck *bolt.Bucket, cardId int, flags cardFlags) error {
+	if flags.isZero() {
+		return deck.Bucket(bucketFlags).Delete(cardKey(cardId))
+	}
+
+	b, err := json.Marshal(flags)
+	if err != nil {
+		return err
+	}
+
+	return deck.Bucket(bucketFlags).Put(cardKey(cardId), b)
+}
@@ -0,0 +1,155 @@
+package bolt_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/db/bolt"
+	"github.com/revelaction/go-srs/db/dbtest"
+	"github.com/revelaction/go-srs/review"
+)
+
+// Handler implements db.Handler
+var _ db.Handler = (*bolt.Handler)(nil)
+
+// openDb returns a db file in a temporary directory
+func openDb(t *testing.T) *bbolt.DB {
+	bdb, err := bbolt.Open(filepath.Join(t.TempDir(), "srs.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	t.Cleanup(func() { bdb.Close() })
+
+	return bdb
+}
+
+// bucketKeys returns the number of keys of the nested bucket of the deck
+func bucketKeys(t *testing.T, bdb *bbolt.DB, deckId, name string) int {
+	t.Helper()
+
+	n := 0
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(deckId)).Bucket([]byte(name)).ForEach(func(k, v []byte) error {
+			n++
+			return nil
+		})
+	})
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return n
+}
+
+func TestBucketLayout(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	bdb := openDb(t)
+	dbh := bolt.NewWithClock(bdb, sm2.NewWithClock(c), c)
+
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.CorrectEasy}, {Quality: review.NoReview}}}
+	if _, err := dbh.Insert(r, "hi"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// the deck bucket has all the nested buckets from its creation
+	err := bdb.View(func(tx *bbolt.Tx) error {
+		deck := tx.Bucket([]byte("hi"))
+		if deck == nil {
+			return fmt.Errorf("no deck bucket")
+		}
+
+		var names []string
+		err := deck.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return fmt.Errorf("deck key %q is not a bucket", k)
+			}
+
+			names = append(names, string(k))
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		want := []string{"cards", "due", "flags", "lapses", "log", "undo"}
+		if fmt.Sprint(names) != fmt.Sprint(want) {
+			t.Errorf("\ngot %#v\nwant %#v", names, want)
+		}
+
+		// the sequence of the cards bucket is the last card id
+		if got := deck.Bucket([]byte("cards")).Sequence(); got != 2 {
+			t.Errorf("\ngot %#v\nwant %#v", got, 2)
+		}
+
+		// card keys are 8 bytes big-endian
+		if v := deck.Bucket([]byte("cards")).Get(binary.BigEndian.AppendUint64(nil, 2)); v == nil {
+			t.Errorf("\ngot no card 2\nwant card 2")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	for name, want := range map[string]int{"cards": 2, "due": 2, "flags": 0, "lapses": 1, "log": 2, "undo": 0} {
+		if got := bucketKeys(t, bdb, "hi", name); got != want {
+			t.Errorf("\n%s: got %d keys\nwant %d keys", name, got, want)
+		}
+	}
+
+	// flags and undo snapshots are written by Suspend and Update
+	if err := dbh.Suspend("hi", []int{1}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	c.Advance(24 * time.Hour)
+	r = review.Review{DeckId: "hi", Items: []review.ReviewItem{{CardId: 2, Quality: review.CorrectEasy}}}
+	if _, err := dbh.Update(r); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	for name, want := range map[string]int{"flags": 1, "log": 3, "undo": 1} {
+		if got := bucketKeys(t, bdb, "hi", name); got != want {
+			t.Errorf("\n%s: got %d keys\nwant %d keys", name, got, want)
+		}
+	}
+
+	// DeleteDeck deletes the deck bucket
+	if err := dbh.DeleteDeck("hi"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	err = bdb.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte("hi")) != nil {
+			t.Errorf("\ngot deck bucket\nwant no deck bucket")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, a algo.Algo, c clock.Clock) db.Handler {
+		return bolt.NewWithClock(openDb(t), a, c)
+	}, dbtest.Algos...)
+}
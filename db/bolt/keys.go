@@ -0,0 +1,100 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Each deck is a top level bucket named by the deck id, with the nested
+// buckets:
+//
+//	cards   cardId                card algo parameters
+//	due     due cardId            due index, no value
+//	flags   cardId                card flags, only if any is set
+//	lapses  cardId                card lapses, only if any is set
+//	log     cardId seq            review log entry
+//	undo    seq                   undo snapshot
+//
+// Numbers are 8 bytes big-endian, so keys sort numerically. The sequence of
+// the cards bucket is the highest card id ever inserted in the deck, so ids
+// of deleted cards are not reused. The sequence of the log and undo buckets
+// numbers their keys.
+var (
+	bucketCards  = []byte("cards")
+	bucketDue    = []byte("due")
+	bucketFlags  = []byte("flags")
+	bucketLapses = []byte("lapses")
+	bucketLog    = []byte("log")
+	bucketUndo   = []byte("undo")
+)
+
+var deckBuckets = [][]byte{bucketCards, bucketDue, bucketFlags, bucketLapses, bucketLog, bucketUndo}
+
+// cardFlags is the value of the flags key. Flags hide the card from the due
+// queries without changing its algo parameters.
+type cardFlags struct {
+	Suspended bool
+
+	// BuriedUntil is a Unix timestamp
+	BuriedUntil int64
+}
+
+// hidden reports if the card is excluded from the due cards at time t
+func (f cardFlags) hidden(t time.Time) bool {
+	return f.Suspended || f.BuriedUntil > t.Unix()
+}
+
+// shownAt returns the Unix time a card due at dueUnix is shown, the end of
+// its burial if that is later, and false if the card is suspended
+func (f cardFlags) shownAt(dueUnix int64) (int64, bool) {
+	if f.Suspended {
+		return 0, false
+	}
+
+	if f.BuriedUntil > dueUnix {
+		return f.BuriedUntil, true
+	}
+
+	return dueUnix, true
+}
+
+func (f cardFlags) isZero() bool {
+	return f == cardFlags{}
+}
+
+func itob(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func cardKey(cardId int) []byte {
+	return itob(uint64(cardId))
+}
+
+// parseCardKey returns the card id of a cards, flags or lapses key
+func parseCardKey(key []byte) int {
+	return int(binary.BigEndian.Uint64(key[:8]))
+}
+
+// logKey builds the key of the review log entry seq of the card
+func logKey(cardId int, seq uint64) []byte {
+	return append(cardKey(cardId), itob(seq)...)
+}
+
+// dueKey builds a key ordered by due time and card id. The sign bit of the
+// due time is flipped, so negative times sort first.
+func dueKey(due time.Time, cardId int) []byte {
+	return append(itob(uint64(due.Unix())^1<<63), cardKey(cardId)...)
+}
+
+// parseDueKey returns the due unix time and card id of the index key
+func parseDueKey(key []byte) (int64, int) {
+	dueUnix := int64(binary.BigEndian.Uint64(key[:8]) ^ 1<<63)
+	return dueUnix, int(binary.BigEndian.Uint64(key[8:16]))
+}
+
+// parseSeq returns the sequence number at the end of a log or undo key
+func parseSeq(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[len(key)-8:])
+}
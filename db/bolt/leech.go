@@ -0,0 +1,199 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/revelaction/go-srs/db"
+)
+
+// MarkLeeches marks as leech the cards with at least threshold lapses, and
+// returns the cards that were not marked before. All the cards must exist.
+//
+// MarkLeeches is atomic
+func (h *Handler) MarkLeeches(deckId string, cardIds []int, threshold int) ([]int, error) {
+	return h.MarkLeechesContext(context.Background(), deckId, cardIds, threshold)
+}
+
+// MarkLeechesContext is MarkLeeches with a context. The transaction is rolled
+// back if ctx is done before the commit.
+func (h *Handler) MarkLeechesContext(ctx context.Context, deckId string, cardIds []int, threshold int) (marked []int, err error) {
+	err = h.updateLapses(ctx, deckId, cardIds, func(cardId int, l *db.Lapses) {
+		if l.Leech || l.Count < threshold {
+			return
+		}
+
+		l.Leech = true
+		marked = append(marked, cardId)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return marked, nil
+}
+
+// UnmarkLeeches clears the leech mark and the lapse count of the cards. All
+// the cards must exist.
+//
+// UnmarkLeeches is atomic
+func (h *Handler) UnmarkLeeches(deckId string, cardIds []int) error {
+	return h.UnmarkLeechesContext(context.Background(), deckId, cardIds)
+}
+
+// UnmarkLeechesContext is UnmarkLeeches with a context. The transaction is
+// rolled back if ctx is done before the commit.
+func (h *Handler) UnmarkLeechesContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateLapses(ctx, deckId, cardIds, func(cardId int, l *db.Lapses) {
+		*l = db.Lapses{Correct: l.Correct}
+	})
+}
+
+// Leeches returns the cards of the deck marked as leech, in card id order.
+func (h *Handler) Leeches(deckId string) ([]db.Leech, error) {
+	return h.LeechesContext(context.Background(), deckId)
+}
+
+// LeechesContext is Leeches with a context. The scan stops when ctx is done.
+func (h *Handler) LeechesContext(ctx context.Context, deckId string) ([]db.Leech, error) {
+	leeches := []db.Leech{}
+
+	err := h.Db.View(func(tx *bolt.Tx) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return nil
+		}
+
+		return deck.Bucket(bucketLapses).ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var lapses db.Lapses
+			if err := json.Unmarshal(v, &lapses); err != nil {
+				return err
+			}
+
+			if lapses.Leech {
+				cardId := int(binary.BigEndian.Uint64(k))
+				leeches = append(leeches, db.Leech{CardId: cardId, Lapses: lapses.Count})
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return leeches, nil
+}
+
+// markLeeches applies the rule to the cards of the snapshot of an Update, and
+// returns the new leeches. The snapshot records the suspended cards.
+func markLeeches(deck *bolt.Bucket, snap snapshot, rule db.LeechRule) (leeches []int, err error) {
+	for k, sc := range snap.Cards {
+		lapses, err := readLapses(deck, sc.CardId)
+		if err != nil {
+			return nil, err
+		}
+
+		if !rule.Marks(lapses) {
+			continue
+		}
+
+		lapses.Leech = true
+		if err := writeLapses(deck, sc.CardId, lapses); err != nil {
+			return nil, err
+		}
+
+		leeches = append(leeches, sc.CardId)
+
+		flags, err := readFlags(deck, sc.CardId)
+		if err != nil {
+			return nil, err
+		}
+
+		if !rule.Suspend || flags.Suspended {
+			continue
+		}
+
+		flags.Suspended = true
+		if err := writeFlags(deck, sc.CardId, flags); err != nil {
+			return nil, err
+		}
+
+		snap.Cards[k].Suspended = true
+	}
+
+	return leeches, nil
+}
+
+// updateLapses applies fn to the lapses of the existing cards
+func (h *Handler) updateLapses(ctx context.Context, deckId string, cardIds []int, fn func(cardId int, l *db.Lapses)) error {
+	return h.Db.Update(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return db.ErrCardIdNotExists
+		}
+
+		for _, cardId := range cardIds {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if card(deck, cardId) == nil {
+				return db.ErrCardIdNotExists
+			}
+
+			lapses, err := readLapses(deck, cardId)
+			if err != nil {
+				return err
+			}
+
+			fn(cardId, &lapses)
+
+			if err := writeLapses(deck, cardId, lapses); err != nil {
+				return err
+			}
+		}
+
+		return ctx.Err()
+	})
+}
+
+// readLapses returns the lapses of the card. Cards without lapses key have
+// zero lapses.
+func readLapses(deck *bolt.Bucket, cardId int) (lapses db.Lapses, err error) {
+	v := deck.Bucket(bucketLapses).Get(cardKey(cardId))
+	if v == nil {
+		return lapses, nil
+	}
+
+	err = json.Unmarshal(v, &lapses)
+	return lapses, err
+}
+
+// writeLapses saves the lapses of the card. Zero lapses delete the key.
+func writeLapses(deck *bolt.Bucket, cardId int, lapses db.Lapses) error {
+	b := deck.Bucket(bucketLapses)
+	if lapses == (db.Lapses{}) {
+		return b.Delete(cardKey(cardId))
+	}
+
+	v, err := json.Marshal(lapses)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(cardKey(cardId), v)
+}
@@ -0,0 +1,202 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+// snapshot is the value of an undo key. It contains the state of the cards
+// before an Update.
+type snapshot struct {
+	Cards []snapshotCard
+}
+
+type snapshotCard struct {
+	CardId int
+
+	// Item is the serialized algo parameters before the Update
+	Item []byte
+
+	// Lapses before the Update
+	Lapses db.Lapses
+
+	// LogSeq is the first review log entry of the card written by the Update
+	LogSeq uint64
+
+	// Suspended reports if the Update suspended the card as leech
+	Suspended bool `json:",omitempty"`
+}
+
+// Undo restores the algo parameters of the cards of the last Update of the
+// deck, and deletes the review log entries written by it. Cards deleted
+// after the Update are not restored. It returns the restored cards.
+//
+// Undo is atomic
+func (h *Handler) Undo(deckId string) (review.Due, error) {
+	return h.UndoContext(context.Background(), deckId)
+}
+
+// UndoContext is Undo with a context. The transaction is rolled back if ctx
+// is done before the commit.
+func (h *Handler) UndoContext(ctx context.Context, deckId string) (due review.Due, err error) {
+
+	due.DeckId = deckId
+
+	err = h.Db.Update(func(tx *bolt.Tx) error {
+		deck := tx.Bucket([]byte(deckId))
+		if deck == nil {
+			return db.ErrNothingToUndo
+		}
+
+		undo := deck.Bucket(bucketUndo)
+
+		key, v := undo.Cursor().Last()
+		if key == nil {
+			return db.ErrNothingToUndo
+		}
+
+		var snap snapshot
+		if err := json.Unmarshal(v, &snap); err != nil {
+			return err
+		}
+
+		for _, sc := range snap.Cards {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			current := card(deck, sc.CardId)
+			if current == nil {
+				continue
+			}
+
+			currentDueItem, err := h.Algo.Summary(current)
+			if err != nil {
+				return err
+			}
+
+			dueItem, err := h.Algo.Summary(sc.Item)
+			if err != nil {
+				return err
+			}
+
+			if err := putCard(deck, sc.CardId, currentDueItem, sc.Item, dueItem); err != nil {
+				return err
+			}
+
+			if err := writeLapses(deck, sc.CardId, sc.Lapses); err != nil {
+				return err
+			}
+
+			if sc.Suspended {
+				flags, err := readFlags(deck, sc.CardId)
+				if err != nil {
+					return err
+				}
+
+				flags.Suspended = false
+				if err := writeFlags(deck, sc.CardId, flags); err != nil {
+					return err
+				}
+			}
+
+			if err := deleteLogFrom(deck, sc.CardId, sc.LogSeq); err != nil {
+				return err
+			}
+
+			due.Items = append(due.Items, dueItem)
+		}
+
+		if err := undo.Delete(key); err != nil {
+			return err
+		}
+
+		return ctx.Err()
+	})
+
+	return due, err
+}
+
+// pushSnapshot saves the snapshot of an Update, and deletes the snapshots
+// older than UndoDepth.
+func (h *Handler) pushSnapshot(deck *bolt.Bucket, snap snapshot) error {
+	if h.UndoDepth <= 0 {
+		return nil
+	}
+
+	undo := deck.Bucket(bucketUndo)
+
+	seq, err := undo.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	if err := undo.Put(itob(seq), b); err != nil {
+		return err
+	}
+
+	// the keys are only deleted after the scan: deleting moves the cursor
+	var old [][]byte
+	c := undo.Cursor()
+	for k, _ := c.First(); k != nil && parseSeq(k)+uint64(h.UndoDepth) <= seq; k, _ = c.Next() {
+		old = append(old, append([]byte{}, k...))
+	}
+
+	for _, k := range old {
+		if err := undo.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearUndo deletes all the snapshots of the deck
+func clearUndo(deck *bolt.Bucket) error {
+	undo := deck.Bucket(bucketUndo)
+
+	var keys [][]byte
+	c := undo.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+
+	for _, k := range keys {
+		if err := undo.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteLogFrom deletes the review log entries of the card from seq on
+func deleteLogFrom(deck *bolt.Bucket, cardId int, seq uint64) error {
+	log := deck.Bucket(bucketLog)
+	prefix := cardKey(cardId)
+
+	var keys [][]byte
+	c := log.Cursor()
+	for k, _ := c.Seek(logKey(cardId, seq)); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+
+	for _, k := range keys {
+		if err := log.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+// Card is the stored state of a card, as returned by Cards and written by
+// PutCards.
+type Card struct {
+	// DueItem is the Summary of Item
+	review.DueItem
+
+	// Item is the serialized algo parameters
+	Item []byte
+
+	Suspended bool
+
+	// BuriedUntil is the zero time if the card is not buried
+	BuriedUntil time.Time
+
+	Lapses Lapses
+}
@@ -1,7 +1,9 @@
 package db
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/revelaction/go-srs/review"
@@ -12,16 +14,123 @@ var (
 	// ErrDeckIdNotExists is returned when not found DeckId in the Db
 	ErrDeckIdNotExists = errors.New("deck Id does not exists")
 
+	// ErrNothingToUndo is returned by Undo when the deck has no Update to undo
+	ErrNothingToUndo = errors.New("nothing to undo")
+
+	// ErrInvalidDeckId is returned by Insert and PutCards for deck ids that
+	// can not be stored (see ValidDeckId)
+	ErrInvalidDeckId = errors.New("invalid deck Id")
+
 	// ErrCardIdNotExists is returned when not found Card Id in the Db
 	ErrCardIdNotExists = errors.New("card Id does not exists")
+
+	// ErrInvalidCardId is returned by PutCards for card ids lower than 1, or
+	// that are not the card id of the algo parameters
+	ErrInvalidCardId = errors.New("invalid card Id")
 )
 
+// ValidDeckId reports if deckId can be stored by all the Handlers: it is not
+// empty and has no zero byte, the key separator of the badger Handler.
+func ValidDeckId(deckId string) bool {
+	return deckId != "" && !strings.ContainsRune(deckId, 0)
+}
+
 // Handler interface abstracts the persistence of the updated Cards following a Review.
 //
-// Implementations should make all methods atomic
+// Implementations should make all methods atomic. Bulk operations (DeleteDeck,
+// PutCards and PutLog) can be split in several transactions by backends with
+// a transaction size limit. A failed DeleteDeck or PutCards can be run again,
+// a failed PutLog can leave part of its entries in the log. PutCards keeps
+// each card atomic.
 // Implementation needs at the least a db backend and a srs algo.
+//
+// Due with a zero time t returns the cards due at the current time of the
+// implementation clock, most overdue first. DueQuery returns a page of the due
+// cards in the order of the Query.
+//
+// Every review item applied by Insert and Update is appended to the review
+// log, returned by CardLog and DeckLog in review order. DeckLogRange returns
+// the entries of DeckLog reviewed in the range [from, to), a zero from being
+// the start of the log.
+//
+// Undo restores the cards of the last Update of the deck, and removes its
+// review log entries. Insert takes no snapshot: it clears the snapshots of
+// the deck, so an Update before it can not be undone.
+//
+// Every operation has a Context variant. It returns ctx.Err() without changes
+// in the db if ctx is done.
+//
+// Deleted card ids are not reused by Insert. Suspended cards, and buried cards
+// until their time, are excluded from Due and DueQuery.
+//
+// DueTimes reads the range [from, to) of the due index of the deck, a zero
+// from being the start of the index, and returns in ascending order the time
+// each card in it is shown, in seconds: its due time, or the end of its burial
+// if that is later. Suspended cards, and cards shown at or after to, are
+// excluded.
+//
+// Insert and Update count the lapses of the cards (see Lapses), and Undo
+// restores them. UpdateLeeches is Update that also applies a LeechRule to the
+// updated cards in the same transaction, and returns the new leeches in
+// review order; Undo reverts the marks and suspensions of the rule.
+// MarkLeeches marks as leech the cards with at least threshold lapses and
+// returns the cards that were not marked before. UnmarkLeeches clears the
+// mark and the lapse count, for example after the card was rewritten. Leeches
+// returns the marked cards in card id order.
+//
+// Cards returns the stored state of all the cards of the deck in card id
+// order, also the suspended and buried ones. PutCards writes cards with a
+// given state, for example to import or restore a deck: it creates the deck
+// if needed, replaces the cards with the same ids, and ignores the DueItem of
+// the cards, as the schedule is the Summary of the Item. It does not write
+// the review log.
+//
+// PutLog appends entries to the review log of cards of an existing deck, for
+// example to import the review history of a deck: the DeckId of the entries
+// is ignored, and the card ids must not be greater than the highest card id
+// of the deck. It does not change the cards, and clears the Undo of the deck.
 type Handler interface {
 	Update(r review.Review) (review.Due, error)
+	UpdateLeeches(r review.Review, rule LeechRule) (review.Due, []int, error)
 	Insert(r review.Review, boxId string) (review.Due, error)
 	Due(deckId string, t time.Time) (review.Due, error)
+	DueQuery(q Query) (review.Due, error)
+	DueTimes(deckId string, from, to time.Time) ([]time.Time, error)
+	DeleteCards(deckId string, cardIds []int) error
+	DeleteDeck(deckId string) error
+	Suspend(deckId string, cardIds []int) error
+	Unsuspend(deckId string, cardIds []int) error
+	Bury(deckId string, cardIds []int, until time.Time) error
+	CardLog(deckId string, cardId int) ([]LogEntry, error)
+	DeckLog(deckId string) ([]LogEntry, error)
+	DeckLogRange(deckId string, from, to time.Time) ([]LogEntry, error)
+	Undo(deckId string) (review.Due, error)
+	MarkLeeches(deckId string, cardIds []int, threshold int) ([]int, error)
+	UnmarkLeeches(deckId string, cardIds []int) error
+	Leeches(deckId string) ([]Leech, error)
+	Cards(deckId string) ([]Card, error)
+	PutCards(deckId string, cards []Card) error
+	PutLog(deckId string, entries []LogEntry) error
+
+	UpdateContext(ctx context.Context, r review.Review) (review.Due, error)
+	UpdateLeechesContext(ctx context.Context, r review.Review, rule LeechRule) (review.Due, []int, error)
+	InsertContext(ctx context.Context, r review.Review, boxId string) (review.Due, error)
+	DueContext(ctx context.Context, deckId string, t time.Time) (review.Due, error)
+	DueQueryContext(ctx context.Context, q Query) (review.Due, error)
+	DueTimesContext(ctx context.Context, deckId string, from, to time.Time) ([]time.Time, error)
+	DeleteCardsContext(ctx context.Context, deckId string, cardIds []int) error
+	DeleteDeckContext(ctx context.Context, deckId string) error
+	SuspendContext(ctx context.Context, deckId string, cardIds []int) error
+	UnsuspendContext(ctx context.Context, deckId string, cardIds []int) error
+	BuryContext(ctx context.Context, deckId string, cardIds []int, until time.Time) error
+	CardLogContext(ctx context.Context, deckId string, cardId int) ([]LogEntry, error)
+	DeckLogContext(ctx context.Context, deckId string) ([]LogEntry, error)
+	DeckLogRangeContext(ctx context.Context, deckId string, from, to time.Time) ([]LogEntry, error)
+	UndoContext(ctx context.Context, deckId string) (review.Due, error)
+	MarkLeechesContext(ctx context.Context, deckId string, cardIds []int, threshold int) ([]int, error)
+	UnmarkLeechesContext(ctx context.Context, deckId string, cardIds []int) error
+	LeechesContext(ctx context.Context, deckId string) ([]Leech, error)
+	CardsContext(ctx context.Context, deckId string) ([]Card, error)
+	PutCardsContext(ctx context.Context, deckId string, cards []Card) error
+	PutLogContext(ctx context.Context, deckId string, entries []LogEntry) error
 }
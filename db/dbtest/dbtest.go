@@ -0,0 +1,1555 @@
+// Package dbtest is a conformance test suite for the srs/db.Handler
+// implementations.
+//
+// A backend runs the suite from its tests with a constructor of empty
+// handlers and the constructors of the algos to run them with, for example
+// all the algos of the module:
+//
+//	func TestConformance(t *testing.T) {
+//		dbtest.Run(t, func(t *testing.T, a algo.Algo, c clock.Clock) db.Handler {
+//			return memory.NewWithClock(a, c)
+//		}, dbtest.Algos...)
+//	}
+//
+// Each algo runs with a fake clock. The expected schedules of the cards are
+// computed with the same algo.
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/algo/anki"
+	"github.com/revelaction/go-srs/algo/ebisu"
+	"github.com/revelaction/go-srs/algo/fsrs"
+	"github.com/revelaction/go-srs/algo/hlr"
+	"github.com/revelaction/go-srs/algo/leitner"
+	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+// NewHandler returns an empty Handler that runs the algo a and asks c for
+// the current time. Resources of the handler should be released with
+// t.Cleanup.
+type NewHandler func(t *testing.T, a algo.Algo, c clock.Clock) db.Handler
+
+// failQuality makes the algo given to the handlers fail, to test the
+// atomicity of the writes.
+const failQuality review.Quality = 100
+
+var errFail = errors.New("dbtest: failing review item")
+
+// failingAlgo is an Algo that fails to update items with failQuality. It is a
+// Recaller with the Recall of the algo, including the approximation of
+// algo.Recall for algos that are not.
+type failingAlgo struct {
+	algo.Algo
+}
+
+func (a failingAlgo) Update(old []byte, r review.ReviewItem) ([]byte, error) {
+	if r.Quality == failQuality {
+		return nil, errFail
+	}
+
+	return a.Algo.Update(old, r)
+}
+
+func (a failingAlgo) Recall(old []byte, t time.Time) (float64, error) {
+	return algo.Recall(a.Algo, old, t)
+}
+
+// failingEaser is a failingAlgo of an algo.Easer
+type failingEaser struct {
+	failingAlgo
+}
+
+func (a failingEaser) Ease(old []byte) (float64, error) {
+	return a.Algo.(algo.Easer).Ease(old)
+}
+
+// failingDifficulter is a failingAlgo of an algo.Difficulter
+type failingDifficulter struct {
+	failingAlgo
+}
+
+func (a failingDifficulter) Difficulty(old []byte) (float64, error) {
+	return a.Algo.(algo.Difficulter).Difficulty(old)
+}
+
+// failingEaserDifficulter is a failingAlgo of an algo.Easer and
+// algo.Difficulter
+type failingEaserDifficulter struct {
+	failingEaser
+}
+
+func (a failingEaserDifficulter) Difficulty(old []byte) (float64, error) {
+	return a.Algo.(algo.Difficulter).Difficulty(old)
+}
+
+// failing returns the failingAlgo of a, with the optional interfaces of a
+func failing(a algo.Algo) algo.Algo {
+	fa := failingAlgo{a}
+
+	_, easer := a.(algo.Easer)
+	_, difficulter := a.(algo.Difficulter)
+
+	switch {
+	case easer && difficulter:
+		return failingEaserDifficulter{failingEaser{fa}}
+	case easer:
+		return failingEaser{fa}
+	case difficulter:
+		return failingDifficulter{fa}
+	default:
+		return fa
+	}
+}
+
+// NewAlgo returns an algo that asks c for the current time
+type NewAlgo func(c clock.Clock) algo.Algo
+
+// Algos are the constructors of all the algos of the module
+var Algos = []NewAlgo{
+	func(c clock.Clock) algo.Algo { return sm2.NewWithClock(c) },
+	func(c clock.Clock) algo.Algo { return anki.NewWithClock(c) },
+	func(c clock.Clock) algo.Algo { return fsrs.NewWithClock(c) },
+	func(c clock.Clock) algo.Algo { return leitner.NewWithClock(c) },
+	func(c clock.Clock) algo.Algo { return hlr.NewWithClock(c) },
+	func(c clock.Clock) algo.Algo { return ebisu.NewWithClock(c) },
+}
+
+// start is the time of the fake clock of every test
+var start = time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+const deckId = "hi"
+
+// Run runs the conformance tests of db.Handler with each algo of newAlgos,
+// each test on a new handler. The subtests of an algo are named after its
+// type.
+func Run(t *testing.T, newHandler NewHandler, newAlgos ...NewAlgo) {
+	if len(newAlgos) == 0 {
+		t.Fatal("dbtest: no algo to run the handlers with")
+	}
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake)
+	}{
+		{"InsertNewDeck", testInsertNewDeck},
+		{"InsertAfterMaxId", testInsertAfterMaxId},
+		{"InsertNotExistingDeck", testInsertNotExistingDeck},
+		{"UpdateUnknownCard", testUpdateUnknownCard},
+		{"Errors", testErrors},
+		{"Atomicity", testAtomicity},
+		{"DueFiltering", testDueFiltering},
+		{"DueQuery", testDueQuery},
+		{"SuspendAndBury", testSuspendAndBury},
+		{"DueTimes", testDueTimes},
+		{"ReviewLog", testReviewLog},
+		{"Undo", testUndo},
+		{"Leeches", testLeeches},
+		{"UpdateLeeches", testUpdateLeeches},
+		{"Cards", testCards},
+		{"PutCards", testPutCards},
+		{"PutLog", testPutLog},
+		{"ContextCanceled", testContextCanceled},
+		{"ConcurrentWriters", testConcurrentWriters},
+	}
+
+	for _, newAlgo := range newAlgos {
+		name := strings.TrimPrefix(fmt.Sprintf("%T", newAlgo(clock.NewFake(start))), "*")
+
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range tests {
+				t.Run(tc.name, func(t *testing.T) {
+					c := clock.NewFake(start)
+					a := failing(newAlgo(c))
+					h := newHandler(t, a, c)
+					tc.fn(t, h, a, c)
+				})
+			}
+		})
+	}
+}
+
+// insert inserts n not reviewed cards in the deck
+func insert(t *testing.T, h db.Handler, deckId string, n int) review.Due {
+	t.Helper()
+
+	r := review.Review{}
+	for i := 0; i < n; i++ {
+		r.Items = append(r.Items, review.ReviewItem{Quality: review.NoReview})
+	}
+
+	due, err := h.Insert(r, deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return due
+}
+
+// update reviews the cards of the deck with quality q
+func update(t *testing.T, h db.Handler, deckId string, q review.Quality, cardIds ...int) review.Due {
+	t.Helper()
+
+	r := review.Review{DeckId: deckId}
+	for _, cardId := range cardIds {
+		r.Items = append(r.Items, review.ReviewItem{CardId: cardId, Quality: q})
+	}
+
+	due, err := h.Update(r)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return due
+}
+
+// dueIds returns the card ids of the query
+func dueIds(t *testing.T, h db.Handler, q db.Query) []int {
+	t.Helper()
+
+	due, err := h.DueQuery(q)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return ids(due)
+}
+
+func ids(due review.Due) []int {
+	ids := []int{}
+	for _, item := range due.Items {
+		ids = append(ids, item.CardId)
+	}
+
+	return ids
+}
+
+// schedule returns the schedule of the algo a for the review item ri of a
+// card with the serialized parameters old
+func schedule(t *testing.T, a algo.Algo, old []byte, ri review.ReviewItem) review.DueItem {
+	t.Helper()
+
+	b, err := a.Update(old, ri)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	dueItem, err := a.Summary(b)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return dueItem
+}
+
+// item returns the stored parameters of the card
+func item(t *testing.T, h db.Handler, deckId string, cardId int) []byte {
+	t.Helper()
+
+	cards, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	for _, c := range cards {
+		if c.CardId == cardId {
+			return c.Item
+		}
+	}
+
+	t.Fatalf("card %d does not exist", cardId)
+	return nil
+}
+
+// dueBefore returns the ids of the items due strictly before t, most overdue
+// first. The due times are compared in seconds, as the backends store them.
+func dueBefore(items []review.DueItem, t time.Time) []int {
+	due := []review.DueItem{}
+	for _, item := range items {
+		if item.Due.Unix() < t.Unix() {
+			due = append(due, item)
+		}
+	}
+
+	db.Query{}.Sort(due, nil)
+	return ids(review.Due{Items: due})
+}
+
+func checkIds(t *testing.T, got, want []int) {
+	t.Helper()
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+}
+
+func checkErr(t *testing.T, err, want error) {
+	t.Helper()
+
+	if !errors.Is(err, want) {
+		t.Errorf("\ngot error %v\nwant %v", err, want)
+	}
+}
+
+// testInsertNewDeck tests that new decks start with card id 1, and that
+// Insert returns the schedule of the cards
+func testInsertNewDeck(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	due := insert(t, h, deckId, 2)
+
+	if due.DeckId != deckId {
+		t.Errorf("\ngot %#v\nwant %#v", due.DeckId, deckId)
+	}
+
+	checkIds(t, ids(due), []int{1, 2})
+
+	want := schedule(t, a, nil, review.ReviewItem{CardId: 1, Quality: review.NoReview})
+	if due.Items[0] != want {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items[0], want)
+	}
+
+	if due.Items[0].State != review.StateNew {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items[0].State, review.StateNew)
+	}
+}
+
+// testInsertAfterMaxId tests that Insert continues after the highest card id
+// ever inserted, also if it was deleted
+func testInsertAfterMaxId(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	insert(t, h, deckId, 2)
+
+	if err := h.DeleteCards(deckId, []int{2}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// existing deck in the review
+	r := review.Review{DeckId: deckId, Items: []review.ReviewItem{{Quality: review.NoReview}, {Quality: review.NoReview}}}
+	due, err := h.Insert(r, "")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, ids(due), []int{3, 4})
+
+	// existing deck in the deckId argument
+	checkIds(t, ids(insert(t, h, deckId, 1)), []int{5})
+
+	// other decks have their own ids
+	checkIds(t, ids(insert(t, h, deckId+"2", 1)), []int{1})
+}
+
+// testInsertNotExistingDeck tests that Insert without deckId argument needs
+// an existing deck
+func testInsertNotExistingDeck(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	r := review.Review{DeckId: deckId, Items: []review.ReviewItem{{Quality: review.NoReview}}}
+
+	_, err := h.Insert(r, "")
+	checkErr(t, err, db.ErrDeckIdNotExists)
+
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId, T: start.AddDate(0, 0, 2)}), []int{})
+}
+
+// testUpdateUnknownCard tests that Update of not existing cards or decks fails
+func testUpdateUnknownCard(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	insert(t, h, deckId, 1)
+
+	r := review.Review{DeckId: deckId, Items: []review.ReviewItem{{CardId: 2, Quality: review.CorrectEasy}}}
+	_, err := h.Update(r)
+	checkErr(t, err, db.ErrCardIdNotExists)
+
+	r.DeckId = "other"
+	r.Items[0].CardId = 1
+	_, err = h.Update(r)
+	checkErr(t, err, db.ErrCardIdNotExists)
+}
+
+// testErrors tests the error values of the other methods
+func testErrors(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	insert(t, h, deckId, 1)
+
+	checkErr(t, h.DeleteDeck("other"), db.ErrDeckIdNotExists)
+	checkErr(t, h.DeleteCards(deckId, []int{2}), db.ErrCardIdNotExists)
+	checkErr(t, h.DeleteCards("other", []int{1}), db.ErrCardIdNotExists)
+	checkErr(t, h.Suspend(deckId, []int{2}), db.ErrCardIdNotExists)
+	checkErr(t, h.Unsuspend(deckId, []int{2}), db.ErrCardIdNotExists)
+	checkErr(t, h.Bury(deckId, []int{2}, time.Time{}), db.ErrCardIdNotExists)
+	checkErr(t, h.UnmarkLeeches(deckId, []int{2}), db.ErrCardIdNotExists)
+
+	_, err := h.MarkLeeches(deckId, []int{1, 2}, 1)
+	checkErr(t, err, db.ErrCardIdNotExists)
+
+	_, err = h.Undo(deckId)
+	checkErr(t, err, db.ErrNothingToUndo)
+
+	_, err = h.Undo("other")
+	checkErr(t, err, db.ErrNothingToUndo)
+
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}}}
+	_, err = h.Insert(r, "other\x00")
+	checkErr(t, err, db.ErrInvalidDeckId)
+
+	cards, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkErr(t, h.PutCards("", cards), db.ErrInvalidDeckId)
+	checkErr(t, h.PutCards("other\x00", cards), db.ErrInvalidDeckId)
+	checkErr(t, h.DeleteDeck("other\x00"), db.ErrDeckIdNotExists)
+}
+
+// testAtomicity tests that a failing write has no effect
+func testAtomicity(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	// the deck is not created
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}, {Quality: failQuality}}}
+	_, err := h.Insert(r, deckId)
+	checkErr(t, err, errFail)
+
+	checkErr(t, h.DeleteDeck(deckId), db.ErrDeckIdNotExists)
+
+	insert(t, h, deckId, 2)
+	c.Advance(24 * time.Hour)
+
+	// the first card is not updated
+	r = review.Review{DeckId: deckId, Items: []review.ReviewItem{
+		{CardId: 1, Quality: review.CorrectEasy},
+		{CardId: 2, Quality: failQuality},
+	}}
+
+	_, err = h.Update(r)
+	checkErr(t, err, errFail)
+
+	r.Items[1] = review.ReviewItem{CardId: 3, Quality: review.CorrectEasy}
+	_, err = h.Update(r)
+	checkErr(t, err, db.ErrCardIdNotExists)
+
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId, T: start.AddDate(0, 0, 1).Add(time.Second)}), []int{1, 2})
+
+	entries, err := h.DeckLog(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(entries), 2)
+	}
+
+	_, err = h.Undo(deckId)
+	checkErr(t, err, db.ErrNothingToUndo)
+
+	// the first card is not deleted
+	checkErr(t, h.DeleteCards(deckId, []int{1, 3}), db.ErrCardIdNotExists)
+	checkErr(t, h.Suspend(deckId, []int{1, 3}), db.ErrCardIdNotExists)
+
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId, T: start.AddDate(0, 0, 1).Add(time.Second)}), []int{1, 2})
+}
+
+// testDueFiltering tests that Due returns the cards of the deck due strictly
+// before t, most overdue first
+func testDueFiltering(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	inserted := insert(t, h, deckId, 3)
+
+	// a deck whose id has the deck id as prefix
+	insert(t, h, deckId+"2", 1)
+
+	// card 1 is reviewed one hour later
+	c.Advance(time.Hour)
+	want := schedule(t, a, item(t, h, deckId, 1), review.ReviewItem{CardId: 1, Quality: review.IncorrectFamiliar})
+
+	due := update(t, h, deckId, review.IncorrectFamiliar, 1)
+	if due.Items[0] != want {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items[0], want)
+	}
+
+	items := []review.DueItem{want, inserted.Items[1], inserted.Items[2]}
+
+	// around the due times of the new cards and of card 1
+	newDue, reviewedDue := inserted.Items[1].Due, want.Due
+	last := newDue
+	if reviewedDue.After(last) {
+		last = reviewedDue
+	}
+
+	last = last.Add(24 * time.Hour)
+
+	for _, tt := range []time.Time{newDue, newDue.Add(time.Second), reviewedDue, reviewedDue.Add(time.Second), last} {
+		due, err := h.Due(deckId, tt)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if due.DeckId != deckId {
+			t.Errorf("\ngot %#v\nwant %#v", due.DeckId, deckId)
+		}
+
+		checkIds(t, ids(due), dueBefore(items, tt))
+	}
+
+	// the last Due has the schedule of the cards
+	due, err := h.Due(deckId, last)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	db.Query{}.Sort(items, nil)
+	if fmt.Sprint(due.Items) != fmt.Sprint(items) {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items, items)
+	}
+
+	// zero time is the clock time
+	due, err = h.Due(deckId, time.Time{})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, ids(due), dueBefore(items, c.Now()))
+
+	c.Set(last)
+
+	if err := h.DeleteCards(deckId, []int{3}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	due, err = h.Due(deckId, time.Time{})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, ids(due), dueBefore([]review.DueItem{want, inserted.Items[1]}, last))
+
+	// unknown decks have no due cards
+	due, err = h.Due("other", time.Time{})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, ids(due), []int{})
+}
+
+// testDueQuery tests the orders and pages of DueQuery
+func testDueQuery(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	insert(t, h, deckId, 5)
+
+	// cards 5 and 4 are reviewed later, in that order
+	for _, cardId := range []int{5, 4} {
+		c.Advance(time.Hour)
+		update(t, h, deckId, review.IncorrectFamiliar, cardId)
+	}
+
+	c.Advance(48 * time.Hour)
+
+	cards, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	var items []review.DueItem
+	recall := map[int]float64{}
+	for _, card := range cards {
+		items = append(items, card.DueItem)
+
+		recall[card.CardId], err = algo.Recall(a, card.Item, c.Now())
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+	}
+
+	// the cards without review have no recall, with every algo
+	for _, cardId := range []int{1, 2, 3} {
+		if recall[cardId] != 0 {
+			t.Errorf("\ncard %d: got %#v\nwant %#v", cardId, recall[cardId], 0.0)
+		}
+	}
+
+	// all the cards are due
+	overdue := dueBefore(items, c.Now())
+	if len(overdue) != 5 {
+		t.Fatalf("\nChecking len:\ngot %d\nwant %d", len(overdue), 5)
+	}
+
+	db.Query{Order: db.OrderRetrievability}.Sort(items, recall)
+	byRecall := ids(review.Due{Items: items})
+
+	tests := []struct {
+		q    db.Query
+		want []int
+	}{
+		{q: db.Query{DeckId: deckId}, want: overdue},
+		{q: db.Query{DeckId: deckId, Limit: 2}, want: overdue[:2]},
+		{q: db.Query{DeckId: deckId, Offset: 2, Limit: 2}, want: overdue[2:4]},
+		{q: db.Query{DeckId: deckId, Offset: 4, Limit: 2}, want: overdue[4:]},
+		{q: db.Query{DeckId: deckId, Offset: 5}, want: []int{}},
+		{q: db.Query{DeckId: deckId, Order: db.OrderCardId}, want: []int{1, 2, 3, 4, 5}},
+		{q: db.Query{DeckId: deckId, Order: db.OrderCardId, Offset: 3}, want: []int{4, 5}},
+		{q: db.Query{DeckId: deckId, Order: db.OrderRetrievability, Limit: 3}, want: byRecall[:3]},
+		{q: db.Query{DeckId: deckId, T: start.AddDate(0, 0, 1).Add(time.Hour)}, want: dueBefore(items, start.AddDate(0, 0, 1).Add(time.Hour))},
+	}
+
+	for _, tc := range tests {
+		if got := dueIds(t, h, tc.q); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("\nquery %#v\ngot %v\nwant %v", tc.q, got, tc.want)
+		}
+	}
+
+	// the random order depends only on the seed, and its pages do not
+	// overlap
+	q := db.Query{DeckId: deckId, Order: db.OrderRandom, Seed: 7}
+	all := dueIds(t, h, q)
+
+	q.Limit = 3
+	page := dueIds(t, h, q)
+	q.Offset = 3
+	page = append(page, dueIds(t, h, q)...)
+
+	checkIds(t, page, all)
+
+	if len(all) != 5 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(all), 5)
+	}
+}
+
+// testSuspendAndBury tests that suspended and buried cards are not due
+func testSuspendAndBury(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	c.Set(start.Add(10 * time.Hour))
+	now := c.Now()
+
+	insert(t, h, deckId, 4)
+
+	if err := h.Suspend(deckId, []int{1}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// buried until the start of the 2020-11-02
+	if err := h.Bury(deckId, []int{2}, time.Time{}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// buried until the 2020-11-03 12:00
+	if err := h.Bury(deckId, []int{3}, now.Add(50*time.Hour)); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	tests := []struct {
+		q    db.Query
+		want []int
+	}{
+		{q: db.Query{DeckId: deckId, T: now.AddDate(0, 0, 2)}, want: []int{2, 4}},
+		{q: db.Query{DeckId: deckId, T: now.AddDate(0, 0, 2), Limit: 1}, want: []int{2}},
+		{q: db.Query{DeckId: deckId, T: now.AddDate(0, 0, 2), Offset: 1}, want: []int{4}},
+		{q: db.Query{DeckId: deckId, T: now.AddDate(0, 0, 3)}, want: []int{2, 3, 4}},
+	}
+
+	for _, tc := range tests {
+		if got := dueIds(t, h, tc.q); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("\nquery %#v\ngot %v\nwant %v", tc.q, got, tc.want)
+		}
+	}
+
+	// suspended cards keep their schedule
+	if err := h.Unsuspend(deckId, []int{1, 3}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId, T: now.AddDate(0, 0, 2)}), []int{1, 2, 3, 4})
+}
+
+// testDueTimes tests that DueTimes returns the shown times of the cards due in
+// the range, buried cards at the end of the burial and without the suspended
+// cards
+func testDueTimes(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	inserted := insert(t, h, deckId, 4)
+
+	// the new cards have the same due time
+	d := time.Unix(inserted.Items[0].Due.Unix(), 0).UTC()
+
+	if err := h.Suspend(deckId, []int{1}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := h.Bury(deckId, []int{2}, d.Add(48*time.Hour)); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	tests := []struct {
+		from, to time.Time
+		want     []time.Time
+	}{
+		{from: time.Time{}, to: d, want: []time.Time{}},
+		{from: time.Time{}, to: d.Add(time.Second), want: []time.Time{d, d}},
+		{from: d, to: d.Add(time.Second), want: []time.Time{d, d}},
+		{from: d.Add(time.Second), to: d.Add(72 * time.Hour), want: []time.Time{}},
+		{from: time.Time{}, to: d.Add(48 * time.Hour), want: []time.Time{d, d}},
+		{from: time.Time{}, to: d.Add(72 * time.Hour), want: []time.Time{d, d, d.Add(48 * time.Hour)}},
+	}
+
+	for _, tc := range tests {
+		got, err := h.DueTimes(deckId, tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("\nrange %v %v\ngot %v\nwant %v", tc.from, tc.to, got, tc.want)
+		}
+	}
+
+	// unknown decks have no due cards
+	got, err := h.DueTimes("other", time.Time{}, d.Add(72*time.Hour))
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("\ngot %v\nwant no times", got)
+	}
+}
+
+// testReviewLog tests that Insert and Update append the review items to the
+// log of the cards
+func testReviewLog(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	inserted := insert(t, h, deckId, 2).Items[1]
+
+	c.Advance(24 * time.Hour)
+	easy := schedule(t, a, item(t, h, deckId, 2), review.ReviewItem{CardId: 2, Quality: review.CorrectEasy})
+
+	r := review.Review{DeckId: deckId, Items: []review.ReviewItem{
+		{CardId: 2, Quality: review.CorrectEasy, Elapsed: 3 * time.Second},
+		{CardId: 1, Quality: review.IncorrectFamiliar, ReviewedAt: start.Add(time.Hour)},
+	}}
+
+	res, err := h.Update(r)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// the response is in the order of the review, not of ReviewedAt
+	got := []int{}
+	for _, item := range res.Items {
+		got = append(got, item.CardId)
+	}
+
+	checkIds(t, got, []int{2, 1})
+
+	entries, err := h.CardLog(deckId, 2)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("\nChecking len:\ngot %d\nwant %d", len(entries), 2)
+	}
+
+	want := db.LogEntry{
+		DeckId:     deckId,
+		CardId:     2,
+		Quality:    review.NoReview,
+		ReviewedAt: start,
+		Interval:   inserted.Interval,
+		Due:        inserted.Due,
+	}
+
+	if got := entries[0]; got != want {
+		t.Errorf("\ngot %#v\nwant %#v", got, want)
+	}
+
+	want = db.LogEntry{
+		DeckId:       deckId,
+		CardId:       2,
+		Quality:      review.CorrectEasy,
+		ReviewedAt:   start.AddDate(0, 0, 1),
+		Elapsed:      3 * time.Second,
+		PrevInterval: inserted.Interval,
+		PrevDue:      inserted.Due,
+		Interval:     easy.Interval,
+		Due:          easy.Due,
+	}
+
+	if got := entries[1]; got != want {
+		t.Errorf("\ngot %#v\nwant %#v", got, want)
+	}
+
+	// the deck log is in review order
+	entries, err = h.DeckLog(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	gotLog := []string{}
+	for _, e := range entries {
+		gotLog = append(gotLog, fmt.Sprintf("%d:%d", e.CardId, e.Quality))
+	}
+
+	wantLog := []string{"1:0", "2:0", "1:2", "2:6"}
+	if fmt.Sprint(gotLog) != fmt.Sprint(wantLog) {
+		t.Errorf("\ngot %v\nwant %v", gotLog, wantLog)
+	}
+
+	// ranges of the deck log, the end excluded
+	ranges := []struct {
+		from, to time.Time
+		want     []string
+	}{
+		{time.Time{}, start.Add(time.Hour), []string{"1:0", "2:0"}},
+		{start.Add(time.Hour), start.AddDate(0, 0, 1), []string{"1:2"}},
+		{start.Add(time.Hour), start.AddDate(0, 0, 2), []string{"1:2", "2:6"}},
+	}
+
+	for _, tc := range ranges {
+		entries, err := h.DeckLogRange(deckId, tc.from, tc.to)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		gotLog := []string{}
+		for _, e := range entries {
+			gotLog = append(gotLog, fmt.Sprintf("%d:%d", e.CardId, e.Quality))
+		}
+
+		if fmt.Sprint(gotLog) != fmt.Sprint(tc.want) {
+			t.Errorf("\n[%v, %v): got %v\nwant %v", tc.from, tc.to, gotLog, tc.want)
+		}
+	}
+
+	// the card log is in review order, also for reviews logged later
+	r = review.Review{DeckId: deckId, Items: []review.ReviewItem{
+		{CardId: 1, Quality: review.CorrectHard, ReviewedAt: start.Add(30 * time.Minute)},
+	}}
+
+	if _, err := h.Update(r); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	entries, err = h.CardLog(deckId, 1)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	gotLog = []string{}
+	for _, e := range entries {
+		gotLog = append(gotLog, fmt.Sprintf("%d:%d", e.CardId, e.Quality))
+	}
+
+	wantLog = []string{"1:0", "1:4", "1:2"}
+	if fmt.Sprint(gotLog) != fmt.Sprint(wantLog) {
+		t.Errorf("\ngot %v\nwant %v", gotLog, wantLog)
+	}
+
+	// the log of deleted cards is kept, the log of deleted decks is not
+	if err := h.DeleteCards(deckId, []int{1}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	entries, err = h.CardLog(deckId, 1)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(entries) != 3 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(entries), 3)
+	}
+
+	if err := h.DeleteDeck(deckId); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	entries, err = h.DeckLog(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(entries), 0)
+	}
+}
+
+// testUndo tests that Undo restores the cards of the last Updates, and skips
+// deleted cards
+func testUndo(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	inserted := insert(t, h, deckId, 3)
+
+	c.Advance(24 * time.Hour)
+	first := update(t, h, deckId, review.CorrectEasy, 1, 3)
+
+	c.Advance(48 * time.Hour)
+
+	// card 1 is reviewed twice: the state before the first review is
+	// restored
+	r := review.Review{DeckId: deckId, Items: []review.ReviewItem{
+		{CardId: 1, Quality: review.CorrectEasy},
+		{CardId: 2, Quality: review.IncorrectBlackout},
+		{CardId: 1, Quality: review.IncorrectBlackout, ReviewedAt: c.Now().Add(time.Minute)},
+	}}
+
+	if _, err := h.Update(r); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	due, err := h.Undo(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	want := []review.DueItem{first.Items[0], inserted.Items[1]}
+	if fmt.Sprint(due.Items) != fmt.Sprint(want) {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items, want)
+	}
+
+	entries, err := h.CardLog(deckId, 1)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(entries), 2)
+	}
+
+	// card 2 is due again as inserted
+	restored := []review.DueItem{first.Items[0], inserted.Items[1], first.Items[1]}
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId}), dueBefore(restored, c.Now()))
+
+	// card 3 is deleted, card 1 is restored as inserted
+	if err := h.DeleteCards(deckId, []int{3}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	due, err = h.Undo(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	want = []review.DueItem{inserted.Items[0]}
+	if fmt.Sprint(due.Items) != fmt.Sprint(want) {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items, want)
+	}
+
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId}), dueBefore(inserted.Items[:2], c.Now()))
+
+	_, err = h.Undo(deckId)
+	checkErr(t, err, db.ErrNothingToUndo)
+
+	// Insert clears the undo: the Update before it is kept
+	update(t, h, deckId, review.CorrectEasy, 1)
+	insert(t, h, deckId, 1)
+
+	_, err = h.Undo(deckId)
+	checkErr(t, err, db.ErrNothingToUndo)
+
+	entries, err = h.CardLog(deckId, 1)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(entries), 2)
+	}
+}
+
+// testLeeches tests that Insert and Update count the lapses of the cards, and
+// the marking of the leeches
+func testLeeches(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.IncorrectBlackout}, {Quality: review.CorrectEasy}}}
+	if _, err := h.Insert(r, deckId); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// card 1: correct, incorrect (lapse), incorrect, correct, incorrect
+	// (lapse). Card 2: incorrect (lapse)
+	qualities := []review.Quality{
+		review.CorrectEasy, review.IncorrectBlackout, review.IncorrectFamiliar,
+		review.CorrectHard, review.IncorrectEasy,
+	}
+
+	r = review.Review{DeckId: deckId}
+	for i, q := range qualities {
+		r.Items = append(r.Items, review.ReviewItem{CardId: 1, Quality: q, ReviewedAt: start.Add(time.Duration(i+1) * time.Hour)})
+	}
+
+	r.Items = append(r.Items, review.ReviewItem{CardId: 2, Quality: review.IncorrectBlackout, ReviewedAt: start.Add(time.Hour)})
+
+	if _, err := h.Update(r); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	mark := func(threshold int, want []int) {
+		t.Helper()
+
+		marked, err := h.MarkLeeches(deckId, []int{1, 2}, threshold)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		checkIds(t, append([]int{}, marked...), want)
+	}
+
+	leeches := func(want []db.Leech) {
+		t.Helper()
+
+		got, err := h.Leeches(deckId)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("\ngot %#v\nwant %#v", got, want)
+		}
+	}
+
+	mark(3, []int{})
+	leeches([]db.Leech{})
+
+	mark(2, []int{1})
+	mark(2, []int{})
+	leeches([]db.Leech{{CardId: 1, Lapses: 2}})
+
+	// suspend does not change the lapses
+	if err := h.Suspend(deckId, []int{2}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := h.Unsuspend(deckId, []int{2}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	mark(1, []int{2})
+	leeches([]db.Leech{{CardId: 1, Lapses: 2}, {CardId: 2, Lapses: 1}})
+
+	// unmarked cards count the lapses again
+	if err := h.UnmarkLeeches(deckId, []int{1, 2}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	leeches([]db.Leech{})
+	mark(1, []int{})
+
+	// card 1 was incorrect: the next incorrect review is not a lapse
+	c.Advance(24 * time.Hour)
+	update(t, h, deckId, review.IncorrectBlackout, 1)
+	update(t, h, deckId, review.CorrectEasy, 2)
+	update(t, h, deckId, review.IncorrectBlackout, 2)
+
+	mark(1, []int{2})
+
+	// undo restores the lapses before the last Update
+	if _, err := h.Undo(deckId); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	leeches([]db.Leech{})
+	mark(1, []int{})
+
+	// deleted cards are not leeches
+	update(t, h, deckId, review.IncorrectBlackout, 2)
+	mark(1, []int{2})
+
+	if err := h.DeleteCards(deckId, []int{2}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	leeches([]db.Leech{})
+}
+
+// testUpdateLeeches tests that UpdateLeeches marks and suspends the new
+// leeches of the Update, and that Undo reverts them
+func testUpdateLeeches(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	r := review.Review{Items: []review.ReviewItem{
+		{Quality: review.CorrectEasy}, {Quality: review.CorrectEasy}, {Quality: review.CorrectEasy},
+	}}
+
+	if _, err := h.Insert(r, deckId); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := h.Suspend(deckId, []int{3}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// suspended cards: 1 by the rule, 3 before the Update
+	suspended := func(want []int) {
+		t.Helper()
+
+		cards, err := h.Cards(deckId)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		got := []int{}
+		for _, c := range cards {
+			if c.Suspended {
+				got = append(got, c.CardId)
+			}
+		}
+
+		checkIds(t, got, want)
+	}
+
+	// the leeches are returned in review order
+	r = review.Review{DeckId: deckId, Items: []review.ReviewItem{
+		{CardId: 3, Quality: review.IncorrectBlackout, ReviewedAt: start.Add(2 * time.Hour)},
+		{CardId: 2, Quality: review.CorrectEasy, ReviewedAt: start.Add(time.Hour)},
+		{CardId: 1, Quality: review.IncorrectBlackout, ReviewedAt: start.Add(time.Hour)},
+	}}
+
+	_, leeches, err := h.UpdateLeeches(r, db.LeechRule{Threshold: 1, Suspend: true})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, append([]int{}, leeches...), []int{1, 3})
+	suspended([]int{1, 3})
+
+	got, err := h.Leeches(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if want := []db.Leech{{CardId: 1, Lapses: 1}, {CardId: 3, Lapses: 1}}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("\ngot %#v\nwant %#v", got, want)
+	}
+
+	// undo only unsuspends the cards suspended by the rule
+	if _, err := h.Undo(deckId); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	suspended([]int{3})
+
+	got, err = h.Leeches(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("\ngot %#v\nwant no leeches", got)
+	}
+
+	// the zero rule does not mark leeches
+	_, leeches, err = h.UpdateLeeches(r, db.LeechRule{})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, append([]int{}, leeches...), []int{})
+	suspended([]int{3})
+}
+
+// testCards tests that Cards returns the state of the existing cards, also
+// the hidden ones
+func testCards(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	r := review.Review{Items: []review.ReviewItem{
+		{Quality: review.CorrectEasy}, {Quality: review.NoReview}, {Quality: review.NoReview}, {Quality: review.NoReview},
+	}}
+
+	if _, err := h.Insert(r, deckId); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if err := h.DeleteCards(deckId, []int{4}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	c.Advance(24 * time.Hour)
+	due := update(t, h, deckId, review.IncorrectBlackout, 1)
+
+	if err := h.Suspend(deckId, []int{2}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	buriedUntil := start.Add(50 * time.Hour)
+	if err := h.Bury(deckId, []int{3}, buriedUntil); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	cards, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(cards) != 3 {
+		t.Fatalf("\nChecking len:\ngot %d\nwant %d", len(cards), 3)
+	}
+
+	for i, card := range cards {
+		if card.CardId != i+1 {
+			t.Errorf("\ngot cardId %d\nwant cardId %d", card.CardId, i+1)
+		}
+
+		if len(card.Item) == 0 {
+			t.Errorf("card %d: got empty item", card.CardId)
+		}
+	}
+
+	if cards[0].DueItem != due.Items[0] {
+		t.Errorf("\ngot %#v\nwant %#v", cards[0].DueItem, due.Items[0])
+	}
+
+	if want := (db.Lapses{Count: 1}); cards[0].Lapses != want {
+		t.Errorf("\ngot %#v\nwant %#v", cards[0].Lapses, want)
+	}
+
+	if cards[0].Suspended || !cards[0].BuriedUntil.IsZero() {
+		t.Errorf("card 1: got suspended %v, buried until %v", cards[0].Suspended, cards[0].BuriedUntil)
+	}
+
+	if !cards[1].Suspended || cards[1].State != review.StateNew {
+		t.Errorf("card 2: got suspended %v, state %v", cards[1].Suspended, cards[1].State)
+	}
+
+	if !cards[2].BuriedUntil.Equal(buriedUntil) {
+		t.Errorf("\ngot %v\nwant %v", cards[2].BuriedUntil, buriedUntil)
+	}
+
+	cards, err = h.Cards("other")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(cards) != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(cards), 0)
+	}
+}
+
+// testPutCards tests that PutCards writes the state of the cards, in new and
+// existing decks
+func testPutCards(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	insert(t, h, deckId, 2)
+
+	before, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	c.Advance(24 * time.Hour)
+	update(t, h, deckId, review.CorrectEasy, 1)
+
+	cards, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// copy the deck with other flags and lapses
+	cards[0].Lapses = db.Lapses{Count: 3, Leech: true}
+	cards[0].BuriedUntil = start.Add(24 * time.Hour)
+	cards[1].Suspended = true
+
+	if err := h.PutCards("other", cards); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	got, err := h.Cards("other")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(got) != len(cards) {
+		t.Fatalf("\nChecking len:\ngot %d\nwant %d", len(got), len(cards))
+	}
+
+	for i := range got {
+		if got[i].DueItem != cards[i].DueItem || string(got[i].Item) != string(cards[i].Item) ||
+			got[i].Suspended != cards[i].Suspended || !got[i].BuriedUntil.Equal(cards[i].BuriedUntil) ||
+			got[i].Lapses != cards[i].Lapses {
+			t.Errorf("\ngot %#v\nwant %#v", got[i], cards[i])
+		}
+	}
+
+	checkIds(t, dueIds(t, h, db.Query{DeckId: "other", T: start.AddDate(1, 0, 0)}), []int{1})
+
+	leeches, err := h.Leeches("other")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if want := []db.Leech{{CardId: 1, Lapses: 3}}; fmt.Sprint(leeches) != fmt.Sprint(want) {
+		t.Errorf("\ngot %#v\nwant %#v", leeches, want)
+	}
+
+	// Insert continues after the put cards
+	checkIds(t, ids(insert(t, h, "other", 1)), []int{3})
+
+	// replaced cards move in the due index
+	if err := h.PutCards(deckId, before[:1]); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId, T: start.AddDate(0, 0, 1).Add(time.Second)}), []int{1, 2})
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId, T: start.AddDate(1, 0, 0)}), []int{1, 2})
+
+	// the card id must be the one of the algo parameters
+	invalid := []db.Card{cards[0], {Item: cards[0].Item}, cards[1]}
+	invalid[1].CardId = 2
+	checkErr(t, h.PutCards("third", invalid), db.ErrInvalidCardId)
+
+	invalid[1].CardId = 0
+	checkErr(t, h.PutCards("third", invalid), db.ErrInvalidCardId)
+
+	got, err = h.Cards("third")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(got), 0)
+	}
+}
+
+// testPutLog tests that PutLog appends entries to the log of existing cards in
+// review order, without changing the cards, and clears the undo
+func testPutLog(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	insert(t, h, deckId, 2)
+
+	c.Advance(24 * time.Hour)
+	update(t, h, deckId, review.CorrectEasy, 1)
+
+	before, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// reviews before the cards were inserted, with another deck id
+	entries := []db.LogEntry{
+		{DeckId: "other", CardId: 2, Quality: review.CorrectHard, ReviewedAt: start.Add(-24 * time.Hour)},
+		{DeckId: "other", CardId: 1, Quality: review.IncorrectEasy, ReviewedAt: start.Add(-48 * time.Hour)},
+	}
+
+	if err := h.PutLog(deckId, entries); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	got, err := h.DeckLog(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	gotLog := []string{}
+	for _, e := range got {
+		gotLog = append(gotLog, fmt.Sprintf("%s:%d:%d", e.DeckId, e.CardId, e.Quality))
+	}
+
+	wantLog := []string{"hi:1:3", "hi:2:4", "hi:1:0", "hi:2:0", "hi:1:6"}
+	if fmt.Sprint(gotLog) != fmt.Sprint(wantLog) {
+		t.Errorf("\ngot %v\nwant %v", gotLog, wantLog)
+	}
+
+	cards, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if fmt.Sprint(cards) != fmt.Sprint(before) {
+		t.Errorf("\ngot %#v\nwant %#v", cards, before)
+	}
+
+	// the Update before PutLog can not be undone
+	_, err = h.Undo(deckId)
+	checkErr(t, err, db.ErrNothingToUndo)
+
+	// the deck and the cards must exist, nothing is written on error
+	checkErr(t, h.PutLog("other", entries), db.ErrDeckIdNotExists)
+	checkErr(t, h.PutLog(deckId, []db.LogEntry{entries[0], {CardId: 3}}), db.ErrCardIdNotExists)
+	checkErr(t, h.PutLog(deckId, []db.LogEntry{entries[0], {CardId: 0}}), db.ErrCardIdNotExists)
+
+	got, err = h.DeckLog(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(got) != len(wantLog) {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(got), len(wantLog))
+	}
+}
+
+// testContextCanceled tests that a done context aborts the operations without
+// changes in the db
+func testContextCanceled(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	insert(t, h, deckId, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := review.Review{DeckId: deckId, Items: []review.ReviewItem{{CardId: 1, Quality: review.CorrectEasy}}}
+
+	_, err := h.UpdateContext(ctx, r)
+	checkErr(t, err, context.Canceled)
+
+	_, err = h.InsertContext(ctx, r, "")
+	checkErr(t, err, context.Canceled)
+
+	_, err = h.InsertContext(ctx, r, "other")
+	checkErr(t, err, context.Canceled)
+
+	checkErr(t, h.DeleteCardsContext(ctx, deckId, []int{1}), context.Canceled)
+	checkErr(t, h.DeleteDeckContext(ctx, deckId), context.Canceled)
+
+	_, err = h.DueContext(ctx, deckId, start.AddDate(0, 0, 2))
+	checkErr(t, err, context.Canceled)
+
+	_, err = h.DueQueryContext(ctx, db.Query{DeckId: deckId, T: start.AddDate(0, 0, 2)})
+	checkErr(t, err, context.Canceled)
+
+	_, err = h.DueTimesContext(ctx, deckId, time.Time{}, start.AddDate(0, 0, 2))
+	checkErr(t, err, context.Canceled)
+
+	_, err = h.DeckLogContext(ctx, deckId)
+	checkErr(t, err, context.Canceled)
+
+	_, err = h.DeckLogRangeContext(ctx, deckId, time.Time{}, start.AddDate(0, 0, 2))
+	checkErr(t, err, context.Canceled)
+
+	cards, err := h.Cards(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	_, err = h.CardsContext(ctx, deckId)
+	checkErr(t, err, context.Canceled)
+
+	checkErr(t, h.PutCardsContext(ctx, "other", cards), context.Canceled)
+	checkErr(t, h.PutLogContext(ctx, deckId, []db.LogEntry{{CardId: 1}}), context.Canceled)
+
+	checkErr(t, h.SuspendContext(ctx, deckId, []int{1}), context.Canceled)
+	checkErr(t, h.BuryContext(ctx, deckId, []int{2}, time.Time{}), context.Canceled)
+	checkErr(t, h.UnsuspendContext(ctx, deckId, []int{1}), context.Canceled)
+
+	_, err = h.CardLogContext(ctx, deckId, 1)
+	checkErr(t, err, context.Canceled)
+
+	_, err = h.MarkLeechesContext(ctx, deckId, []int{1, 2}, 0)
+	checkErr(t, err, context.Canceled)
+
+	checkErr(t, h.UnmarkLeechesContext(ctx, deckId, []int{1}), context.Canceled)
+
+	_, err = h.LeechesContext(ctx, deckId)
+	checkErr(t, err, context.Canceled)
+
+	// nothing changed: the two inserted cards are due, only the Insert is
+	// logged
+	checkIds(t, dueIds(t, h, db.Query{DeckId: deckId, T: start.AddDate(0, 0, 1).Add(time.Second)}), []int{1, 2})
+	checkErr(t, h.DeleteDeck("other"), db.ErrDeckIdNotExists)
+
+	entries, err := h.DeckLog(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(entries) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(entries), 2)
+	}
+
+	leeches, err := h.Leeches(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(leeches) != 0 {
+		t.Errorf("\ngot %#v\nwant no leeches", leeches)
+	}
+
+	// the Update can still be undone
+	update(t, h, deckId, review.CorrectEasy, 1)
+
+	_, err = h.UndoContext(ctx, deckId)
+	checkErr(t, err, context.Canceled)
+
+	due, err := h.Undo(deckId)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	checkIds(t, ids(due), []int{1})
+}
+
+// testConcurrentWriters tests that concurrent writes are not lost or mixed.
+//
+// Writers of different decks must all succeed. Writers of the same deck may
+// fail if the backend detects a conflict, but the succeeded ones have
+// distinct card ids.
+func testConcurrentWriters(t *testing.T, h db.Handler, a algo.Algo, c *clock.Fake) {
+	const writers = 8
+	const cards = 5
+
+	insert(t, h, deckId, 1)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	inserted := []int{1}
+
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+
+			r := review.Review{}
+			for j := 0; j < cards; j++ {
+				r.Items = append(r.Items, review.ReviewItem{Quality: review.NoReview})
+			}
+
+			due, err := h.Insert(r, fmt.Sprintf("deck%d", i))
+			if err != nil {
+				t.Errorf("got unexpected error %s", err)
+				return
+			}
+
+			checkIds(t, ids(due), []int{1, 2, 3, 4, 5})
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			r := review.Review{DeckId: deckId}
+			for j := 0; j < cards; j++ {
+				r.Items = append(r.Items, review.ReviewItem{Quality: review.NoReview})
+			}
+
+			due, err := h.Insert(r, "")
+			if err != nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			inserted = append(inserted, ids(due)...)
+		}()
+	}
+
+	wg.Wait()
+
+	for i := 0; i < writers; i++ {
+		q := db.Query{DeckId: fmt.Sprintf("deck%d", i), T: start.AddDate(0, 0, 2), Order: db.OrderCardId}
+		checkIds(t, dueIds(t, h, q), []int{1, 2, 3, 4, 5})
+	}
+
+	if len(inserted) == 1 {
+		t.Errorf("got no successful concurrent Insert in the same deck")
+	}
+
+	got := dueIds(t, h, db.Query{DeckId: deckId, T: start.AddDate(0, 0, 2), Order: db.OrderCardId})
+	if len(got) != len(inserted) {
+		t.Fatalf("\nChecking len:\ngot %d\nwant %d", len(got), len(inserted))
+	}
+
+	seen := map[int]bool{}
+	for _, cardId := range inserted {
+		if seen[cardId] {
+			t.Errorf("got card id %d inserted twice", cardId)
+		}
+
+		seen[cardId] = true
+	}
+
+	for _, cardId := range got {
+		if !seen[cardId] {
+			t.Errorf("got not inserted card id %d", cardId)
+		}
+	}
+}
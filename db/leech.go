@@ -0,0 +1,55 @@
+package db
+
+import (
+	"github.com/revelaction/go-srs/review"
+)
+
+// Lapses is the lapse count of a card. Handlers store it with the algo
+// parameters of the card, and update it in Insert and Update.
+type Lapses struct {
+	// Count is the number of lapses: incorrect reviews after a correct one
+	Count int
+
+	// Correct reports if the last review of the card was correct
+	Correct bool
+
+	// Leech is set by MarkLeeches and by the LeechRule of an Update
+	Leech bool
+}
+
+// Review returns the lapses after a review of quality q. NoReview items do not
+// change them.
+func (l Lapses) Review(q review.Quality) Lapses {
+	if q == review.NoReview {
+		return l
+	}
+
+	if l.Correct && !q.Correct() {
+		l.Count++
+	}
+
+	l.Correct = q.Correct()
+	return l
+}
+
+// Leech is a card marked as leech
+type Leech struct {
+	CardId int
+	Lapses int
+}
+
+// LeechRule is applied by UpdateLeeches to the updated cards, in the
+// transaction of the Update. A zero Threshold disables it.
+type LeechRule struct {
+	// Threshold is the number of lapses that makes a card a leech
+	Threshold int
+
+	// Suspend suspends the new leeches. Undo unsuspends them.
+	Suspend bool
+}
+
+// Marks reports if the rule marks as leech a card with lapses l, that is not
+// marked yet.
+func (r LeechRule) Marks(l Lapses) bool {
+	return r.Threshold > 0 && !l.Leech && l.Count >= r.Threshold
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+func TestLapsesReview(t *testing.T) {
+	qualities := []review.Quality{
+		review.IncorrectBlackout, // first review incorrect: no lapse
+		review.CorrectHard,
+		review.NoReview,
+		review.IncorrectEasy, // lapse
+		review.IncorrectFamiliar,
+		review.CorrectEasy,
+		review.IncorrectBlackout, // lapse
+	}
+
+	l := Lapses{}
+	for _, q := range qualities {
+		l = l.Review(q)
+	}
+
+	want := Lapses{Count: 2, Correct: false}
+	if l != want {
+		t.Errorf("\ngot %#v\nwant %#v", l, want)
+	}
+
+	// the leech mark is kept
+	l = Lapses{Count: 1, Correct: true, Leech: true}.Review(review.IncorrectBlackout)
+	want = Lapses{Count: 2, Correct: false, Leech: true}
+	if l != want {
+		t.Errorf("\ngot %#v\nwant %#v", l, want)
+	}
+}
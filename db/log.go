@@ -0,0 +1,66 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+// LogEntry is the immutable record of a review.ReviewItem applied to a card,
+// with the schedule of the card before and after the review.
+type LogEntry struct {
+	DeckId  string
+	CardId  int
+	Quality review.Quality
+
+	// ReviewedAt is the time of the review
+	ReviewedAt time.Time
+
+	// Elapsed is the time taken to answer, if given in the review
+	Elapsed time.Duration
+
+	// PrevInterval and PrevDue are zero for new cards
+	PrevInterval time.Duration
+	PrevDue      time.Time
+
+	Interval time.Duration
+	Due      time.Time
+}
+
+// NewLogEntry returns the log entry of the review item ri of the deck, that
+// changed the schedule of the card from prev to next.
+func NewLogEntry(deckId string, ri review.ReviewItem, reviewedAt time.Time, prev, next review.DueItem) LogEntry {
+	return LogEntry{
+		DeckId:       deckId,
+		CardId:       ri.CardId,
+		Quality:      ri.Quality,
+		ReviewedAt:   reviewedAt,
+		Elapsed:      ri.Elapsed,
+		PrevInterval: prev.Interval,
+		PrevDue:      prev.Due,
+		Interval:     next.Interval,
+		Due:          next.Due,
+	}
+}
+
+// LogRange returns the entries reviewed in the range [from, to), a zero from
+// being the start of the log, in their order. It reuses the array of entries.
+func LogRange(entries []LogEntry, from, to time.Time) []LogEntry {
+	res := entries[:0]
+	for _, e := range entries {
+		if !e.ReviewedAt.Before(from) && e.ReviewedAt.Before(to) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// SortLog sorts in place the log entries of a deck or a card in review order.
+// Entries reviewed at the same time keep their order.
+func SortLog(entries []LogEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ReviewedAt.Before(entries[j].ReviewedAt)
+	})
+}
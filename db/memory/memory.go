@@ -0,0 +1,884 @@
+// Package memory implements the srs/db.Handler interface with maps. It has
+// the same semantics as the badger backend, without persistence.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+// DefaultUndoDepth is the UndoDepth of new Handlers
+const DefaultUndoDepth = 10
+
+// Handler is an in-memory db.
+//
+// All methods are atomic: a mutex serializes them, and writes are applied
+// only after all the cards of the review were computed.
+type Handler struct {
+	Algo  algo.Algo
+	Clock clock.Clock
+
+	// UndoDepth is the number of Update calls per deck that can be undone.
+	// Zero disables Undo.
+	UndoDepth int
+
+	mu    sync.Mutex
+	decks map[string]*deck
+}
+
+type deck struct {
+	// maxCardId is the highest card id ever inserted in the deck
+	maxCardId int
+
+	cards map[int]card
+	flags map[int]flags
+	log   map[int][]db.LogEntry
+	undo  []snapshot
+}
+
+type card struct {
+	// item is the serialized algo parameters
+	item []byte
+
+	// due is the Summary of item
+	due review.DueItem
+
+	lapses db.Lapses
+}
+
+type flags struct {
+	suspended   bool
+	buriedUntil time.Time
+}
+
+// hidden reports if the card is excluded from the due cards at time t
+func (f flags) hidden(t time.Time) bool {
+	return f.suspended || f.buriedUntil.After(t)
+}
+
+// snapshot contains the state of the cards before an Update
+type snapshot struct {
+	cards []snapshotCard
+}
+
+type snapshotCard struct {
+	cardId int
+	card   card
+
+	// logLen is the number of log entries of the card before the Update
+	logLen int
+
+	// suspended reports if the Update suspended the card as leech
+	suspended bool
+}
+
+// New returns a Handler with the system clock
+func New(algo algo.Algo) *Handler {
+	return NewWithClock(algo, clock.Real{})
+}
+
+// NewWithClock returns a Handler that asks c for the current time. The
+// algo should share the same clock.
+func NewWithClock(algo algo.Algo, c clock.Clock) *Handler {
+	return &Handler{
+		Algo:      algo,
+		Clock:     c,
+		UndoDepth: DefaultUndoDepth,
+		decks:     map[string]*deck{},
+	}
+}
+
+func newDeck() *deck {
+	return &deck{
+		cards: map[int]card{},
+		flags: map[int]flags{},
+		log:   map[int][]db.LogEntry{},
+	}
+}
+
+// Insert runs the Algo on all cards of a Review, and saves them after the
+// last card id of the deck. A non empty deckId creates the deck if it does
+// not exist; otherwise r.DeckId must exist. It clears the undo snapshots of
+// the deck.
+func (h *Handler) Insert(r review.Review, deckId string) (review.Due, error) {
+	return h.InsertContext(context.Background(), r, deckId)
+}
+
+// InsertContext is Insert with a context.
+func (h *Handler) InsertContext(ctx context.Context, r review.Review, deckId string) (res review.Due, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if deckId != "" {
+		if !db.ValidDeckId(deckId) {
+			return res, db.ErrInvalidDeckId
+		}
+
+		r.DeckId = deckId
+	}
+
+	d, ok := h.decks[r.DeckId]
+	if !ok {
+		if deckId == "" {
+			return res, db.ErrDeckIdNotExists
+		}
+
+		d = newDeck()
+	}
+
+	res.DeckId = r.DeckId
+
+	cards := map[int]card{}
+	var entries []db.LogEntry
+
+	for idx, ri := range r.Items {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		// cardId must be injected, to be properly encoded in the Algo native struct
+		ri.CardId = d.maxCardId + idx + 1
+
+		c, err := h.newCard(nil, ri)
+		if err != nil {
+			return res, err
+		}
+
+		c.lapses = c.lapses.Review(ri.Quality)
+
+		cards[ri.CardId] = c
+		entries = append(entries, db.NewLogEntry(r.DeckId, ri, ri.Time(h.Clock.Now()), review.DueItem{}, c.due))
+		res.Items = append(res.Items, c.due)
+	}
+
+	for cardId, c := range cards {
+		d.cards[cardId] = c
+	}
+
+	for _, e := range entries {
+		d.log[e.CardId] = append(d.log[e.CardId], e)
+	}
+
+	d.maxCardId += len(r.Items)
+	d.undo = nil
+	h.decks[r.DeckId] = d
+
+	return res, nil
+}
+
+// Update runs the Algo on the existing cards of the review r. Items of the
+// same card are applied in the order they were reviewed.
+func (h *Handler) Update(r review.Review) (review.Due, error) {
+	return h.UpdateContext(context.Background(), r)
+}
+
+// UpdateContext is Update with a context.
+func (h *Handler) UpdateContext(ctx context.Context, r review.Review) (review.Due, error) {
+	due, _, err := h.UpdateLeechesContext(ctx, r, db.LeechRule{})
+	return due, err
+}
+
+// UpdateLeeches is Update that also marks as leech the updated cards that
+// reach the threshold of rule, and returns them.
+func (h *Handler) UpdateLeeches(r review.Review, rule db.LeechRule) (review.Due, []int, error) {
+	return h.UpdateLeechesContext(context.Background(), r, rule)
+}
+
+// UpdateLeechesContext is UpdateLeeches with a context.
+func (h *Handler) UpdateLeechesContext(ctx context.Context, r review.Review, rule db.LeechRule) (due review.Due, leeches []int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	due.DeckId = r.DeckId
+
+	d, ok := h.decks[r.DeckId]
+	if !ok {
+		return due, nil, db.ErrCardIdNotExists
+	}
+
+	// updated cards, applied at the end
+	cards := map[int]card{}
+	var entries []db.LogEntry
+	var snap snapshot
+
+	due.Items = make([]review.DueItem, len(r.Items))
+
+	// Items of the same card are applied in the order they were reviewed,
+	// the response keeps the order of the review
+	for _, i := range r.ChronologicalOrder() {
+		ri := r.Items[i]
+
+		if err := ctx.Err(); err != nil {
+			return due, nil, err
+		}
+
+		old, ok := cards[ri.CardId]
+		if !ok {
+			old, ok = d.cards[ri.CardId]
+			if !ok {
+				return due, nil, db.ErrCardIdNotExists
+			}
+
+			// only the state before the first review of the card is restored
+			snap.cards = append(snap.cards, snapshotCard{cardId: ri.CardId, card: old, logLen: len(d.log[ri.CardId])})
+		}
+
+		c, err := h.newCard(old.item, ri)
+		if err != nil {
+			return due, nil, err
+		}
+
+		c.lapses = old.lapses.Review(ri.Quality)
+
+		cards[ri.CardId] = c
+		entries = append(entries, db.NewLogEntry(r.DeckId, ri, ri.Time(h.Clock.Now()), old.due, c.due))
+		due.Items[i] = c.due
+	}
+
+	for k, sc := range snap.cards {
+		c := cards[sc.cardId]
+		if !rule.Marks(c.lapses) {
+			continue
+		}
+
+		c.lapses.Leech = true
+		cards[sc.cardId] = c
+		leeches = append(leeches, sc.cardId)
+
+		if f := d.flags[sc.cardId]; rule.Suspend && !f.suspended {
+			f.suspended = true
+			d.flags[sc.cardId] = f
+			snap.cards[k].suspended = true
+		}
+	}
+
+	for cardId, c := range cards {
+		d.cards[cardId] = c
+	}
+
+	for _, e := range entries {
+		d.log[e.CardId] = append(d.log[e.CardId], e)
+	}
+
+	if h.UndoDepth > 0 {
+		d.undo = append(d.undo, snap)
+		if len(d.undo) > h.UndoDepth {
+			d.undo = d.undo[len(d.undo)-h.UndoDepth:]
+		}
+	}
+
+	return due, leeches, nil
+}
+
+// Due returns the due cards for the time t, most overdue first. A zero t
+// means the current time of the Handler Clock.
+func (h *Handler) Due(deckId string, t time.Time) (review.Due, error) {
+	return h.DueQueryContext(context.Background(), db.Query{DeckId: deckId, T: t})
+}
+
+// DueContext is Due with a context.
+func (h *Handler) DueContext(ctx context.Context, deckId string, t time.Time) (review.Due, error) {
+	return h.DueQueryContext(ctx, db.Query{DeckId: deckId, T: t})
+}
+
+// DueQuery returns the page of due cards of the query q.
+func (h *Handler) DueQuery(q db.Query) (review.Due, error) {
+	return h.DueQueryContext(context.Background(), q)
+}
+
+// DueQueryContext is DueQuery with a context.
+func (h *Handler) DueQueryContext(ctx context.Context, q db.Query) (due review.Due, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	due.DeckId = q.DeckId
+
+	t := q.T
+	if t.IsZero() {
+		t = h.Clock.Now()
+	}
+
+	d, ok := h.decks[q.DeckId]
+	if !ok {
+		return due, nil
+	}
+
+	var items []review.DueItem
+	recall := map[int]float64{}
+
+	for cardId, c := range d.cards {
+		if err := ctx.Err(); err != nil {
+			return due, err
+		}
+
+		if c.due.Due.Unix() >= t.Unix() || d.flags[cardId].hidden(t) {
+			continue
+		}
+
+		if q.Order == db.OrderRetrievability {
+			recall[cardId], err = algo.Recall(h.Algo, c.item, t)
+			if err != nil {
+				return due, err
+			}
+		}
+
+		items = append(items, c.due)
+	}
+
+	q.Sort(items, recall)
+	due.Items = q.Page(items)
+
+	return due, nil
+}
+
+// DueTimes returns the times the cards of the deck due in [from, to) are
+// shown. A zero from means the start of the deck.
+func (h *Handler) DueTimes(deckId string, from, to time.Time) ([]time.Time, error) {
+	return h.DueTimesContext(context.Background(), deckId, from, to)
+}
+
+// DueTimesContext is DueTimes with a context.
+func (h *Handler) DueTimesContext(ctx context.Context, deckId string, from, to time.Time) ([]time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	times := []time.Time{}
+
+	d, ok := h.decks[deckId]
+	if !ok {
+		return times, nil
+	}
+
+	for cardId, c := range d.cards {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		dueUnix := c.due.Due.Unix()
+		if dueUnix >= to.Unix() || !from.IsZero() && dueUnix < from.Unix() {
+			continue
+		}
+
+		f := d.flags[cardId]
+		if f.suspended {
+			continue
+		}
+
+		shown := dueUnix
+		if f.buriedUntil.Unix() > shown {
+			shown = f.buriedUntil.Unix()
+		}
+
+		if shown >= to.Unix() {
+			continue
+		}
+
+		times = append(times, time.Unix(shown, 0).UTC())
+	}
+
+	db.SortTimes(times)
+	return times, nil
+}
+
+// DeleteCards deletes the cards of the deck. All the cards must exist. The
+// review log of the cards is kept.
+func (h *Handler) DeleteCards(deckId string, cardIds []int) error {
+	return h.DeleteCardsContext(context.Background(), deckId, cardIds)
+}
+
+// DeleteCardsContext is DeleteCards with a context.
+func (h *Handler) DeleteCardsContext(ctx context.Context, deckId string, cardIds []int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.cards(deckId, cardIds)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, cardId := range cardIds {
+		delete(d.cards, cardId)
+		delete(d.flags, cardId)
+	}
+
+	return nil
+}
+
+// DeleteDeck deletes the deck with all its cards, flags, review log and undo
+// snapshots.
+func (h *Handler) DeleteDeck(deckId string) error {
+	return h.DeleteDeckContext(context.Background(), deckId)
+}
+
+// DeleteDeckContext is DeleteDeck with a context.
+func (h *Handler) DeleteDeckContext(ctx context.Context, deckId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.decks[deckId]; !ok {
+		return db.ErrDeckIdNotExists
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delete(h.decks, deckId)
+	return nil
+}
+
+// Suspend hides the cards from the due queries until Unsuspend.
+func (h *Handler) Suspend(deckId string, cardIds []int) error {
+	return h.SuspendContext(context.Background(), deckId, cardIds)
+}
+
+// SuspendContext is Suspend with a context.
+func (h *Handler) SuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateFlags(ctx, deckId, cardIds, func(f *flags) {
+		f.suspended = true
+	})
+}
+
+// Unsuspend shows again suspended or buried cards in the due queries.
+func (h *Handler) Unsuspend(deckId string, cardIds []int) error {
+	return h.UnsuspendContext(context.Background(), deckId, cardIds)
+}
+
+// UnsuspendContext is Unsuspend with a context.
+func (h *Handler) UnsuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateFlags(ctx, deckId, cardIds, func(f *flags) {
+		*f = flags{}
+	})
+}
+
+// Bury hides the cards from the due queries until the time until. A zero
+// until means the start of the next day of the Handler Clock.
+func (h *Handler) Bury(deckId string, cardIds []int, until time.Time) error {
+	return h.BuryContext(context.Background(), deckId, cardIds, until)
+}
+
+// BuryContext is Bury with a context.
+func (h *Handler) BuryContext(ctx context.Context, deckId string, cardIds []int, until time.Time) error {
+	if until.IsZero() {
+		now := h.Clock.Now()
+		until = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	}
+
+	return h.updateFlags(ctx, deckId, cardIds, func(f *flags) {
+		f.buriedUntil = until
+	})
+}
+
+func (h *Handler) updateFlags(ctx context.Context, deckId string, cardIds []int, fn func(f *flags)) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.cards(deckId, cardIds)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, cardId := range cardIds {
+		f := d.flags[cardId]
+		fn(&f)
+		d.setFlags(cardId, f)
+	}
+
+	return nil
+}
+
+// setFlags saves the flags of the card. Zero flags are deleted.
+func (d *deck) setFlags(cardId int, f flags) {
+	if f == (flags{}) {
+		delete(d.flags, cardId)
+		return
+	}
+
+	d.flags[cardId] = f
+}
+
+// CardLog returns the review log of the card, in review order.
+func (h *Handler) CardLog(deckId string, cardId int) ([]db.LogEntry, error) {
+	return h.CardLogContext(context.Background(), deckId, cardId)
+}
+
+// CardLogContext is CardLog with a context.
+func (h *Handler) CardLogContext(ctx context.Context, deckId string, cardId int) ([]db.LogEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := []db.LogEntry{}
+	if d, ok := h.decks[deckId]; ok {
+		entries = append(entries, d.log[cardId]...)
+	}
+
+	db.SortLog(entries)
+	return entries, nil
+}
+
+// DeckLog returns the review log of all the cards of the deck, in review
+// order.
+func (h *Handler) DeckLog(deckId string) ([]db.LogEntry, error) {
+	return h.DeckLogContext(context.Background(), deckId)
+}
+
+// DeckLogContext is DeckLog with a context.
+func (h *Handler) DeckLogContext(ctx context.Context, deckId string) ([]db.LogEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := []db.LogEntry{}
+
+	d, ok := h.decks[deckId]
+	if !ok {
+		return entries, nil
+	}
+
+	for cardId := 1; cardId <= d.maxCardId; cardId++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, d.log[cardId]...)
+	}
+
+	db.SortLog(entries)
+	return entries, nil
+}
+
+// DeckLogRange returns the entries of the review log of the deck reviewed in
+// the range [from, to), a zero from being the start of the log, in review
+// order.
+func (h *Handler) DeckLogRange(deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	return h.DeckLogRangeContext(context.Background(), deckId, from, to)
+}
+
+// DeckLogRangeContext is DeckLogRange with a context.
+func (h *Handler) DeckLogRangeContext(ctx context.Context, deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	entries, err := h.DeckLogContext(ctx, deckId)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.LogRange(entries, from, to), nil
+}
+
+// Cards returns the stored state of all the cards of the deck, in card id
+// order.
+func (h *Handler) Cards(deckId string) ([]db.Card, error) {
+	return h.CardsContext(context.Background(), deckId)
+}
+
+// CardsContext is Cards with a context.
+func (h *Handler) CardsContext(ctx context.Context, deckId string) ([]db.Card, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cards := []db.Card{}
+
+	d, ok := h.decks[deckId]
+	if !ok {
+		return cards, nil
+	}
+
+	for cardId := 1; cardId <= d.maxCardId; cardId++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		c, ok := d.cards[cardId]
+		if !ok {
+			continue
+		}
+
+		f := d.flags[cardId]
+		cards = append(cards, db.Card{
+			DueItem:     c.due,
+			Item:        append([]byte{}, c.item...),
+			Suspended:   f.suspended,
+			BuriedUntil: f.buriedUntil,
+			Lapses:      c.lapses,
+		})
+	}
+
+	return cards, nil
+}
+
+// PutCards writes the cards in the deck, creating it if it does not exist.
+// Cards with the same ids are replaced.
+func (h *Handler) PutCards(deckId string, cards []db.Card) error {
+	return h.PutCardsContext(context.Background(), deckId, cards)
+}
+
+// PutCardsContext is PutCards with a context.
+func (h *Handler) PutCardsContext(ctx context.Context, deckId string, cards []db.Card) error {
+	if !db.ValidDeckId(deckId) {
+		return db.ErrInvalidDeckId
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	put := map[int]card{}
+	for _, c := range cards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dueItem, err := h.Algo.Summary(c.Item)
+		if err != nil {
+			return err
+		}
+
+		if c.CardId < 1 || dueItem.CardId != c.CardId {
+			return db.ErrInvalidCardId
+		}
+
+		put[c.CardId] = card{item: append([]byte{}, c.Item...), due: dueItem, lapses: c.Lapses}
+	}
+
+	d, ok := h.decks[deckId]
+	if !ok {
+		d = newDeck()
+		h.decks[deckId] = d
+	}
+
+	for _, c := range cards {
+		d.cards[c.CardId] = put[c.CardId]
+
+		if f := (flags{suspended: c.Suspended, buriedUntil: c.BuriedUntil}); f != (flags{}) {
+			d.flags[c.CardId] = f
+		} else {
+			delete(d.flags, c.CardId)
+		}
+
+		if c.CardId > d.maxCardId {
+			d.maxCardId = c.CardId
+		}
+	}
+
+	return nil
+}
+
+// PutLog appends the entries to the review log of the deck, and clears its
+// undo snapshots. The cards are not changed.
+func (h *Handler) PutLog(deckId string, entries []db.LogEntry) error {
+	return h.PutLogContext(context.Background(), deckId, entries)
+}
+
+// PutLogContext is PutLog with a context.
+func (h *Handler) PutLogContext(ctx context.Context, deckId string, entries []db.LogEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, ok := h.decks[deckId]
+	if !ok {
+		return db.ErrDeckIdNotExists
+	}
+
+	for _, e := range entries {
+		if e.CardId < 1 || e.CardId > d.maxCardId {
+			return db.ErrCardIdNotExists
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		e.DeckId = deckId
+		d.log[e.CardId] = append(d.log[e.CardId], e)
+	}
+
+	d.undo = nil
+	return nil
+}
+
+// Undo restores the cards of the last Update of the deck, and deletes the
+// review log entries written by it. Cards deleted after the Update are not
+// restored. It returns the restored cards.
+func (h *Handler) Undo(deckId string) (review.Due, error) {
+	return h.UndoContext(context.Background(), deckId)
+}
+
+// UndoContext is Undo with a context.
+func (h *Handler) UndoContext(ctx context.Context, deckId string) (due review.Due, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	due.DeckId = deckId
+
+	d, ok := h.decks[deckId]
+	if !ok || len(d.undo) == 0 {
+		return due, db.ErrNothingToUndo
+	}
+
+	if err := ctx.Err(); err != nil {
+		return due, err
+	}
+
+	snap := d.undo[len(d.undo)-1]
+	d.undo = d.undo[:len(d.undo)-1]
+
+	for _, sc := range snap.cards {
+		if _, ok := d.cards[sc.cardId]; !ok {
+			continue
+		}
+
+		d.cards[sc.cardId] = sc.card
+		d.log[sc.cardId] = d.log[sc.cardId][:sc.logLen]
+
+		if f := d.flags[sc.cardId]; sc.suspended {
+			f.suspended = false
+			d.setFlags(sc.cardId, f)
+		}
+
+		due.Items = append(due.Items, sc.card.due)
+	}
+
+	return due, nil
+}
+
+// MarkLeeches marks as leech the cards with at least threshold lapses, and
+// returns the cards that were not marked before. All the cards must exist.
+func (h *Handler) MarkLeeches(deckId string, cardIds []int, threshold int) ([]int, error) {
+	return h.MarkLeechesContext(context.Background(), deckId, cardIds, threshold)
+}
+
+// MarkLeechesContext is MarkLeeches with a context.
+func (h *Handler) MarkLeechesContext(ctx context.Context, deckId string, cardIds []int, threshold int) ([]int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.cards(deckId, cardIds)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var marked []int
+	for _, cardId := range cardIds {
+		c := d.cards[cardId]
+		if c.lapses.Leech || c.lapses.Count < threshold {
+			continue
+		}
+
+		c.lapses.Leech = true
+		d.cards[cardId] = c
+		marked = append(marked, cardId)
+	}
+
+	return marked, nil
+}
+
+// UnmarkLeeches clears the leech mark and the lapse count of the cards. All
+// the cards must exist.
+func (h *Handler) UnmarkLeeches(deckId string, cardIds []int) error {
+	return h.UnmarkLeechesContext(context.Background(), deckId, cardIds)
+}
+
+// UnmarkLeechesContext is UnmarkLeeches with a context.
+func (h *Handler) UnmarkLeechesContext(ctx context.Context, deckId string, cardIds []int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, err := h.cards(deckId, cardIds)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, cardId := range cardIds {
+		c := d.cards[cardId]
+		c.lapses = db.Lapses{Correct: c.lapses.Correct}
+		d.cards[cardId] = c
+	}
+
+	return nil
+}
+
+// Leeches returns the cards of the deck marked as leech, in card id order.
+func (h *Handler) Leeches(deckId string) ([]db.Leech, error) {
+	return h.LeechesContext(context.Background(), deckId)
+}
+
+// LeechesContext is Leeches with a context.
+func (h *Handler) LeechesContext(ctx context.Context, deckId string) ([]db.Leech, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	leeches := []db.Leech{}
+
+	d, ok := h.decks[deckId]
+	if !ok {
+		return leeches, nil
+	}
+
+	for cardId := 1; cardId <= d.maxCardId; cardId++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if c, ok := d.cards[cardId]; ok && c.lapses.Leech {
+			leeches = append(leeches, db.Leech{CardId: cardId, Lapses: c.lapses.Count})
+		}
+	}
+
+	return leeches, nil
+}
+
+// newCard runs the Algo on the old serialized item
+func (h *Handler) newCard(old []byte, ri review.ReviewItem) (card, error) {
+	b, err := h.Algo.Update(old, ri)
+	if err != nil {
+		return card{}, err
+	}
+
+	dueItem, err := h.Algo.Summary(b)
+	if err != nil {
+		return card{}, err
+	}
+
+	return card{item: b, due: dueItem}, nil
+}
+
+// cards returns the deck if all the cards exist
+func (h *Handler) cards(deckId string, cardIds []int) (*deck, error) {
+	d, ok := h.decks[deckId]
+	if !ok {
+		return nil, db.ErrCardIdNotExists
+	}
+
+	for _, cardId := range cardIds {
+		if _, ok := d.cards[cardId]; !ok {
+			return nil, db.ErrCardIdNotExists
+		}
+	}
+
+	return d, nil
+}
@@ -0,0 +1,203 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/db/dbtest"
+	"github.com/revelaction/go-srs/db/memory"
+	"github.com/revelaction/go-srs/review"
+)
+
+// Handler implements db.Handler
+var _ db.Handler = (*memory.Handler)(nil)
+
+func TestInsertNotExistingDeckId(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	dbh := memory.New(sm2.New(now))
+
+	r := review.Review{DeckId: "hi"}
+	r.Items = []review.ReviewItem{
+		{Quality: 4},
+	}
+
+	_, err := dbh.Insert(r, "")
+	if err != db.ErrDeckIdNotExists {
+		t.Errorf("\ngot error %v\nwant %v", err, db.ErrDeckIdNotExists)
+	}
+}
+
+func TestInsertAndUdpate(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	dbh := memory.NewWithClock(sm2.NewWithClock(c), c)
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: 4},
+		{Quality: 3},
+	}
+
+	deckId := "hi"
+
+	res, err := dbh.Insert(r, deckId)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	for idx, item := range res.Items {
+		wantId := idx + 1
+		if item.CardId != wantId {
+			t.Errorf("\ngot cardId %d\nwant cardId %d", item.CardId, wantId)
+		}
+	}
+
+	// New cards are inserted after the last one
+	r.DeckId = deckId
+	res, err = dbh.Insert(r, "")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if res.Items[0].CardId != 3 {
+		t.Errorf("\ngot cardId %d\nwant cardId %d", res.Items[0].CardId, 3)
+	}
+
+	due, err := dbh.Due(deckId, time.Time{})
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 0)
+	}
+
+	c.Advance(24*time.Hour + time.Second)
+
+	due, err = dbh.Due(deckId, time.Time{})
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 4 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 4)
+	}
+
+	// bad review: due one day later
+	r.Items = []review.ReviewItem{
+		{CardId: 1, Quality: 2},
+		{CardId: 2, Quality: 2},
+	}
+
+	_, err = dbh.Update(r)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	due, err = dbh.Due(deckId, time.Time{})
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(due.Items), 2)
+	}
+
+	r.Items = []review.ReviewItem{
+		{CardId: 5, Quality: 2},
+	}
+
+	_, err = dbh.Update(r)
+	if err != db.ErrCardIdNotExists {
+		t.Errorf("\ngot error %v\nwant %v", err, db.ErrCardIdNotExists)
+	}
+}
+
+func TestUndoAndDelete(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	dbh := memory.NewWithClock(sm2.NewWithClock(c), c)
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+		{Quality: review.NoReview},
+	}
+
+	deckId := "hi"
+
+	inserted, err := dbh.Insert(r, deckId)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	c.Advance(24 * time.Hour)
+
+	r.DeckId = deckId
+	r.Items = []review.ReviewItem{
+		{CardId: 1, Quality: review.CorrectEasy},
+		{CardId: 1, Quality: review.CorrectEasy},
+	}
+
+	_, err = dbh.Update(r)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	due, err := dbh.Undo(deckId)
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 1 || due.Items[0] != inserted.Items[0] {
+		t.Errorf("\ngot %#v\nwant %#v", due.Items, inserted.Items[:1])
+	}
+
+	entries, _ := dbh.CardLog(deckId, 1)
+	if len(entries) != 1 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(entries), 1)
+	}
+
+	if _, err := dbh.Undo(deckId); err != db.ErrNothingToUndo {
+		t.Errorf("\ngot error %v\nwant %v", err, db.ErrNothingToUndo)
+	}
+
+	// deleted card ids are not reused
+	if err := dbh.DeleteCards(deckId, []int{2}); err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+	}
+
+	res, err := dbh.Insert(r, "")
+	if err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if res.Items[0].CardId != 3 {
+		t.Errorf("\ngot cardId %d\nwant cardId %d", res.Items[0].CardId, 3)
+	}
+
+	if err := dbh.DeleteDeck(deckId); err != nil {
+		t.Errorf("got unexpected error %s", err)
+	}
+
+	if err := dbh.DeleteDeck(deckId); err != db.ErrDeckIdNotExists {
+		t.Errorf("\ngot error %v\nwant %v", err, db.ErrDeckIdNotExists)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, a algo.Algo, c clock.Clock) db.Handler {
+		return memory.NewWithClock(a, c)
+	}, dbtest.Algos...)
+}
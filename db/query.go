@@ -0,0 +1,115 @@
+package db
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+// Order is the sort order of the due cards of a Query
+type Order int
+
+const (
+	// OrderOverdue returns the most overdue cards first
+	OrderOverdue Order = iota
+
+	// OrderRetrievability returns the cards with the lowest probability of
+	// recall first. See algo.Recall
+	OrderRetrievability
+
+	// OrderRandom shuffles the due cards with the Query Seed
+	OrderRandom
+
+	// OrderCardId returns the cards in ascending card id order
+	OrderCardId
+)
+
+// Query selects a page of the due cards of a deck.
+type Query struct {
+	DeckId string
+
+	// T is the time of the query. A zero T means the current time of the
+	// handler clock.
+	T time.Time
+
+	Order Order
+
+	// Seed of the OrderRandom shuffle. Pages of queries with the same seed
+	// do not overlap.
+	Seed int64
+
+	// Offset is the number of cards to skip
+	Offset int
+
+	// Limit is the maximum number of cards returned. Zero means no limit.
+	Limit int
+}
+
+// Sort sorts in place the due items in the order of the query. recall
+// contains the probability of recall of each card id, and is only used by
+// OrderRetrievability.
+func (q Query) Sort(items []review.DueItem, recall map[int]float64) {
+
+	byCardId := func(i, j int) bool {
+		return items[i].CardId < items[j].CardId
+	}
+
+	switch q.Order {
+	case OrderRetrievability:
+		sort.SliceStable(items, func(i, j int) bool {
+			ri, rj := recall[items[i].CardId], recall[items[j].CardId]
+			if ri != rj {
+				return ri < rj
+			}
+
+			return byCardId(i, j)
+		})
+	case OrderRandom:
+		// the shuffle does not depend on the order of the backend
+		sort.SliceStable(items, byCardId)
+		rnd := rand.New(rand.NewSource(q.Seed))
+		rnd.Shuffle(len(items), func(i, j int) {
+			items[i], items[j] = items[j], items[i]
+		})
+	case OrderCardId:
+		sort.SliceStable(items, byCardId)
+	default:
+		sort.SliceStable(items, func(i, j int) bool {
+			if !items[i].Due.Equal(items[j].Due) {
+				return items[i].Due.Before(items[j].Due)
+			}
+
+			return byCardId(i, j)
+		})
+	}
+}
+
+// Page returns the items of the page selected by Offset and Limit of the
+// sorted items.
+func (q Query) Page(items []review.DueItem) []review.DueItem {
+	offset := q.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	if offset >= len(items) {
+		return nil
+	}
+
+	items = items[offset:]
+
+	if q.Limit > 0 && q.Limit < len(items) {
+		items = items[:q.Limit]
+	}
+
+	return items
+}
+
+// SortTimes sorts in place the shown times of DueTimes in ascending order.
+func SortTimes(times []time.Time) {
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+}
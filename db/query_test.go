@@ -0,0 +1,102 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/review"
+)
+
+func dueItems() []review.DueItem {
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	return []review.DueItem{
+		{CardId: 3, Due: now.Add(time.Hour)},
+		{CardId: 1, Due: now.Add(2 * time.Hour)},
+		{CardId: 2, Due: now},
+		{CardId: 4, Due: now.Add(time.Hour)},
+	}
+}
+
+func cardIds(items []review.DueItem) []int {
+	ids := []int{}
+	for _, item := range items {
+		ids = append(ids, item.CardId)
+	}
+
+	return ids
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestSort(t *testing.T) {
+
+	recall := map[int]float64{1: 0.5, 2: 0.9, 3: 0.1, 4: 0.5}
+
+	tests := []struct {
+		order Order
+		want  []int
+	}{
+		{order: OrderOverdue, want: []int{2, 3, 4, 1}},
+		{order: OrderRetrievability, want: []int{3, 1, 4, 2}},
+		{order: OrderCardId, want: []int{1, 2, 3, 4}},
+	}
+
+	for _, tc := range tests {
+		items := dueItems()
+		Query{Order: tc.order}.Sort(items, recall)
+
+		if got := cardIds(items); !equal(got, tc.want) {
+			t.Errorf("\norder %d\ngot %v\nwant %v", tc.order, got, tc.want)
+		}
+	}
+}
+
+func TestSortRandomSeed(t *testing.T) {
+
+	items := dueItems()
+	Query{Order: OrderRandom, Seed: 7}.Sort(items, nil)
+
+	// same seed, different initial order
+	other := dueItems()
+	other[0], other[3] = other[3], other[0]
+	Query{Order: OrderRandom, Seed: 7}.Sort(other, nil)
+
+	if !equal(cardIds(items), cardIds(other)) {
+		t.Errorf("\ngot %v\nwant %v", cardIds(other), cardIds(items))
+	}
+}
+
+func TestPage(t *testing.T) {
+
+	tests := []struct {
+		offset int
+		limit  int
+		want   []int
+	}{
+		{offset: 0, limit: 0, want: []int{3, 1, 2, 4}},
+		{offset: 0, limit: 2, want: []int{3, 1}},
+		{offset: 2, limit: 2, want: []int{2, 4}},
+		{offset: 3, limit: 2, want: []int{4}},
+		{offset: 4, limit: 2, want: []int{}},
+		{offset: -1, limit: 1, want: []int{3}},
+	}
+
+	for _, tc := range tests {
+		got := Query{Offset: tc.offset, Limit: tc.limit}.Page(dueItems())
+		if !equal(cardIds(got), tc.want) {
+			t.Errorf("\noffset %d limit %d\ngot %v\nwant %v", tc.offset, tc.limit, cardIds(got), tc.want)
+		}
+	}
+}
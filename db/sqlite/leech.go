@@ -0,0 +1,143 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/revelaction/go-srs/db"
+)
+
+// MarkLeeches marks as leech the cards with at least threshold lapses, and
+// returns the cards that were not marked before. All the cards must exist.
+//
+// MarkLeeches is atomic
+func (h *Handler) MarkLeeches(deckId string, cardIds []int, threshold int) ([]int, error) {
+	return h.MarkLeechesContext(context.Background(), deckId, cardIds, threshold)
+}
+
+// MarkLeechesContext is MarkLeeches with a context. The transaction is rolled
+// back if ctx is done before the commit.
+func (h *Handler) MarkLeechesContext(ctx context.Context, deckId string, cardIds []int, threshold int) ([]int, error) {
+	tx, err := h.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var marked []int
+	for _, cardId := range cardIds {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		_, lapses, err := card(ctx, tx, deckId, cardId)
+		if err != nil {
+			return nil, err
+		}
+
+		if lapses.Leech || lapses.Count < threshold {
+			continue
+		}
+
+		lapses.Leech = true
+		if err := writeLapses(ctx, tx, deckId, cardId, lapses); err != nil {
+			return nil, err
+		}
+
+		marked = append(marked, cardId)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return marked, nil
+}
+
+// UnmarkLeeches clears the leech mark and the lapse count of the cards. All
+// the cards must exist.
+//
+// UnmarkLeeches is atomic
+func (h *Handler) UnmarkLeeches(deckId string, cardIds []int) error {
+	return h.UnmarkLeechesContext(context.Background(), deckId, cardIds)
+}
+
+// UnmarkLeechesContext is UnmarkLeeches with a context. The transaction is
+// rolled back if ctx is done before the commit.
+func (h *Handler) UnmarkLeechesContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateCards(ctx, deckId, cardIds,
+		"UPDATE cards SET lapses = 0, leech = 0 WHERE deck_id = ? AND card_id = ?")
+}
+
+// Leeches returns the cards of the deck marked as leech, in card id order.
+func (h *Handler) Leeches(deckId string) ([]db.Leech, error) {
+	return h.LeechesContext(context.Background(), deckId)
+}
+
+// LeechesContext is Leeches with a context.
+func (h *Handler) LeechesContext(ctx context.Context, deckId string) ([]db.Leech, error) {
+	rows, err := h.Db.QueryContext(ctx, "SELECT card_id, lapses FROM cards WHERE deck_id = ? AND leech = 1 ORDER BY card_id", deckId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	leeches := []db.Leech{}
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var l db.Leech
+		if err := rows.Scan(&l.CardId, &l.Lapses); err != nil {
+			return nil, err
+		}
+
+		leeches = append(leeches, l)
+	}
+
+	return leeches, rows.Err()
+}
+
+// markLeeches applies the rule to the cards of the snapshot of an Update, and
+// returns the new leeches. The snapshot records the suspended cards.
+func markLeeches(ctx context.Context, tx *sql.Tx, deckId string, snap snapshot, rule db.LeechRule) (leeches []int, err error) {
+	for k, sc := range snap.Cards {
+		_, lapses, err := card(ctx, tx, deckId, sc.CardId)
+		if err != nil {
+			return nil, err
+		}
+
+		if !rule.Marks(lapses) {
+			continue
+		}
+
+		lapses.Leech = true
+		if err := writeLapses(ctx, tx, deckId, sc.CardId, lapses); err != nil {
+			return nil, err
+		}
+
+		leeches = append(leeches, sc.CardId)
+
+		if !rule.Suspend {
+			continue
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE cards SET suspended = 1 WHERE deck_id = ? AND card_id = ? AND suspended = 0",
+			deckId, sc.CardId)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+
+		snap.Cards[k].Suspended = n == 1
+	}
+
+	return leeches, nil
+}
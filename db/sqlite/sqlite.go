@@ -0,0 +1,1093 @@
+// Package sqlite implements the srs/db.Handler interface with a SQLite
+// backend, using the pure Go driver modernc.org/sqlite.
+//
+// The db is a single file that other programs can query with SQL. It has the
+// tables:
+//
+//	decks   one row per deck with the max card id
+//	cards   the algo parameters and lapses of each card, with an indexed due
+//	        column
+//	revlog  the review log
+//	undo    the undo snapshots of each deck
+//
+// Times are stored as Unix timestamps: due times in seconds, review log times
+// in nanoseconds.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// registers the "sqlite" driver
+	_ "modernc.org/sqlite"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+// SchemaVersion is the version of the tables, saved in the user_version
+// pragma.
+const SchemaVersion = 2
+
+// DefaultUndoDepth is the UndoDepth of new Handlers
+const DefaultUndoDepth = 10
+
+const schema = `
+CREATE TABLE IF NOT EXISTS decks (
+	deck_id     TEXT PRIMARY KEY,
+	max_card_id INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cards (
+	deck_id      TEXT NOT NULL,
+	card_id      INTEGER NOT NULL,
+	item         BLOB NOT NULL,
+	due          INTEGER NOT NULL,
+	suspended    INTEGER NOT NULL DEFAULT 0,
+	buried_until INTEGER NOT NULL DEFAULT 0,
+	lapses       INTEGER NOT NULL DEFAULT 0,
+	last_correct INTEGER NOT NULL DEFAULT 0,
+	leech        INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (deck_id, card_id)
+);
+
+CREATE INDEX IF NOT EXISTS cards_due ON cards (deck_id, due, card_id);
+
+CREATE TABLE IF NOT EXISTS revlog (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	deck_id       TEXT NOT NULL,
+	card_id       INTEGER NOT NULL,
+	quality       INTEGER NOT NULL,
+	reviewed_at   INTEGER NOT NULL,
+	elapsed       INTEGER NOT NULL,
+	prev_interval INTEGER NOT NULL,
+	prev_due      INTEGER NOT NULL,
+	interval      INTEGER NOT NULL,
+	due           INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS revlog_card ON revlog (deck_id, card_id, id);
+CREATE INDEX IF NOT EXISTS revlog_time ON revlog (deck_id, reviewed_at);
+
+CREATE TABLE IF NOT EXISTS undo (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	deck_id  TEXT NOT NULL,
+	snapshot BLOB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS undo_deck ON undo (deck_id, id);
+`
+
+// migrations upgrade the tables of older versions: migrations[v] from the
+// version v to v+1
+var migrations = map[int]string{
+	1: `CREATE INDEX IF NOT EXISTS revlog_time ON revlog (deck_id, reviewed_at);`,
+}
+
+// Handler is a SQLite client.
+//
+// It accepts an Algo to allow for atomic operations. Every method runs in a
+// single SQL transaction.
+type Handler struct {
+	Db    *sql.DB
+	Algo  algo.Algo
+	Clock clock.Clock
+
+	// UndoDepth is the number of Update calls per deck that can be undone.
+	// Zero disables Undo.
+	UndoDepth int
+}
+
+// snapshot is the value of an undo row. It contains the state of the cards
+// before an Update.
+type snapshot struct {
+	Cards []snapshotCard
+}
+
+type snapshotCard struct {
+	CardId int
+
+	// Item is the serialized algo parameters before the Update
+	Item []byte
+
+	// Lapses before the Update
+	Lapses db.Lapses
+
+	// LogId is the first revlog row of the card written by the Update
+	LogId int64
+
+	// Suspended reports if the Update suspended the card as leech
+	Suspended bool `json:",omitempty"`
+}
+
+// New returns a Handler with the system clock
+func New(db *sql.DB, algo algo.Algo) *Handler {
+	return NewWithClock(db, algo, clock.Real{})
+}
+
+// NewWithClock returns a Handler that asks c for the current time. The
+// algo should share the same clock.
+//
+// The tables must exist. Use Open for new databases.
+func NewWithClock(db *sql.DB, algo algo.Algo, c clock.Clock) *Handler {
+	return &Handler{
+		Db:        db,
+		Algo:      algo,
+		Clock:     c,
+		UndoDepth: DefaultUndoDepth,
+	}
+}
+
+// Open returns a Handler that asks c for the current time, after creating
+// the tables if they do not exist, or upgrading the tables of an older
+// version.
+//
+// The db is opened with the "sqlite" driver. Concurrent writers need a busy
+// timeout and immediate transactions, for example:
+//
+//	sql.Open("sqlite", "srs.db?_pragma=busy_timeout(5000)&_txlock=immediate")
+func Open(db *sql.DB, algo algo.Algo, c clock.Clock) (*Handler, error) {
+	h := NewWithClock(db, algo, c)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return nil, err
+	}
+
+	if version == SchemaVersion {
+		return h, nil
+	}
+
+	if version < 0 || version > SchemaVersion {
+		return nil, fmt.Errorf("unknown schema version %d", version)
+	}
+
+	stmts := []string{schema}
+	if version > 0 {
+		stmts = nil
+		for v := version; v < SchemaVersion; v++ {
+			stmts = append(stmts, migrations[v])
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Insert runs the Algo on all cards of a Review, and saves them after the
+// last card id of the deck. A non empty deckId creates the deck if it does
+// not exist; otherwise r.DeckId must exist. It clears the undo snapshots of
+// the deck.
+//
+// Insert is atomic
+func (h *Handler) Insert(r review.Review, deckId string) (review.Due, error) {
+	return h.InsertContext(context.Background(), r, deckId)
+}
+
+// InsertContext is Insert with a context. The transaction is rolled back if
+// ctx is done before the commit.
+func (h *Handler) InsertContext(ctx context.Context, r review.Review, deckId string) (res review.Due, err error) {
+	if deckId != "" && !db.ValidDeckId(deckId) {
+		return res, db.ErrInvalidDeckId
+	}
+
+	tx, err := h.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+
+	defer tx.Rollback()
+
+	if deckId != "" {
+		r.DeckId = deckId
+	}
+
+	res.DeckId = r.DeckId
+
+	var max int
+	err = tx.QueryRowContext(ctx, "SELECT max_card_id FROM decks WHERE deck_id = ?", r.DeckId).Scan(&max)
+	if err == sql.ErrNoRows {
+		if deckId == "" {
+			return res, db.ErrDeckIdNotExists
+		}
+	} else if err != nil {
+		return res, err
+	}
+
+	for idx, ri := range r.Items {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
+		// cardId must be injected, to be properly encoded in the Algo native struct
+		ri.CardId = max + idx + 1
+
+		b, err := h.Algo.Update(nil, ri)
+		if err != nil {
+			return res, err
+		}
+
+		dueItem, err := h.Algo.Summary(b)
+		if err != nil {
+			return res, err
+		}
+
+		lapses := db.Lapses{}.Review(ri.Quality)
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO cards (deck_id, card_id, item, due, lapses, last_correct)
+			VALUES (?, ?, ?, ?, ?, ?)`, r.DeckId, ri.CardId, b, dueItem.Due.Unix(), lapses.Count, lapses.Correct)
+		if err != nil {
+			return res, err
+		}
+
+		entry := db.NewLogEntry(r.DeckId, ri, ri.Time(h.Clock.Now()), review.DueItem{}, dueItem)
+		if _, err := appendLog(ctx, tx, entry); err != nil {
+			return res, err
+		}
+
+		res.Items = append(res.Items, dueItem)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO decks (deck_id, max_card_id) VALUES (?, ?)
+		ON CONFLICT (deck_id) DO UPDATE SET max_card_id = excluded.max_card_id`,
+		r.DeckId, max+len(r.Items))
+	if err != nil {
+		return res, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM undo WHERE deck_id = ?", r.DeckId); err != nil {
+		return res, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// Update looks up in the db the (must) existing cards in the review r, run the
+// srs algo on them, and saves the updated result in the db. Items of the same
+// card are applied in the order they were reviewed.
+//
+// Update is atomic
+func (h *Handler) Update(r review.Review) (review.Due, error) {
+	return h.UpdateContext(context.Background(), r)
+}
+
+// UpdateContext is Update with a context. The transaction is rolled back if
+// ctx is done before the commit.
+func (h *Handler) UpdateContext(ctx context.Context, r review.Review) (review.Due, error) {
+	due, _, err := h.UpdateLeechesContext(ctx, r, db.LeechRule{})
+	return due, err
+}
+
+// UpdateLeeches is Update that also marks as leech the updated cards that
+// reach the threshold of rule, and returns them.
+//
+// UpdateLeeches is atomic
+func (h *Handler) UpdateLeeches(r review.Review, rule db.LeechRule) (review.Due, []int, error) {
+	return h.UpdateLeechesContext(context.Background(), r, rule)
+}
+
+// UpdateLeechesContext is UpdateLeeches with a context.
+func (h *Handler) UpdateLeechesContext(ctx context.Context, r review.Review, rule db.LeechRule) (due review.Due, leeches []int, err error) {
+	tx, err := h.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return due, nil, err
+	}
+
+	defer tx.Rollback()
+
+	due.DeckId = r.DeckId
+
+	var snap snapshot
+	seen := map[int]bool{}
+
+	due.Items = make([]review.DueItem, len(r.Items))
+
+	// Items of the same card are applied in the order they were reviewed,
+	// the response keeps the order of the review
+	for _, i := range r.ChronologicalOrder() {
+		ri := r.Items[i]
+
+		if err := ctx.Err(); err != nil {
+			return due, nil, err
+		}
+
+		old, lapses, err := card(ctx, tx, r.DeckId, ri.CardId)
+		if err != nil {
+			return due, nil, err
+		}
+
+		oldDueItem, err := h.Algo.Summary(old)
+		if err != nil {
+			return due, nil, err
+		}
+
+		b, err := h.Algo.Update(old, ri)
+		if err != nil {
+			return due, nil, err
+		}
+
+		dueItem, err := h.Algo.Summary(b)
+		if err != nil {
+			return due, nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE cards SET item = ?, due = ? WHERE deck_id = ? AND card_id = ?",
+			b, dueItem.Due.Unix(), r.DeckId, ri.CardId)
+		if err != nil {
+			return due, nil, err
+		}
+
+		if err := writeLapses(ctx, tx, r.DeckId, ri.CardId, lapses.Review(ri.Quality)); err != nil {
+			return due, nil, err
+		}
+
+		entry := db.NewLogEntry(r.DeckId, ri, ri.Time(h.Clock.Now()), oldDueItem, dueItem)
+		logId, err := appendLog(ctx, tx, entry)
+		if err != nil {
+			return due, nil, err
+		}
+
+		// only the state before the first review of the card is restored
+		if !seen[ri.CardId] {
+			seen[ri.CardId] = true
+			snap.Cards = append(snap.Cards, snapshotCard{CardId: ri.CardId, Item: old, Lapses: lapses, LogId: logId})
+		}
+
+		due.Items[i] = dueItem
+	}
+
+	leeches, err = markLeeches(ctx, tx, r.DeckId, snap, rule)
+	if err != nil {
+		return due, nil, err
+	}
+
+	if err := h.pushSnapshot(ctx, tx, r.DeckId, snap); err != nil {
+		return due, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return due, nil, err
+	}
+
+	return due, leeches, nil
+}
+
+// Due returns the due cards for the time t, most overdue first. A zero t
+// means the current time of the Handler Clock.
+func (h *Handler) Due(deckId string, t time.Time) (review.Due, error) {
+	return h.DueQueryContext(context.Background(), db.Query{DeckId: deckId, T: t})
+}
+
+// DueContext is Due with a context.
+func (h *Handler) DueContext(ctx context.Context, deckId string, t time.Time) (review.Due, error) {
+	return h.DueQueryContext(ctx, db.Query{DeckId: deckId, T: t})
+}
+
+// DueQuery returns the page of due cards of the query q.
+//
+// The overdue and card id orders are paged by SQLite with the due index.
+func (h *Handler) DueQuery(q db.Query) (review.Due, error) {
+	return h.DueQueryContext(context.Background(), q)
+}
+
+// DueQueryContext is DueQuery with a context.
+func (h *Handler) DueQueryContext(ctx context.Context, q db.Query) (due review.Due, err error) {
+
+	due.DeckId = q.DeckId
+
+	t := q.T
+	if t.IsZero() {
+		t = h.Clock.Now()
+	}
+
+	query := `SELECT card_id, item FROM cards
+		WHERE deck_id = ? AND due < ? AND suspended = 0 AND buried_until <= ?`
+	args := []any{q.DeckId, t.Unix(), t.Unix()}
+
+	paged := false
+	switch q.Order {
+	case db.OrderOverdue:
+		query += " ORDER BY due, card_id"
+		paged = true
+	case db.OrderCardId:
+		query += " ORDER BY card_id"
+		paged = true
+	}
+
+	if paged {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+
+		offset := q.Offset
+		if offset < 0 {
+			offset = 0
+		}
+
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+
+	rows, err := h.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return due, err
+	}
+
+	defer rows.Close()
+
+	var items []review.DueItem
+	recall := map[int]float64{}
+
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return due, err
+		}
+
+		var cardId int
+		var b []byte
+		if err := rows.Scan(&cardId, &b); err != nil {
+			return due, err
+		}
+
+		dueItem, err := h.Algo.Summary(b)
+		if err != nil {
+			return due, err
+		}
+
+		if q.Order == db.OrderRetrievability {
+			recall[cardId], err = algo.Recall(h.Algo, b, t)
+			if err != nil {
+				return due, err
+			}
+		}
+
+		items = append(items, dueItem)
+	}
+
+	if err := rows.Err(); err != nil {
+		return due, err
+	}
+
+	if paged {
+		due.Items = items
+		return due, nil
+	}
+
+	q.Sort(items, recall)
+	due.Items = q.Page(items)
+
+	return due, nil
+}
+
+// DueTimes returns the times the cards of the deck due in [from, to) are
+// shown. A zero from means the start of the deck. The range is read with the
+// due index.
+func (h *Handler) DueTimes(deckId string, from, to time.Time) ([]time.Time, error) {
+	return h.DueTimesContext(context.Background(), deckId, from, to)
+}
+
+// DueTimesContext is DueTimes with a context.
+func (h *Handler) DueTimesContext(ctx context.Context, deckId string, from, to time.Time) ([]time.Time, error) {
+	query := `SELECT MAX(due, buried_until) AS shown FROM cards
+		WHERE deck_id = ? AND due < ? AND suspended = 0`
+	args := []any{deckId, to.Unix()}
+
+	if !from.IsZero() {
+		query += " AND due >= ?"
+		args = append(args, from.Unix())
+	}
+
+	query += " AND shown < ? ORDER BY shown"
+	args = append(args, to.Unix())
+
+	rows, err := h.Db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	times := []time.Time{}
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var shown int64
+		if err := rows.Scan(&shown); err != nil {
+			return nil, err
+		}
+
+		times = append(times, time.Unix(shown, 0).UTC())
+	}
+
+	return times, rows.Err()
+}
+
+// DeleteCards deletes the cards of the deck. All the cards must exist. The
+// review log of the cards is kept.
+//
+// DeleteCards is atomic
+func (h *Handler) DeleteCards(deckId string, cardIds []int) error {
+	return h.DeleteCardsContext(context.Background(), deckId, cardIds)
+}
+
+// DeleteCardsContext is DeleteCards with a context. The transaction is
+// rolled back if ctx is done before the commit.
+func (h *Handler) DeleteCardsContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateCards(ctx, deckId, cardIds, "DELETE FROM cards WHERE deck_id = ? AND card_id = ?")
+}
+
+// DeleteDeck deletes the deck with all its cards, review log and undo
+// snapshots.
+//
+// DeleteDeck is atomic
+func (h *Handler) DeleteDeck(deckId string) error {
+	return h.DeleteDeckContext(context.Background(), deckId)
+}
+
+// DeleteDeckContext is DeleteDeck with a context. The transaction is rolled
+// back if ctx is done before the commit.
+func (h *Handler) DeleteDeckContext(ctx context.Context, deckId string) error {
+	tx, err := h.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM decks WHERE deck_id = ?", deckId)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return db.ErrDeckIdNotExists
+	}
+
+	for _, table := range []string{"cards", "revlog", "undo"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE deck_id = ?", deckId); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Suspend hides the cards from the due queries until Unsuspend. The cards
+// keep their schedule.
+//
+// Suspend is atomic
+func (h *Handler) Suspend(deckId string, cardIds []int) error {
+	return h.SuspendContext(context.Background(), deckId, cardIds)
+}
+
+// SuspendContext is Suspend with a context. The transaction is rolled back if
+// ctx is done before the commit.
+func (h *Handler) SuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateCards(ctx, deckId, cardIds,
+		"UPDATE cards SET suspended = 1 WHERE deck_id = ? AND card_id = ?")
+}
+
+// Unsuspend shows again suspended or buried cards in the due queries.
+//
+// Unsuspend is atomic
+func (h *Handler) Unsuspend(deckId string, cardIds []int) error {
+	return h.UnsuspendContext(context.Background(), deckId, cardIds)
+}
+
+// UnsuspendContext is Unsuspend with a context. The transaction is rolled
+// back if ctx is done before the commit.
+func (h *Handler) UnsuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.updateCards(ctx, deckId, cardIds,
+		"UPDATE cards SET suspended = 0, buried_until = 0 WHERE deck_id = ? AND card_id = ?")
+}
+
+// Bury hides the cards from the due queries until the time until. A zero
+// until means the start of the next day of the Handler Clock.
+//
+// Bury is atomic
+func (h *Handler) Bury(deckId string, cardIds []int, until time.Time) error {
+	return h.BuryContext(context.Background(), deckId, cardIds, until)
+}
+
+// BuryContext is Bury with a context. The transaction is rolled back if ctx
+// is done before the commit.
+func (h *Handler) BuryContext(ctx context.Context, deckId string, cardIds []int, until time.Time) error {
+	if until.IsZero() {
+		now := h.Clock.Now()
+		until = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	}
+
+	return h.updateCards(ctx, deckId, cardIds,
+		"UPDATE cards SET buried_until = ? WHERE deck_id = ? AND card_id = ?", until.Unix())
+}
+
+// updateCards executes the statement for each card, with the args followed
+// by the deck id and the card id. All the cards must exist.
+func (h *Handler) updateCards(ctx context.Context, deckId string, cardIds []int, stmt string, args ...any) error {
+	tx, err := h.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, cardId := range cardIds {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, stmt, append(args, deckId, cardId)...)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return db.ErrCardIdNotExists
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CardLog returns the review log of the card, in review order.
+func (h *Handler) CardLog(deckId string, cardId int) ([]db.LogEntry, error) {
+	return h.CardLogContext(context.Background(), deckId, cardId)
+}
+
+// CardLogContext is CardLog with a context.
+func (h *Handler) CardLogContext(ctx context.Context, deckId string, cardId int) ([]db.LogEntry, error) {
+	entries, err := h.readLog(ctx, "WHERE deck_id = ? AND card_id = ? ORDER BY id", deckId, cardId)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SortLog(entries)
+	return entries, nil
+}
+
+// DeckLog returns the review log of all the cards of the deck, in review
+// order.
+func (h *Handler) DeckLog(deckId string) ([]db.LogEntry, error) {
+	return h.DeckLogContext(context.Background(), deckId)
+}
+
+// DeckLogContext is DeckLog with a context.
+func (h *Handler) DeckLogContext(ctx context.Context, deckId string) ([]db.LogEntry, error) {
+	entries, err := h.readLog(ctx, "WHERE deck_id = ? ORDER BY card_id, id", deckId)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SortLog(entries)
+	return entries, nil
+}
+
+// DeckLogRange returns the entries of the review log of the deck reviewed in
+// the range [from, to), a zero from being the start of the log, in review
+// order.
+func (h *Handler) DeckLogRange(deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	return h.DeckLogRangeContext(context.Background(), deckId, from, to)
+}
+
+// DeckLogRangeContext is DeckLogRange with a context.
+func (h *Handler) DeckLogRangeContext(ctx context.Context, deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	// the order of DeckLog, that keeps the order of each card
+	return h.readLog(ctx, "WHERE deck_id = ? AND reviewed_at >= ? AND reviewed_at < ? ORDER BY reviewed_at, card_id, id",
+		deckId, toUnixNano(from), toUnixNano(to))
+}
+
+// Cards returns the stored state of all the cards of the deck, in card id
+// order.
+func (h *Handler) Cards(deckId string) ([]db.Card, error) {
+	return h.CardsContext(context.Background(), deckId)
+}
+
+// CardsContext is Cards with a context.
+func (h *Handler) CardsContext(ctx context.Context, deckId string) ([]db.Card, error) {
+	rows, err := h.Db.QueryContext(ctx, `SELECT item, suspended, buried_until, lapses, last_correct, leech
+		FROM cards WHERE deck_id = ? ORDER BY card_id`, deckId)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	cards := []db.Card{}
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var c db.Card
+		var buriedUntil int64
+		err := rows.Scan(&c.Item, &c.Suspended, &buriedUntil,
+			&c.Lapses.Count, &c.Lapses.Correct, &c.Lapses.Leech)
+		if err != nil {
+			return nil, err
+		}
+
+		c.DueItem, err = h.Algo.Summary(c.Item)
+		if err != nil {
+			return nil, err
+		}
+
+		if buriedUntil != 0 {
+			c.BuriedUntil = time.Unix(buriedUntil, 0).UTC()
+		}
+
+		cards = append(cards, c)
+	}
+
+	return cards, rows.Err()
+}
+
+// PutCards writes the cards in the deck, creating it if it does not exist.
+// Cards with the same ids are replaced.
+//
+// PutCards is atomic
+func (h *Handler) PutCards(deckId string, cards []db.Card) error {
+	return h.PutCardsContext(context.Background(), deckId, cards)
+}
+
+// PutCardsContext is PutCards with a context. The transaction is rolled back
+// if ctx is done before the commit.
+func (h *Handler) PutCardsContext(ctx context.Context, deckId string, cards []db.Card) error {
+	if !db.ValidDeckId(deckId) {
+		return db.ErrInvalidDeckId
+	}
+
+	tx, err := h.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var max int
+	err = tx.QueryRowContext(ctx, "SELECT max_card_id FROM decks WHERE deck_id = ?", deckId).Scan(&max)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	for _, c := range cards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dueItem, err := h.Algo.Summary(c.Item)
+		if err != nil {
+			return err
+		}
+
+		if c.CardId < 1 || dueItem.CardId != c.CardId {
+			return db.ErrInvalidCardId
+		}
+
+		var buriedUntil int64
+		if !c.BuriedUntil.IsZero() {
+			buriedUntil = c.BuriedUntil.Unix()
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT OR REPLACE INTO cards (deck_id, card_id, item, due, suspended,
+			buried_until, lapses, last_correct, leech) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			deckId, c.CardId, c.Item, dueItem.Due.Unix(), c.Suspended, buriedUntil,
+			c.Lapses.Count, c.Lapses.Correct, c.Lapses.Leech)
+		if err != nil {
+			return err
+		}
+
+		if c.CardId > max {
+			max = c.CardId
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO decks (deck_id, max_card_id) VALUES (?, ?)
+		ON CONFLICT (deck_id) DO UPDATE SET max_card_id = excluded.max_card_id`,
+		deckId, max)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PutLog appends the entries to the review log of the deck, and clears its
+// undo snapshots. The cards are not changed.
+//
+// PutLog is atomic
+func (h *Handler) PutLog(deckId string, entries []db.LogEntry) error {
+	return h.PutLogContext(context.Background(), deckId, entries)
+}
+
+// PutLogContext is PutLog with a context. The transaction is rolled back if
+// ctx is done before the commit.
+func (h *Handler) PutLogContext(ctx context.Context, deckId string, entries []db.LogEntry) error {
+	tx, err := h.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var max int
+	err = tx.QueryRowContext(ctx, "SELECT max_card_id FROM decks WHERE deck_id = ?", deckId).Scan(&max)
+	if err == sql.ErrNoRows {
+		return db.ErrDeckIdNotExists
+	} else if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if e.CardId < 1 || e.CardId > max {
+			return db.ErrCardIdNotExists
+		}
+
+		e.DeckId = deckId
+		if _, err := appendLog(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM undo WHERE deck_id = ?", deckId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Undo restores the algo parameters of the cards of the last Update of the
+// deck, and deletes the review log entries written by it. Cards deleted
+// after the Update are not restored. It returns the restored cards.
+//
+// Undo is atomic
+func (h *Handler) Undo(deckId string) (review.Due, error) {
+	return h.UndoContext(context.Background(), deckId)
+}
+
+// UndoContext is Undo with a context. The transaction is rolled back if ctx
+// is done before the commit.
+func (h *Handler) UndoContext(ctx context.Context, deckId string) (due review.Due, err error) {
+	due.DeckId = deckId
+
+	tx, err := h.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return due, err
+	}
+
+	defer tx.Rollback()
+
+	var id int64
+	var b []byte
+	err = tx.QueryRowContext(ctx, "SELECT id, snapshot FROM undo WHERE deck_id = ? ORDER BY id DESC LIMIT 1", deckId).Scan(&id, &b)
+	if err == sql.ErrNoRows {
+		return due, db.ErrNothingToUndo
+	}
+
+	if err != nil {
+		return due, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return due, err
+	}
+
+	for _, sc := range snap.Cards {
+		if err := ctx.Err(); err != nil {
+			return due, err
+		}
+
+		dueItem, err := h.Algo.Summary(sc.Item)
+		if err != nil {
+			return due, err
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE cards SET item = ?, due = ? WHERE deck_id = ? AND card_id = ?",
+			sc.Item, dueItem.Due.Unix(), deckId, sc.CardId)
+		if err != nil {
+			return due, err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return due, err
+		} else if n == 0 {
+			continue
+		}
+
+		if err := writeLapses(ctx, tx, deckId, sc.CardId, sc.Lapses); err != nil {
+			return due, err
+		}
+
+		if sc.Suspended {
+			_, err := tx.ExecContext(ctx, "UPDATE cards SET suspended = 0 WHERE deck_id = ? AND card_id = ?", deckId, sc.CardId)
+			if err != nil {
+				return due, err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM revlog WHERE deck_id = ? AND card_id = ? AND id >= ?",
+			deckId, sc.CardId, sc.LogId)
+		if err != nil {
+			return due, err
+		}
+
+		due.Items = append(due.Items, dueItem)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM undo WHERE id = ?", id); err != nil {
+		return due, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return due, err
+	}
+
+	return due, nil
+}
+
+// pushSnapshot saves the snapshot of an Update, and deletes the snapshots
+// older than UndoDepth.
+func (h *Handler) pushSnapshot(ctx context.Context, tx *sql.Tx, deckId string, snap snapshot) error {
+	if h.UndoDepth <= 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO undo (deck_id, snapshot) VALUES (?, ?)", deckId, b); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM undo WHERE deck_id = ? AND id NOT IN
+		(SELECT id FROM undo WHERE deck_id = ? ORDER BY id DESC LIMIT ?)`,
+		deckId, deckId, h.UndoDepth)
+
+	return err
+}
+
+// readLog returns the revlog rows of the where clause
+func (h *Handler) readLog(ctx context.Context, where string, args ...any) ([]db.LogEntry, error) {
+	rows, err := h.Db.QueryContext(ctx, `SELECT deck_id, card_id, quality, reviewed_at, elapsed,
+		prev_interval, prev_due, interval, due FROM revlog `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	entries := []db.LogEntry{}
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var e db.LogEntry
+		var reviewedAt, prevDue, due int64
+		err := rows.Scan(&e.DeckId, &e.CardId, &e.Quality, &reviewedAt, &e.Elapsed,
+			&e.PrevInterval, &prevDue, &e.Interval, &due)
+		if err != nil {
+			return nil, err
+		}
+
+		e.ReviewedAt = fromUnixNano(reviewedAt)
+		e.PrevDue = fromUnixNano(prevDue)
+		e.Due = fromUnixNano(due)
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// appendLog inserts the entry in the revlog, and returns its row id.
+func appendLog(ctx context.Context, tx *sql.Tx, e db.LogEntry) (int64, error) {
+	res, err := tx.ExecContext(ctx, `INSERT INTO revlog (deck_id, card_id, quality, reviewed_at, elapsed,
+		prev_interval, prev_due, interval, due) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.DeckId, e.CardId, e.Quality, toUnixNano(e.ReviewedAt), e.Elapsed,
+		e.PrevInterval, toUnixNano(e.PrevDue), e.Interval, toUnixNano(e.Due))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// card reads the serialized algo parameters and the lapses of the card
+func card(ctx context.Context, tx *sql.Tx, deckId string, cardId int) (b []byte, lapses db.Lapses, err error) {
+	err = tx.QueryRowContext(ctx, "SELECT item, lapses, last_correct, leech FROM cards WHERE deck_id = ? AND card_id = ?",
+		deckId, cardId).Scan(&b, &lapses.Count, &lapses.Correct, &lapses.Leech)
+	if err == sql.ErrNoRows {
+		return nil, lapses, db.ErrCardIdNotExists
+	}
+
+	return b, lapses, err
+}
+
+func writeLapses(ctx context.Context, tx *sql.Tx, deckId string, cardId int, lapses db.Lapses) error {
+	_, err := tx.ExecContext(ctx, "UPDATE cards SET lapses = ?, last_correct = ?, leech = ? WHERE deck_id = ? AND card_id = ?",
+		lapses.Count, lapses.Correct, lapses.Leech, deckId, cardId)
+	return err
+}
+
+// toUnixNano stores the zero time as 0
+func toUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,187 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/db/dbtest"
+	"github.com/revelaction/go-srs/db/sqlite"
+	"github.com/revelaction/go-srs/review"
+)
+
+// Handler implements db.Handler
+var _ db.Handler = (*sqlite.Handler)(nil)
+
+// newDb returns an empty db file in a temporary directory
+func newDb(t *testing.T) *sql.DB {
+	sdb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "srs.db")+"?_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	t.Cleanup(func() { sdb.Close() })
+
+	return sdb
+}
+
+// openDb returns a db file with the srs tables in a temporary directory
+func openDb(t *testing.T) *sql.DB {
+	sdb := newDb(t)
+
+	if _, err := sqlite.Open(sdb, nil, clock.Real{}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return sdb
+}
+
+func userVersion(t *testing.T, sdb *sql.DB) int {
+	t.Helper()
+
+	var version int
+	if err := sdb.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return version
+}
+
+func tables(t *testing.T, sdb *sql.DB) []string {
+	t.Helper()
+
+	rows, err := sdb.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func TestOpenSchema(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	sdb := newDb(t)
+
+	dbh, err := sqlite.Open(sdb, sm2.NewWithClock(c), c)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if got := userVersion(t, sdb); got != sqlite.SchemaVersion {
+		t.Errorf("\ngot %#v\nwant %#v", got, sqlite.SchemaVersion)
+	}
+
+	want := []string{"cards", "decks", "revlog", "undo"}
+	if got := tables(t, sdb); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("\ngot %#v\nwant %#v", got, want)
+	}
+
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.IncorrectBlackout}, {Quality: review.CorrectEasy}}}
+	if _, err := dbh.Insert(r, "hi"); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// the lapses are stored in the cards table
+	var lapses, lastCorrect, leech int
+	err = sdb.QueryRow("SELECT lapses, last_correct, leech FROM cards WHERE deck_id = ? AND card_id = ?", "hi", 2).
+		Scan(&lapses, &lastCorrect, &leech)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if lapses != 0 || lastCorrect != 1 || leech != 0 {
+		t.Errorf("\ngot lapses %d, last_correct %d, leech %d\nwant 0, 1, 0", lapses, lastCorrect, leech)
+	}
+
+	// opening again keeps the cards
+	dbh, err = sqlite.Open(sdb, sm2.NewWithClock(c), c)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	cards, err := dbh.Cards("hi")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(cards) != 2 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", len(cards), 2)
+	}
+}
+
+func TestOpenMigrate(t *testing.T) {
+
+	sdb := newDb(t)
+
+	// the revlog of the version 1, without time index
+	_, err := sdb.Exec(`CREATE TABLE revlog (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, deck_id TEXT NOT NULL, card_id INTEGER NOT NULL,
+		quality INTEGER NOT NULL, reviewed_at INTEGER NOT NULL, elapsed INTEGER NOT NULL,
+		prev_interval INTEGER NOT NULL, prev_due INTEGER NOT NULL, interval INTEGER NOT NULL,
+		due INTEGER NOT NULL);
+		PRAGMA user_version = 1`)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if _, err := sqlite.Open(sdb, nil, clock.Real{}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if got := userVersion(t, sdb); got != sqlite.SchemaVersion {
+		t.Errorf("\ngot %#v\nwant %#v", got, sqlite.SchemaVersion)
+	}
+
+	var n int
+	if err := sdb.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'index' AND name = 'revlog_time'").Scan(&n); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if n != 1 {
+		t.Errorf("\ngot %#v\nwant %#v", n, 1)
+	}
+}
+
+func TestOpenUnknownVersion(t *testing.T) {
+
+	sdb := newDb(t)
+
+	if _, err := sdb.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqlite.SchemaVersion+1)); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if _, err := sqlite.Open(sdb, nil, clock.Real{}); err == nil {
+		t.Errorf("\ngot no error\nwant unknown schema version")
+	}
+
+	// the tables of a newer version are not touched
+	if got := tables(t, sdb); len(got) != 0 {
+		t.Errorf("\ngot %#v\nwant no tables", got)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, a algo.Algo, c clock.Clock) db.Handler {
+		return sqlite.NewWithClock(openDb(t), a, c)
+	}, dbtest.Algos...)
+}
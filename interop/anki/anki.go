@@ -0,0 +1,779 @@
+// Package anki reads and writes Anki packages (.apkg), and imports and
+// exports their decks as go-srs decks scheduled by the sm2 algo.
+//
+// A package is a zip with the collection, a SQLite db, and the media files.
+// Packages of Anki 2.1.50 and later must be exported with "Support older
+// Anki versions": the zstd compressed collection.anki21b is not supported.
+//
+// The schedule of the cards is translated to sm2.Item: the ease factor to
+// Easiness, the interval in days to Interval and the due day or time to Due.
+// ConsecutiveCorrectAnswers is derived from the interval and the ease factor,
+// as the reps of Anki count all the reviews of the card, also the failed ones.
+//
+// The revlog of the collection is translated to db.LogEntry: the answer
+// buttons again, hard, good and easy to the qualities IncorrectFamiliar,
+// CorrectHard, CorrectEffort and CorrectEasy. Entries without quality, as the
+// one of the Insert of a card, are not written to the revlog.
+package anki
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+var (
+
+	// ErrUnsupported is returned by Read for packages with only the
+	// collection format of Anki 2.1.50 and later
+	ErrUnsupported = errors.New("anki: unsupported collection format, export with \"Support older Anki versions\"")
+
+	// ErrNoCollection is returned by Read for zip files without collection
+	ErrNoCollection = errors.New("anki: no collection in package")
+)
+
+// leechTag is the tag of the notes of leech cards
+const leechTag = "leech"
+
+// Package contains the decks and media files of an Anki package.
+type Package struct {
+	Decks []Deck
+
+	// Media contains the content of the media files by name
+	Media map[string][]byte
+}
+
+// Deck is an Anki deck.
+type Deck struct {
+
+	// Name is the full name of the deck, with "::" between the parent decks
+	Name string
+
+	Cards []Card
+
+	// Log is the review history of the cards, in review order. The CardId
+	// of the entries is the one of the sm2 Item of the card.
+	Log []db.LogEntry
+}
+
+// Card is an Anki card with its note.
+type Card struct {
+
+	// Item is the sm2 schedule of the card. Its CardId is the card id in the
+	// go-srs deck: the cards of a read deck are numbered from 1 in the order
+	// they were created in Anki.
+	Item sm2.Item
+
+	Suspended bool
+	Lapses    db.Lapses
+
+	// AnkiId and NoteId are the ids of the card and its note in the read
+	// collection. Write ignores them.
+	AnkiId int64
+	NoteId int64
+
+	// Fields and Tags of the note
+	Fields []string
+	Tags   []string
+}
+
+// ReadFile reads the Anki package in the file name. See Read.
+func ReadFile(name string, now time.Time) (*Package, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return Read(f, st.Size(), now)
+}
+
+// Read reads the Anki package of size bytes in r. Decks are returned in name
+// order, and only if they have cards. Cards of filtered decks are returned in
+// their original deck.
+//
+// New cards are due at now. Buried cards are not buried in the returned
+// decks.
+func Read(r io.ReaderAt, size int64, now time.Time) (*Package, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	// packages with both collections have a dummy collection.anki2
+	coll := files["collection.anki21"]
+	if coll == nil {
+		coll = files["collection.anki2"]
+	}
+
+	if coll == nil {
+		if files["collection.anki21b"] != nil {
+			return nil, ErrUnsupported
+		}
+
+		return nil, ErrNoCollection
+	}
+
+	p := &Package{Media: map[string][]byte{}}
+
+	if err := p.readCollection(coll, now); err != nil {
+		return nil, err
+	}
+
+	if f := files["media"]; f != nil {
+		if err := p.readMedia(f, files); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// readCollection reads the decks of the collection file. The SQLite driver
+// needs a file: the collection is copied to a temporary one.
+func (p *Package) readCollection(f *zip.File, now time.Time) error {
+	tmp, err := os.CreateTemp("", "collection-*.anki2")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	rc, err := f.Open()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	_, err = io.Copy(tmp, rc)
+	rc.Close()
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	sdb, err := sql.Open("sqlite", tmp.Name())
+	if err != nil {
+		return err
+	}
+
+	defer sdb.Close()
+
+	var crt int64
+	if err := sdb.QueryRow("SELECT crt FROM col").Scan(&crt); err != nil {
+		return err
+	}
+
+	names, err := readDecks(sdb)
+	if err != nil {
+		return err
+	}
+
+	rows, err := readRows(sdb)
+	if err != nil {
+		return err
+	}
+
+	// refs has the deck and the card id of the read cards by Anki id
+	type ref struct {
+		did    int64
+		cardId int
+	}
+
+	refs := map[int64]ref{}
+	decks := map[int64]*Deck{}
+	for _, r := range rows {
+		did := r.did
+		if r.odid != 0 {
+			did = r.odid
+		}
+
+		d, ok := decks[did]
+		if !ok {
+			name, ok := names[did]
+			if !ok {
+				name = strconv.FormatInt(did, 10)
+			}
+
+			d = &Deck{Name: name}
+			decks[did] = d
+		}
+
+		d.Cards = append(d.Cards, card(r, len(d.Cards)+1, crt, now))
+		refs[r.id] = ref{did: did, cardId: len(d.Cards)}
+	}
+
+	revlog, err := readRevlog(sdb)
+	if err != nil {
+		return err
+	}
+
+	// the previous due of an entry is the due of the previous entry of
+	// its card
+	dues := map[int64]time.Time{}
+	for _, r := range revlog {
+		ref, ok := refs[r.cid]
+		if !ok || r.ease == easeManual {
+			continue
+		}
+
+		e := entry(r, ref.cardId, dues[r.cid])
+		dues[r.cid] = e.Due
+
+		d := decks[ref.did]
+		d.Log = append(d.Log, e)
+	}
+
+	for _, d := range decks {
+		p.Decks = append(p.Decks, *d)
+	}
+
+	sort.Slice(p.Decks, func(i, j int) bool {
+		return p.Decks[i].Name < p.Decks[j].Name
+	})
+
+	return nil
+}
+
+// card translates the row to the card cardId of a go-srs deck
+func card(r row, cardId int, crt int64, now time.Time) Card {
+	c := Card{
+		Item: sm2.Item{
+			CardId:   cardId,
+			Easiness: sm2.DefaultEasiness,
+			Due:      due(r, crt, now).Unix(),
+		},
+		Suspended: r.queue == queueSuspended,
+		Lapses:    db.Lapses{Count: r.lapses, Correct: r.ctype == typeReview},
+		AnkiId:    r.id,
+		NoteId:    r.nid,
+		Fields:    strings.Split(r.fields, fieldSep),
+		Tags:      strings.Fields(r.tags),
+	}
+
+	// new cards have no factor
+	if r.factor > 0 {
+		c.Item.Easiness = float64(r.factor) / 1000
+	}
+
+	// the interval of learning cards is negative, in seconds
+	if r.ivl > 0 {
+		c.Item.Interval = r.ivl
+	}
+
+	switch {
+	case r.ctype == typeNew, r.ctype == typeRelearning:
+	case r.ivl > 0:
+		c.Item.ConsecutiveCorrectAnswers = streak(r.ivl, c.Item.Easiness)
+	case r.reps > 0:
+		c.Item.ConsecutiveCorrectAnswers = 1
+	}
+
+	for _, tag := range c.Tags {
+		if strings.EqualFold(tag, leechTag) {
+			c.Lapses.Leech = true
+		}
+	}
+
+	return c
+}
+
+// streak returns the ConsecutiveCorrectAnswers of a sm2 Item with an interval
+// of ivl days and the easiness e. The interval that sm2 gives after n correct
+// answers is 6*e^(n-2) days.
+func streak(ivl int, e float64) int {
+	e = math.Max(e, sm2.MinEasiness)
+	n := int(math.Round(2 + math.Log(float64(ivl)/sm2.DueDateStartDays)/math.Log(e)))
+	if n < 1 {
+		return 1
+	}
+
+	return n
+}
+
+// entry translates the revlog row to the log entry of the card cardId, with
+// the due prevDue of its previous review
+func entry(r revlogRow, cardId int, prevDue time.Time) db.LogEntry {
+	e := db.LogEntry{
+		CardId:       cardId,
+		Quality:      quality(r.ease),
+		ReviewedAt:   time.UnixMilli(r.id).UTC(),
+		Elapsed:      time.Duration(r.time) * time.Millisecond,
+		PrevInterval: interval(r.lastIvl),
+		Interval:     interval(r.ivl),
+	}
+
+	if e.PrevInterval > 0 {
+		e.PrevDue = prevDue
+	}
+
+	e.Due = e.ReviewedAt.Add(e.Interval)
+	return e
+}
+
+// quality translates the answer button ease to a review.Quality. The v1
+// scheduler of Anki has no hard button for learning cards: its good button
+// is read as hard.
+func quality(ease int) review.Quality {
+	switch ease {
+	case easeAgain:
+		return review.IncorrectFamiliar
+	case easeHard:
+		return review.CorrectHard
+	case easeGood:
+		return review.CorrectEffort
+	default:
+		return review.CorrectEasy
+	}
+}
+
+// ease translates the quality q to an answer button
+func ease(q review.Quality) int {
+	switch {
+	case q < review.CorrectHard:
+		return easeAgain
+	case q == review.CorrectHard:
+		return easeHard
+	case q == review.CorrectEffort:
+		return easeGood
+	default:
+		return easeEasy
+	}
+}
+
+// interval returns the revlog interval ivl, in days or in seconds if
+// negative, as a duration
+func interval(ivl int) time.Duration {
+	if ivl < 0 {
+		return time.Duration(-ivl) * time.Second
+	}
+
+	return time.Duration(ivl) * 24 * time.Hour
+}
+
+// revlogInterval returns the revlog interval of the duration d: in days if
+// it is at least one day, in negative seconds otherwise
+func revlogInterval(d time.Duration) int {
+	if d >= 24*time.Hour {
+		return int(math.Round(d.Hours() / 24))
+	}
+
+	return -int(d.Seconds())
+}
+
+// due returns the due time of the row. The due of review cards is a day
+// number since the creation of the collection, the due of learning cards a
+// timestamp and the due of new cards a position. Cards in filtered decks keep
+// their original due in odue.
+func due(r row, crt int64, now time.Time) time.Time {
+	d := r.due
+	if r.odid != 0 && r.odue != 0 {
+		d = r.odue
+	}
+
+	switch {
+	case r.ctype == typeNew:
+		return now.UTC()
+	case d > 1000000000:
+		return time.Unix(d, 0).UTC()
+	default:
+		return time.Unix(crt, 0).UTC().AddDate(0, 0, int(d))
+	}
+}
+
+// readMedia reads the media files listed in the media file, a JSON map of
+// the zip file names to the media file names
+func (p *Package) readMedia(f *zip.File, files map[string]*zip.File) error {
+	b, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+
+	var media map[string]string
+	if err := json.Unmarshal(b, &media); err != nil {
+		return fmt.Errorf("anki: decoding media: %w", err)
+	}
+
+	for index, name := range media {
+		mf := files[index]
+		if mf == nil {
+			return fmt.Errorf("anki: missing media file %s (%s)", index, name)
+		}
+
+		b, err := readZipFile(mf)
+		if err != nil {
+			return err
+		}
+
+		p.Media[name] = b
+	}
+
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// Write writes the package to w as an Anki package. Each card gets its own
+// note, of a note type with the fields Front, Back and as many more as the
+// longest Fields. Leech cards are tagged leech. The entries of the Log with
+// a quality are written in the revlog, with the current ease factor of the
+// card.
+//
+// Due times are rounded down to the day, and now is the modification time of
+// the collection.
+func (p *Package) Write(w io.Writer, now time.Time) error {
+	b, err := p.collection(now)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	fw, err := zw.Create("collection.anki2")
+	if err != nil {
+		return err
+	}
+
+	if _, err := fw.Write(b); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(p.Media))
+	for name := range p.Media {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	media := map[string]string{}
+	for i, name := range names {
+		index := strconv.Itoa(i)
+		media[index] = name
+
+		fw, err := zw.Create(index)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fw.Write(p.Media[name]); err != nil {
+			return err
+		}
+	}
+
+	mb, err := json.Marshal(media)
+	if err != nil {
+		return err
+	}
+
+	fw, err = zw.Create("media")
+	if err != nil {
+		return err
+	}
+
+	if _, err := fw.Write(mb); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// collection returns the SQLite collection of the package
+func (p *Package) collection(now time.Time) ([]byte, error) {
+	tmp, err := os.CreateTemp("", "collection-*.anki2")
+	if err != nil {
+		return nil, err
+	}
+
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	sdb, err := sql.Open("sqlite", tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.writeCollection(sdb, now); err != nil {
+		sdb.Close()
+		return nil, err
+	}
+
+	if err := sdb.Close(); err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(tmp.Name())
+}
+
+func (p *Package) writeCollection(sdb *sql.DB, now time.Time) error {
+	if _, err := sdb.Exec(schema); err != nil {
+		return err
+	}
+
+	// ids are millisecond timestamps in Anki
+	base := now.UnixMilli()
+	mod := now.Unix()
+
+	// the collection is created before the first due day, so the due day
+	// numbers are not negative
+	first := now
+	nfields := 2
+	for _, d := range p.Decks {
+		for _, c := range d.Cards {
+			if t := time.Unix(c.Item.Due, 0); c.Item.Interval > 0 && t.Before(first) {
+				first = t
+			}
+
+			if len(c.Fields) > nfields {
+				nfields = len(c.Fields)
+			}
+		}
+	}
+
+	first = first.UTC()
+	crt := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC).Unix()
+
+	m := newModel(base, mod, nfields)
+	decks := map[string]any{strconv.Itoa(defaultDeckId): newDeck(defaultDeckId, "Default", mod)}
+	for i, d := range p.Decks {
+		id := base + int64(i) + 1
+		decks[strconv.FormatInt(id, 10)] = newDeck(id, d.Name, mod)
+	}
+
+	models, err := json.Marshal(map[string]model{strconv.FormatInt(m.Id, 10): m})
+	if err != nil {
+		return err
+	}
+
+	decksJson, err := json.Marshal(decks)
+	if err != nil {
+		return err
+	}
+
+	conf := fmt.Sprintf(`{"nextPos": 1, "estTimes": true, "activeDecks": [1], "sortType": "noteFld", "timeLim": 0,
+		"sortBackwards": false, "addToCur": true, "curDeck": 1, "newSpread": 0, "dueCounts": true,
+		"curModel": "%d", "collapseTime": 1200}`, m.Id)
+
+	tx, err := sdb.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO col VALUES (1, ?, ?, ?, 11, 0, 0, 0, ?, ?, ?, ?, '{}')`,
+		crt, base, base, conf, string(models), string(decksJson), defaultConf)
+	if err != nil {
+		return err
+	}
+
+	id := base
+	var revlogId int64
+	for i, d := range p.Decks {
+		did := base + int64(i) + 1
+		cids := map[int]int64{}
+		factors := map[int]int{}
+
+		for pos, c := range d.Cards {
+			id++
+
+			fields := make([]string, nfields)
+			copy(fields, c.Fields)
+
+			tags := c.Tags
+			if c.Lapses.Leech && !hasTag(tags, leechTag) {
+				tags = append(append([]string{}, tags...), leechTag)
+			}
+
+			tagsField := ""
+			if len(tags) > 0 {
+				tagsField = " " + strings.Join(tags, " ") + " "
+			}
+
+			_, err := tx.Exec(`INSERT INTO notes VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')`,
+				id, strconv.FormatInt(id, 36), m.Id, mod, tagsField,
+				strings.Join(fields, fieldSep), fields[0], checksum(fields[0]))
+			if err != nil {
+				return err
+			}
+
+			ctype, queue, due := schedule(c, pos+1, crt)
+
+			_, err = tx.Exec(`INSERT INTO cards VALUES (?, ?, ?, 0, ?, -1, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, 0, '')`,
+				id, id, did, mod, ctype, queue, due, c.Item.Interval,
+				int(math.Round(c.Item.Easiness*1000)), c.Item.ConsecutiveCorrectAnswers, c.Lapses.Count)
+			if err != nil {
+				return err
+			}
+
+			cids[c.Item.CardId] = id
+			factors[c.Item.CardId] = int(math.Round(c.Item.Easiness * 1000))
+		}
+
+		if err := writeRevlog(tx, d.Log, cids, factors, &revlogId); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// writeRevlog writes the entries of the cards with Anki ids cids and ease
+// factors factors. Entries of other cards are skipped. The revlog ids are the
+// review times in milliseconds, made unique after the last id *last.
+func writeRevlog(tx *sql.Tx, entries []db.LogEntry, cids map[int]int64, factors map[int]int, last *int64) error {
+	for _, e := range entries {
+		cid, ok := cids[e.CardId]
+		if !ok || e.Quality == review.NoReview {
+			continue
+		}
+
+		id := e.ReviewedAt.UnixMilli()
+		if id <= *last {
+			id = *last + 1
+		}
+
+		*last = id
+
+		rtype := revlogReview
+		if e.PrevInterval == 0 {
+			rtype = revlogLearn
+		}
+
+		_, err := tx.Exec(`INSERT INTO revlog VALUES (?, ?, -1, ?, ?, ?, ?, ?, ?)`,
+			id, cid, ease(e.Quality), revlogInterval(e.Interval), revlogInterval(e.PrevInterval),
+			factors[e.CardId], e.Elapsed.Milliseconds(), rtype)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// schedule returns the type, queue and due of the card at the position pos
+// of the new cards. Cards without interval are new, the others are review
+// cards, or relearning if the last review failed.
+func schedule(c Card, pos int, crt int64) (ctype, queue int, due int64) {
+	switch {
+	case c.Item.Interval == 0:
+		ctype, queue, due = typeNew, queueNew, int64(pos)
+	case c.Item.ConsecutiveCorrectAnswers == 0:
+		ctype, queue, due = typeRelearning, queueDayLearn, (c.Item.Due-crt)/(24*60*60)
+	default:
+		ctype, queue, due = typeReview, queueReview, (c.Item.Due-crt)/(24*60*60)
+	}
+
+	if c.Suspended {
+		queue = queueSuspended
+	}
+
+	return ctype, queue, due
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Import writes the cards of the deck d in the deck deckId of h, that must
+// run the sm2 algo. Cards of deckId with the same ids are replaced. The notes
+// of the cards are not stored: the caller keeps them by card id. The Log of d
+// is appended to the review log of deckId.
+func Import(h db.Handler, deckId string, d Deck) error {
+	cards := make([]db.Card, 0, len(d.Cards))
+	for _, c := range d.Cards {
+		b, err := sm2.Encode(c.Item)
+		if err != nil {
+			return err
+		}
+
+		cards = append(cards, db.Card{
+			DueItem:   review.DueItem{CardId: c.Item.CardId},
+			Item:      b,
+			Suspended: c.Suspended,
+			Lapses:    c.Lapses,
+		})
+	}
+
+	if err := h.PutCards(deckId, cards); err != nil {
+		return err
+	}
+
+	if len(d.Log) == 0 {
+		return nil
+	}
+
+	return h.PutLog(deckId, d.Log)
+}
+
+// Export returns the cards and the review log of the deck deckId of h, that
+// must run the sm2 algo, as the deck name. The cards have no Fields: the
+// caller adds them before Write.
+func Export(h db.Handler, deckId string, name string) (Deck, error) {
+	d := Deck{Name: name}
+
+	cards, err := h.Cards(deckId)
+	if err != nil {
+		return d, err
+	}
+
+	for _, c := range cards {
+		item, err := sm2.Decode(c.Item)
+		if err != nil {
+			return d, err
+		}
+
+		d.Cards = append(d.Cards, Card{Item: item, Suspended: c.Suspended, Lapses: c.Lapses})
+	}
+
+	d.Log, err = h.DeckLog(deckId)
+	if err != nil {
+		return d, err
+	}
+
+	return d, nil
+}
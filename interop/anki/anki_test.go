@@ -0,0 +1,377 @@
+package anki
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/db/memory"
+	"github.com/revelaction/go-srs/review"
+)
+
+var (
+	// crt is the creation of the test collection
+	crt = time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	now = time.Date(2020, time.November, 20, 10, 0, 0, 0, time.UTC)
+)
+
+// newCollection returns a collection db with the statements applied
+func newCollection(t *testing.T, stmts ...string) []byte {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "collection.anki2")
+	sdb, err := sql.Open("sqlite", name)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	for _, stmt := range append([]string{schema}, stmts...) {
+		if _, err := sdb.Exec(stmt); err != nil {
+			t.Fatalf("got unexpected error %s in %s", err, stmt)
+		}
+	}
+
+	if err := sdb.Close(); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return b
+}
+
+// newZip returns a zip with the files
+func newZip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, b := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if _, err := w.Write(b); err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+func read(t *testing.T, b []byte) *Package {
+	t.Helper()
+
+	p, err := Read(bytes.NewReader(b), int64(len(b)), now)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return p
+}
+
+func TestRead(t *testing.T) {
+	coll := newCollection(t,
+		fmt.Sprintf(`INSERT INTO col VALUES (1, %d, 0, 0, 11, 0, 0, 0, '{}', '{}',
+			'{"1": {"id": 1, "name": "Default"}, "10": {"id": 10, "name": "Lang::French"}, "20": {"id": 20, "name": "Filtered", "dyn": 1}}',
+			'{}', '{}')`, crt.Unix()),
+		`INSERT INTO notes VALUES
+			(1, 'a', 1, 0, 0, ' leech greeting ', 'bonjour'||char(31)||'hello', 'bonjour', 0, 0, ''),
+			(2, 'b', 1, 0, 0, '', 'chat'||char(31)||'cat', 'chat', 0, 0, ''),
+			(3, 'c', 1, 0, 0, '', 'chien'||char(31)||'dog', 'chien', 0, 0, ''),
+			(4, 'd', 1, 0, 0, '', 'oui'||char(31)||'yes', 'oui', 0, 0, ''),
+			(5, 'e', 1, 0, 0, '', 'non'||char(31)||'no', 'non', 0, 0, '')`,
+		// review, new, review in a filtered deck, learning, suspended
+		// relearning
+		`INSERT INTO cards VALUES
+			(100, 1, 10, 0, 0, 0, 2, 2, 10, 5, 2300, 7, 2, 0, 0, 0, 0, ''),
+			(101, 2, 10, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, ''),
+			(102, 3, 20, 0, 0, 0, 2, 2, -100000, 3, 2500, 4, 0, 0, 12, 10, 0, ''),
+			(103, 4, 1, 0, 0, 0, 1, 1, 1604250000, -600, 0, 1, 0, 1001, 0, 0, 0, ''),
+			(104, 5, 10, 0, 0, 0, 3, -1, 4, 1, 1700, 9, 3, 0, 0, 0, 0, '')`,
+		// learning and review of 100, a manual reschedule, a deleted card
+		// and the learning card 103
+		`INSERT INTO revlog VALUES
+			(1604300000000, 100, 0, 1, -600, 0, 0, 8000, 0),
+			(1604400000000, 100, 0, 3, 5, -600, 2300, 5000, 1),
+			(1604500000000, 100, 0, 0, 5, 5, 2300, 0, 4),
+			(1604500000001, 999, 0, 3, 1, 0, 2500, 1000, 0),
+			(1604200000000, 103, 0, 4, 1, 0, 2500, 3000, 0)`,
+	)
+
+	p := read(t, newZip(t, map[string][]byte{
+		"collection.anki2": coll,
+		"media":            []byte(`{"0": "hello.mp3"}`),
+		"0":                []byte("sound"),
+	}))
+
+	if len(p.Decks) != 2 || p.Decks[0].Name != "Default" || p.Decks[1].Name != "Lang::French" {
+		t.Fatalf("\ngot decks %#v\nwant Default, Lang::French", p.Decks)
+	}
+
+	want := []Card{
+		{
+			Item:   sm2.Item{CardId: 1, Easiness: 2.3, ConsecutiveCorrectAnswers: 2, Interval: 5, Due: crt.AddDate(0, 0, 10).Unix()},
+			Lapses: db.Lapses{Count: 2, Correct: true, Leech: true},
+			AnkiId: 100, NoteId: 1, Fields: []string{"bonjour", "hello"}, Tags: []string{"leech", "greeting"},
+		},
+		{
+			Item:   sm2.Item{CardId: 2, Easiness: sm2.DefaultEasiness, Due: now.Unix()},
+			AnkiId: 101, NoteId: 2, Fields: []string{"chat", "cat"}, Tags: []string{},
+		},
+		{
+			Item:   sm2.Item{CardId: 3, Easiness: 2.5, ConsecutiveCorrectAnswers: 1, Interval: 3, Due: crt.AddDate(0, 0, 12).Unix()},
+			Lapses: db.Lapses{Correct: true},
+			AnkiId: 102, NoteId: 3, Fields: []string{"chien", "dog"}, Tags: []string{},
+		},
+		{
+			Item:      sm2.Item{CardId: 4, Easiness: 1.7, Interval: 1, Due: crt.AddDate(0, 0, 4).Unix()},
+			Suspended: true,
+			Lapses:    db.Lapses{Count: 3},
+			AnkiId:    104, NoteId: 5, Fields: []string{"non", "no"}, Tags: []string{},
+		},
+	}
+
+	if fmt.Sprint(p.Decks[1].Cards) != fmt.Sprint(want) {
+		t.Errorf("\ngot %#v\nwant %#v", p.Decks[1].Cards, want)
+	}
+
+	// learning cards are due at a time
+	wantLearning := sm2.Item{CardId: 1, Easiness: sm2.DefaultEasiness, ConsecutiveCorrectAnswers: 1, Due: 1604250000}
+	if got := p.Decks[0].Cards[0].Item; got != wantLearning {
+		t.Errorf("\ngot %#v\nwant %#v", got, wantLearning)
+	}
+
+	learned := time.UnixMilli(1604300000000).UTC()
+	reviewed := time.UnixMilli(1604400000000).UTC()
+
+	wantLog := []db.LogEntry{
+		{CardId: 1, Quality: review.IncorrectFamiliar, ReviewedAt: learned, Elapsed: 8 * time.Second,
+			Interval: 10 * time.Minute, Due: learned.Add(10 * time.Minute)},
+		{CardId: 1, Quality: review.CorrectEffort, ReviewedAt: reviewed, Elapsed: 5 * time.Second,
+			PrevInterval: 10 * time.Minute, PrevDue: learned.Add(10 * time.Minute),
+			Interval: 5 * 24 * time.Hour, Due: reviewed.AddDate(0, 0, 5)},
+	}
+
+	if fmt.Sprint(p.Decks[1].Log) != fmt.Sprint(wantLog) {
+		t.Errorf("\ngot %#v\nwant %#v", p.Decks[1].Log, wantLog)
+	}
+
+	if len(p.Decks[0].Log) != 1 || p.Decks[0].Log[0].Quality != review.CorrectEasy {
+		t.Errorf("\ngot %#v\nwant an easy review", p.Decks[0].Log)
+	}
+
+	if string(p.Media["hello.mp3"]) != "sound" {
+		t.Errorf("\ngot media %v\nwant hello.mp3", p.Media)
+	}
+}
+
+func TestReadDecksTable(t *testing.T) {
+	coll := newCollection(t,
+		`CREATE TABLE decks (id integer PRIMARY KEY, name text NOT NULL)`,
+		`INSERT INTO decks VALUES (1, 'Default'), (10, 'Lang'||char(31)||'French')`,
+		`INSERT INTO col VALUES (1, 0, 0, 0, 11, 0, 0, 0, '{}', '{}', '{}', '{}', '{}')`,
+		`INSERT INTO notes VALUES (1, 'a', 1, 0, 0, '', 'chat'||char(31)||'cat', 'chat', 0, 0, '')`,
+		`INSERT INTO cards VALUES (100, 1, 10, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, '')`,
+	)
+
+	p := read(t, newZip(t, map[string][]byte{"collection.anki21": coll}))
+
+	if len(p.Decks) != 1 || p.Decks[0].Name != "Lang::French" {
+		t.Errorf("\ngot decks %#v\nwant Lang::French", p.Decks)
+	}
+}
+
+func TestCardStreak(t *testing.T) {
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// a card reviewed for years, with a hundred year interval
+	r := row{ctype: typeReview, queue: queueReview, due: 100, ivl: 36500, factor: 1300, reps: 5000}
+	c := card(r, 1, now.Unix(), now)
+
+	if c.Item.ConsecutiveCorrectAnswers != 35 {
+		t.Errorf("\ngot %#v\nwant %#v", c.Item.ConsecutiveCorrectAnswers, 35)
+	}
+
+	item, err := sm2.Encode(c.Item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := sm2.New(now)
+	b, err := s.Update(item, review.ReviewItem{CardId: 1, Quality: review.CorrectEasy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := s.Summary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the next interval follows the imported one
+	if days := next.Interval.Hours() / 24; days < 36500 || days > 2*36500 {
+		t.Errorf("\ngot %v days\nwant between 36500 and 73000", days)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		files map[string][]byte
+		want  error
+	}{
+		{files: map[string][]byte{"collection.anki21b": nil, "media": nil}, want: ErrUnsupported},
+		{files: map[string][]byte{"media": nil}, want: ErrNoCollection},
+	}
+
+	for _, tc := range tests {
+		b := newZip(t, tc.files)
+
+		_, err := Read(bytes.NewReader(b), int64(len(b)), now)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("\ngot error %v\nwant %v", err, tc.want)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	due := time.Date(2020, time.November, 25, 15, 0, 0, 0, time.UTC)
+
+	p := &Package{
+		Decks: []Deck{
+			{Name: "Lang::French", Cards: []Card{
+				{Item: sm2.Item{CardId: 1, Easiness: 2.36, ConsecutiveCorrectAnswers: 3, Interval: 15, Due: due.Unix()}, Fields: []string{"chat", "cat", "noun"}, Tags: []string{"animal"}},
+				{Item: sm2.Item{CardId: 2, Easiness: 2.5, Due: now.Unix()}, Fields: []string{"oui"}},
+				{Item: sm2.Item{CardId: 3, Easiness: 1.3, Interval: 1, Due: due.Unix()}, Suspended: true, Lapses: db.Lapses{Count: 9, Leech: true}},
+			}, Log: []db.LogEntry{
+				// the insert of card 1, the entry of a deleted card and two
+				// reviews at the same time
+				{CardId: 1, ReviewedAt: crt},
+				{CardId: 1, Quality: review.CorrectEasy, ReviewedAt: due.AddDate(0, 0, -15), Elapsed: 4 * time.Second,
+					Interval: 15 * 24 * time.Hour, Due: due},
+				{CardId: 9, Quality: review.CorrectEasy, ReviewedAt: due},
+				{CardId: 3, Quality: review.IncorrectBlackout, ReviewedAt: due.AddDate(0, 0, -15),
+					PrevInterval: 24 * time.Hour, PrevDue: due.AddDate(0, 0, -15), Interval: 24 * time.Hour, Due: due.AddDate(0, 0, -14)},
+			}},
+			{Name: "Other", Cards: []Card{
+				// overdue before now
+				{Item: sm2.Item{CardId: 1, Easiness: 2.5, ConsecutiveCorrectAnswers: 1, Interval: 1, Due: crt.Unix()}},
+			}},
+		},
+		Media: map[string][]byte{"cat.jpg": []byte("cat"), "a.mp3": []byte("a")},
+	}
+
+	var buf bytes.Buffer
+	if err := p.Write(&buf, now); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	got := read(t, buf.Bytes())
+
+	if len(got.Decks) != 2 {
+		t.Fatalf("\nChecking len:\ngot %d\nwant %d", len(got.Decks), 2)
+	}
+
+	// due times are rounded down to the day
+	dueDay := time.Date(2020, time.November, 25, 0, 0, 0, 0, time.UTC).Unix()
+
+	want := []Card{
+		{Item: sm2.Item{CardId: 1, Easiness: 2.36, ConsecutiveCorrectAnswers: 3, Interval: 15, Due: dueDay}, Lapses: db.Lapses{Correct: true}, Fields: []string{"chat", "cat", "noun"}, Tags: []string{"animal"}},
+		{Item: sm2.Item{CardId: 2, Easiness: 2.5, Due: now.Unix()}, Fields: []string{"oui", "", ""}, Tags: []string{}},
+		{Item: sm2.Item{CardId: 3, Easiness: 1.3, Interval: 1, Due: dueDay}, Suspended: true, Lapses: db.Lapses{Count: 9, Leech: true}, Fields: []string{"", "", ""}, Tags: []string{"leech"}},
+	}
+
+	for i := range want {
+		g := got.Decks[0].Cards[i]
+		g.AnkiId, g.NoteId = 0, 0
+
+		if fmt.Sprint(g) != fmt.Sprint(want[i]) {
+			t.Errorf("\ngot %#v\nwant %#v", g, want[i])
+		}
+	}
+
+	// the second review of the same time is a millisecond later, and has
+	// no previous due without the previous entry
+	reviewed := due.AddDate(0, 0, -15)
+	wantLog := []db.LogEntry{
+		{CardId: 1, Quality: review.CorrectEasy, ReviewedAt: reviewed, Elapsed: 4 * time.Second,
+			Interval: 15 * 24 * time.Hour, Due: due},
+		{CardId: 3, Quality: review.IncorrectFamiliar, ReviewedAt: reviewed.Add(time.Millisecond),
+			PrevInterval: 24 * time.Hour, Interval: 24 * time.Hour, Due: reviewed.Add(24*time.Hour + time.Millisecond)},
+	}
+
+	if fmt.Sprint(got.Decks[0].Log) != fmt.Sprint(wantLog) {
+		t.Errorf("\ngot %#v\nwant %#v", got.Decks[0].Log, wantLog)
+	}
+
+	if got.Decks[1].Name != "Other" || got.Decks[1].Cards[0].Item.Due != crt.Unix() {
+		t.Errorf("\ngot %#v\nwant deck Other due %v", got.Decks[1], crt)
+	}
+
+	if fmt.Sprint(got.Media) != fmt.Sprint(p.Media) {
+		t.Errorf("\ngot %v\nwant %v", got.Media, p.Media)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	c := clock.NewFake(now)
+	h := memory.NewWithClock(sm2.NewWithClock(c), c)
+
+	d := Deck{Name: "French", Cards: []Card{
+		{Item: sm2.Item{CardId: 1, Easiness: 2.36, ConsecutiveCorrectAnswers: 3, Interval: 15, Due: now.AddDate(0, 0, 5).Unix()}},
+		{Item: sm2.Item{CardId: 2, Easiness: 2.5, Due: now.Unix()}, Suspended: true},
+		{Item: sm2.Item{CardId: 3, Easiness: 1.3, Interval: 1, Due: now.Add(-time.Hour).Unix()}, Lapses: db.Lapses{Count: 9, Leech: true}},
+	}, Log: []db.LogEntry{
+		{DeckId: "fr", CardId: 3, Quality: review.IncorrectFamiliar, ReviewedAt: now.Add(-25 * time.Hour),
+			Interval: 24 * time.Hour, Due: now.Add(-time.Hour)},
+	}}
+
+	if err := Import(h, "fr", d); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	due, err := h.Due("fr", now)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(due.Items) != 1 || due.Items[0].CardId != 3 {
+		t.Errorf("\ngot %#v\nwant card 3", due.Items)
+	}
+
+	leeches, err := h.Leeches("fr")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if len(leeches) != 1 || leeches[0] != (db.Leech{CardId: 3, Lapses: 9}) {
+		t.Errorf("\ngot %#v\nwant card 3", leeches)
+	}
+
+	got, err := Export(h, "fr", "French")
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(d) {
+		t.Errorf("\ngot %#v\nwant %#v", got, d)
+	}
+}
@@ -0,0 +1,315 @@
+package anki
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// schema is the schema 11 of the Anki collection, the format of the
+// collection.anki2 and collection.anki21 files
+const schema = `
+CREATE TABLE col (
+	id integer PRIMARY KEY, crt integer NOT NULL, mod integer NOT NULL,
+	scm integer NOT NULL, ver integer NOT NULL, dty integer NOT NULL,
+	usn integer NOT NULL, ls integer NOT NULL, conf text NOT NULL,
+	models text NOT NULL, decks text NOT NULL, dconf text NOT NULL,
+	tags text NOT NULL
+);
+
+CREATE TABLE notes (
+	id integer PRIMARY KEY, guid text NOT NULL, mid integer NOT NULL,
+	mod integer NOT NULL, usn integer NOT NULL, tags text NOT NULL,
+	flds text NOT NULL, sfld integer NOT NULL, csum integer NOT NULL,
+	flags integer NOT NULL, data text NOT NULL
+);
+
+CREATE TABLE cards (
+	id integer PRIMARY KEY, nid integer NOT NULL, did integer NOT NULL,
+	ord integer NOT NULL, mod integer NOT NULL, usn integer NOT NULL,
+	type integer NOT NULL, queue integer NOT NULL, due integer NOT NULL,
+	ivl integer NOT NULL, factor integer NOT NULL, reps integer NOT NULL,
+	lapses integer NOT NULL, left integer NOT NULL, odue integer NOT NULL,
+	odid integer NOT NULL, flags integer NOT NULL, data text NOT NULL
+);
+
+CREATE TABLE revlog (
+	id integer PRIMARY KEY, cid integer NOT NULL, usn integer NOT NULL,
+	ease integer NOT NULL, ivl integer NOT NULL, lastIvl integer NOT NULL,
+	factor integer NOT NULL, time integer NOT NULL, type integer NOT NULL
+);
+
+CREATE TABLE graves (usn integer NOT NULL, oid integer NOT NULL, type integer NOT NULL);
+
+CREATE INDEX ix_notes_usn ON notes (usn);
+CREATE INDEX ix_cards_usn ON cards (usn);
+CREATE INDEX ix_revlog_usn ON revlog (usn);
+CREATE INDEX ix_cards_nid ON cards (nid);
+CREATE INDEX ix_cards_sched ON cards (did, queue, due);
+CREATE INDEX ix_revlog_cid ON revlog (cid);
+CREATE INDEX ix_notes_csum ON notes (csum);
+`
+
+// Card types and queues of the cards table
+const (
+	typeNew        = 0
+	typeLearning   = 1
+	typeReview     = 2
+	typeRelearning = 3
+
+	queueSuspended = -1
+	queueNew       = 0
+	queueReview    = 2
+	queueDayLearn  = 3
+)
+
+// Answer buttons of the revlog table. Manual reschedules have no button.
+const (
+	easeManual = 0
+	easeAgain  = 1
+	easeHard   = 2
+	easeGood   = 3
+	easeEasy   = 4
+)
+
+// Review types of the revlog table
+const (
+	revlogLearn  = 0
+	revlogReview = 1
+)
+
+// fieldSep separates the fields of a note, and the parent decks in the names
+// of the decks table
+const fieldSep = "\x1f"
+
+// defaultDeckId is the id of the Default deck, that every collection has
+const defaultDeckId = 1
+
+// row is a row of the cards table, with the fields and tags of its note
+type row struct {
+	id, nid, did      int64
+	ctype, queue      int
+	due, odue, odid   int64
+	ivl, factor, reps int
+	lapses            int
+	fields, tags      string
+}
+
+// revlogRow is a row of the revlog table. The id is the review time in
+// milliseconds. Intervals are in days, or in seconds if negative.
+type revlogRow struct {
+	id, cid      int64
+	ease         int
+	ivl, lastIvl int
+	time         int64
+}
+
+// readDecks returns the deck names by id. Collections of Anki 2.1.28 and
+// later keep the decks in a table instead of the col row.
+func readDecks(sdb *sql.DB) (map[int64]string, error) {
+	var b string
+	if err := sdb.QueryRow("SELECT decks FROM col").Scan(&b); err != nil {
+		return nil, err
+	}
+
+	var decks map[string]struct {
+		Id   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+
+	if err := json.Unmarshal([]byte(b), &decks); err != nil {
+		return nil, fmt.Errorf("anki: decoding decks: %w", err)
+	}
+
+	names := map[int64]string{}
+	for _, d := range decks {
+		names[d.Id] = d.Name
+	}
+
+	if len(names) > 0 {
+		return names, nil
+	}
+
+	rows, err := sdb.Query("SELECT id, name FROM decks")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+
+		names[id] = strings.ReplaceAll(name, fieldSep, "::")
+	}
+
+	return names, rows.Err()
+}
+
+// readRows returns the cards of the collection in card id order
+func readRows(sdb *sql.DB) ([]row, error) {
+	rows, err := sdb.Query(`SELECT c.id, c.nid, c.did, c.type, c.queue, c.due, c.odue, c.odid,
+		c.ivl, c.factor, c.reps, c.lapses, n.flds, n.tags
+		FROM cards c JOIN notes n ON n.id = c.nid ORDER BY c.id`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var res []row
+	for rows.Next() {
+		var r row
+		err := rows.Scan(&r.id, &r.nid, &r.did, &r.ctype, &r.queue, &r.due, &r.odue, &r.odid,
+			&r.ivl, &r.factor, &r.reps, &r.lapses, &r.fields, &r.tags)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, r)
+	}
+
+	return res, rows.Err()
+}
+
+// readRevlog returns the rows of the revlog table in review order
+func readRevlog(sdb *sql.DB) ([]revlogRow, error) {
+	rows, err := sdb.Query("SELECT id, cid, ease, ivl, lastIvl, time FROM revlog ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var res []revlogRow
+	for rows.Next() {
+		var r revlogRow
+		if err := rows.Scan(&r.id, &r.cid, &r.ease, &r.ivl, &r.lastIvl, &r.time); err != nil {
+			return nil, err
+		}
+
+		res = append(res, r)
+	}
+
+	return res, rows.Err()
+}
+
+// model is a note type of the models JSON of the col row
+type model struct {
+	Id        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Type      int        `json:"type"`
+	Mod       int64      `json:"mod"`
+	Usn       int        `json:"usn"`
+	Sortf     int        `json:"sortf"`
+	Did       int64      `json:"did"`
+	Tmpls     []template `json:"tmpls"`
+	Flds      []field    `json:"flds"`
+	Css       string     `json:"css"`
+	LatexPre  string     `json:"latexPre"`
+	LatexPost string     `json:"latexPost"`
+	Req       [][]any    `json:"req"`
+	Tags      []string   `json:"tags"`
+	Vers      []any      `json:"vers"`
+}
+
+type template struct {
+	Name  string `json:"name"`
+	Ord   int    `json:"ord"`
+	Qfmt  string `json:"qfmt"`
+	Afmt  string `json:"afmt"`
+	Bqfmt string `json:"bqfmt"`
+	Bafmt string `json:"bafmt"`
+	Did   *int64 `json:"did"`
+}
+
+type field struct {
+	Name   string `json:"name"`
+	Ord    int    `json:"ord"`
+	Sticky bool   `json:"sticky"`
+	Rtl    bool   `json:"rtl"`
+	Font   string `json:"font"`
+	Size   int    `json:"size"`
+	Media  []any  `json:"media"`
+}
+
+// newModel returns a note type with n fields, named Front, Back, Field 3...,
+// and one card template with the first field on the front.
+func newModel(id, mod int64, n int) model {
+	m := model{
+		Id:        id,
+		Name:      "Basic (go-srs)",
+		Mod:       mod,
+		Usn:       -1,
+		Did:       defaultDeckId,
+		Css:       ".card {\n font-family: arial;\n font-size: 20px;\n text-align: center;\n color: black;\n background-color: white;\n}\n",
+		LatexPre:  "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n",
+		LatexPost: "\\end{document}",
+		Req:       [][]any{{0, "any", []int{0}}},
+		Tags:      []string{},
+		Vers:      []any{},
+	}
+
+	for i := 0; i < n; i++ {
+		name := "Field " + strconv.Itoa(i+1)
+		switch i {
+		case 0:
+			name = "Front"
+		case 1:
+			name = "Back"
+		}
+
+		m.Flds = append(m.Flds, field{Name: name, Ord: i, Font: "Arial", Size: 20, Media: []any{}})
+	}
+
+	m.Tmpls = []template{{
+		Name: "Card 1",
+		Qfmt: "{{Front}}",
+		Afmt: "{{FrontSide}}\n\n<hr id=answer>\n\n{{Back}}",
+	}}
+
+	return m
+}
+
+// newDeck returns a deck of the decks JSON of the col row
+func newDeck(id int64, name string, mod int64) map[string]any {
+	return map[string]any{
+		"id":               id,
+		"name":             name,
+		"mod":              mod,
+		"usn":              -1,
+		"desc":             "",
+		"dyn":              0,
+		"conf":             1,
+		"collapsed":        false,
+		"browserCollapsed": false,
+		"extendNew":        0,
+		"extendRev":        0,
+		"newToday":         []int{0, 0},
+		"revToday":         []int{0, 0},
+		"lrnToday":         []int{0, 0},
+		"timeToday":        []int{0, 0},
+	}
+}
+
+// defaultConf is the deck options group of the decks
+const defaultConf = `{"1": {"id": 1, "name": "Default", "mod": 0, "usn": 0, "maxTaken": 60,
+"autoplay": true, "timer": 0, "replayq": true, "dyn": false,
+"new": {"delays": [1, 10], "ints": [1, 4, 7], "initialFactor": 2500, "order": 1, "perDay": 20, "bury": false, "separate": true},
+"rev": {"perDay": 200, "ease4": 1.3, "fuzz": 0.05, "maxIvl": 36500, "ivlFct": 1, "minSpace": 1, "bury": false, "hardFactor": 1.2},
+"lapse": {"delays": [10], "mult": 0, "minInt": 1, "leechFails": 8, "leechAction": 1}}}`
+
+// checksum is the csum of a note: the first 8 hex digits of the sha1 of the
+// sort field
+func checksum(sfld string) int64 {
+	sum := sha1.Sum([]byte(sfld))
+	return int64(binary.BigEndian.Uint32(sum[:4]))
+}
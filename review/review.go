@@ -2,6 +2,8 @@ package review
 
 import (
 	"errors"
+	"sort"
+	"time"
 )
 
 var (
@@ -52,6 +54,11 @@ func (q Quality) Validate() error {
 	return nil
 }
 
+// Correct reports if the quality is a correct response
+func (q Quality) Correct() bool {
+	return q >= CorrectHard
+}
+
 // Review contains the ReviewItem for a DeckId
 // A DeckId is some external id that identify a collection of Cards belonging
 // to some User.
@@ -66,6 +73,23 @@ type Review struct {
 type ReviewItem struct {
 	CardId  int
 	Quality Quality
+
+	// ReviewedAt is the time of the review, for reviews made offline and
+	// uploaded later. It is optional: a zero ReviewedAt means the current
+	// time of the algo.
+	ReviewedAt time.Time
+
+	// Elapsed is the time taken to answer the card. It is optional.
+	Elapsed time.Duration
+}
+
+// Time returns the time of the review: ReviewedAt, or now if not given.
+func (ri ReviewItem) Time(now time.Time) time.Time {
+	if ri.ReviewedAt.IsZero() {
+		return now
+	}
+
+	return ri.ReviewedAt
 }
 
 // Due contains all DueItems (CardId) that need to be reviewed.
@@ -74,9 +98,51 @@ type Due struct {
 	Items  []DueItem
 }
 
+// DueItem contains the schedule of a card.
 type DueItem struct {
 	CardId int
-	//Due time.Time
+
+	// Due is the time (UTC) of the next review
+	Due time.Time
+
+	// Interval is the time between the last review and Due
+	Interval time.Duration
+
+	State State
+}
+
+// State is an algorithm agnostic summary of the scheduling phase of a card.
+// Each algo translates its own state to it.
+type State int
+
+const (
+	// StateNew cards have not been reviewed yet
+	StateNew State = iota
+
+	// StateLearning cards are being learned for the first time, in short
+	// intervals
+	StateLearning
+
+	// StateReview cards are scheduled in long intervals
+	StateReview
+
+	// StateRelearning cards are being learned again after a failed review
+	StateRelearning
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateLearning:
+		return "learning"
+	case StateReview:
+		return "review"
+	case StateRelearning:
+		return "relearning"
+	}
+
+	return "unknown"
 }
 
 // Validate the Review
@@ -129,6 +195,38 @@ func (r *Review) Validate() error {
 	return nil
 }
 
+// Chronological returns the Items sorted by ReviewedAt. Items without
+// ReviewedAt happen now, so they are placed after the others. Items with the
+// same time keep their order.
+func (r *Review) Chronological() []ReviewItem {
+	items := make([]ReviewItem, len(r.Items))
+	for i, idx := range r.ChronologicalOrder() {
+		items[i] = r.Items[idx]
+	}
+
+	return items
+}
+
+// ChronologicalOrder returns the indexes of the Items in the order of
+// Chronological.
+func (r *Review) ChronologicalOrder() []int {
+	idx := make([]int, len(r.Items))
+	for i := range idx {
+		idx[i] = i
+	}
+
+	sort.SliceStable(idx, func(i, j int) bool {
+		ti, tj := r.Items[idx[i]].ReviewedAt, r.Items[idx[j]].ReviewedAt
+		if ti.IsZero() || tj.IsZero() {
+			return !ti.IsZero() && tj.IsZero()
+		}
+
+		return ti.Before(tj)
+	})
+
+	return idx
+}
+
 func (r *Review) AllNewCards() bool {
 	return r.Items[0].CardId == 0
 }
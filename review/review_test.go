@@ -2,6 +2,7 @@ package review_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/revelaction/go-srs/review"
 )
@@ -104,3 +105,48 @@ func TestAllNewCards(t *testing.T) {
 		t.Errorf("expected all new cards")
 	}
 }
+
+func TestChronological(t *testing.T) {
+
+	t1 := time.Date(2020, time.November, 1, 10, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	r := review.Review{}
+	r.DeckId = "hi"
+	r.Items = []review.ReviewItem{
+		{CardId: 1, Quality: 1},
+		{CardId: 1, Quality: 2, ReviewedAt: t2},
+		{CardId: 2, Quality: 3},
+		{CardId: 1, Quality: 4, ReviewedAt: t1},
+	}
+
+	want := []review.Quality{4, 2, 1, 3}
+
+	items := r.Chronological()
+	for idx, item := range items {
+		if item.Quality != want[idx] {
+			t.Errorf("\ngot quality %d at %d\nwant quality %d", item.Quality, idx, want[idx])
+		}
+	}
+
+	// the review is not modified
+	if r.Items[0].Quality != 1 {
+		t.Errorf("\ngot quality %d\nwant quality %d", r.Items[0].Quality, 1)
+	}
+}
+
+func TestReviewItemTime(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 10, 0, 0, 0, time.UTC)
+	reviewedAt := now.Add(-5 * time.Hour)
+
+	ri := review.ReviewItem{CardId: 1, Quality: 4}
+	if got := ri.Time(now); !got.Equal(now) {
+		t.Errorf("\ngot %s\nwant %s", got, now)
+	}
+
+	ri.ReviewedAt = reviewedAt
+	if got := ri.Time(now); !got.Equal(reviewedAt) {
+		t.Errorf("\ngot %s\nwant %s", got, reviewedAt)
+	}
+}
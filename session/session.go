@@ -0,0 +1,270 @@
+// Package session builds the study queue of a deck for a day on top of an
+// srs.Srs.
+//
+// A Session takes the due cards of the deck, limits the new and review cards
+// to what is left of the daily limits, and interleaves them by a Policy.
+// Failed cards with a short interval are shown again before the session ends.
+package session
+
+import (
+	"errors"
+	"time"
+
+	"github.com/revelaction/go-srs"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+// ErrEmpty is returned by Answer when the session has no cards left
+var ErrEmpty = errors.New("session has no cards left")
+
+// Policy is the order of the new cards among the review cards
+type Policy int
+
+const (
+	// ReviewsFirst shows the new cards after the review cards
+	ReviewsFirst Policy = iota
+
+	// NewFirst shows the new cards before the review cards
+	NewFirst
+
+	// Mix spreads the new cards evenly among the review cards
+	Mix
+)
+
+// Config configures the sessions of a deck.
+type Config struct {
+
+	// NewPerDay is the maximum number of new cards studied per day. Zero
+	// means no limit.
+	NewPerDay int
+
+	// ReviewsPerDay is the maximum number of review cards studied per day.
+	// Zero means no limit.
+	ReviewsPerDay int
+
+	Policy Policy
+
+	// Order is the order of the review cards, and of the new cards, before
+	// they are interleaved.
+	Order db.Order
+
+	// Seed of the db.OrderRandom order
+	Seed int64
+
+	// Relearn is the maximum interval of a failed card to be shown again at
+	// the end of the session. Zero disables requeueing.
+	Relearn time.Duration
+}
+
+// Session is the study queue of a deck for a day.
+//
+// The daily limits are reduced by the reviews of the deck log made in the
+// same day, so a session can be built again after a restart. A Session is
+// not safe for concurrent use.
+type Session struct {
+	Srs    *srs.Srs
+	DeckId string
+	Config Config
+	Clock  clock.Clock
+
+	queue []review.DueItem
+}
+
+// New builds the session of the deck at the current time of the clock c.
+func New(s *srs.Srs, deckId string, cfg Config, c clock.Clock) (*Session, error) {
+	sess := &Session{
+		Srs:    s,
+		DeckId: deckId,
+		Config: cfg,
+		Clock:  c,
+	}
+
+	if err := sess.build(); err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// build fills the queue with the due cards left for today
+func (s *Session) build() error {
+	now := s.Clock.Now()
+
+	newToday, reviewsToday, err := s.studied(startOfDay(now))
+	if err != nil {
+		return err
+	}
+
+	due, err := s.Srs.DueQuery(db.Query{DeckId: s.DeckId, T: now, Order: s.Config.Order, Seed: s.Config.Seed})
+	if err != nil {
+		return err
+	}
+
+	var newCards, reviews []review.DueItem
+	for _, item := range due.Items {
+		if item.State == review.StateNew {
+			newCards = append(newCards, item)
+			continue
+		}
+
+		reviews = append(reviews, item)
+	}
+
+	newCards = limit(newCards, s.Config.NewPerDay, newToday)
+	reviews = limit(reviews, s.Config.ReviewsPerDay, reviewsToday)
+
+	s.queue = interleave(newCards, reviews, s.Config.Policy)
+
+	return nil
+}
+
+// studied counts the new and review cards studied in the deck in the day that
+// starts at from. A review is of a new card if it is the first review of the
+// card: the log before the day is only read for the cards studied in it.
+func (s *Session) studied(from time.Time) (newCards, reviews int, err error) {
+	entries, err := s.Srs.DeckLogRange(s.DeckId, from, from.AddDate(0, 0, 1))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reviewed := map[int]bool{}
+
+	for _, e := range entries {
+		if e.Quality == review.NoReview {
+			continue
+		}
+
+		if !reviewed[e.CardId] {
+			reviewed[e.CardId] = true
+
+			before, err := s.reviewedBefore(e.CardId, from)
+			if err != nil {
+				return 0, 0, err
+			}
+
+			if !before {
+				newCards++
+				continue
+			}
+		}
+
+		reviews++
+	}
+
+	return newCards, reviews, nil
+}
+
+// reviewedBefore reports if the card was reviewed before the time t
+func (s *Session) reviewedBefore(cardId int, t time.Time) (bool, error) {
+	entries, err := s.Srs.CardLog(s.DeckId, cardId)
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range entries {
+		if e.Quality != review.NoReview && e.ReviewedAt.Before(t) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Len returns the number of cards left in the session
+func (s *Session) Len() int {
+	return len(s.queue)
+}
+
+// Next returns the card to study, and false if the session is done.
+func (s *Session) Next() (review.DueItem, bool) {
+	if len(s.queue) == 0 {
+		return review.DueItem{}, false
+	}
+
+	return s.queue[0], true
+}
+
+// Answer saves the review of the card returned by Next, and returns its new
+// schedule. elapsed is the time taken to answer, and is optional.
+//
+// A failed card whose new interval is at most Relearn is shown again at the
+// end of the session.
+func (s *Session) Answer(q review.Quality, elapsed time.Duration) (review.DueItem, error) {
+	item, ok := s.Next()
+	if !ok {
+		return review.DueItem{}, ErrEmpty
+	}
+
+	r := review.Review{
+		DeckId: s.DeckId,
+		Items:  []review.ReviewItem{{CardId: item.CardId, Quality: q, Elapsed: elapsed}},
+	}
+
+	due, err := s.Srs.Update(r)
+	if err != nil {
+		return review.DueItem{}, err
+	}
+
+	s.queue = s.queue[1:]
+
+	next := due.Items[0]
+	if !q.Correct() && s.Config.Relearn > 0 && next.Interval <= s.Config.Relearn {
+		s.queue = append(s.queue, next)
+	}
+
+	return next, nil
+}
+
+// limit returns the items left of the daily limit max after done items. A
+// zero max means no limit.
+func limit(items []review.DueItem, max, done int) []review.DueItem {
+	if max <= 0 {
+		return items
+	}
+
+	left := max - done
+	if left <= 0 {
+		return nil
+	}
+
+	if left < len(items) {
+		return items[:left]
+	}
+
+	return items
+}
+
+// interleave merges the new cards and the review cards by the policy
+func interleave(newCards, reviews []review.DueItem, p Policy) []review.DueItem {
+	queue := make([]review.DueItem, 0, len(newCards)+len(reviews))
+
+	switch p {
+	case NewFirst:
+		queue = append(queue, newCards...)
+		return append(queue, reviews...)
+	case Mix:
+		// a new card after each group of reviews, the groups of even size
+		groups := len(newCards) + 1
+		for i := 0; i < groups; i++ {
+			size := len(reviews) / (groups - i)
+			queue = append(queue, reviews[:size]...)
+			reviews = reviews[size:]
+
+			if i < len(newCards) {
+				queue = append(queue, newCards[i])
+			}
+		}
+
+		return queue
+	default:
+		queue = append(queue, reviews...)
+		return append(queue, newCards...)
+	}
+}
+
+// startOfDay returns the midnight of the day of t, in the location of t
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
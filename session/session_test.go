@@ -0,0 +1,230 @@
+package session_test
+
+import (
+	"fmt"
+	ulidPkg "github.com/oklog/ulid/v2"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs"
+	"github.com/revelaction/go-srs/algo/anki"
+	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db/memory"
+	"github.com/revelaction/go-srs/review"
+	"github.com/revelaction/go-srs/session"
+	"github.com/revelaction/go-srs/uid/ulid"
+)
+
+// newDeck returns a deck with the review cards 1, 2 and 3 and the new cards
+// 4 to 7, all due at the time of the returned clock
+func newDeck(t *testing.T) (*srs.Srs, string, *clock.Fake) {
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+
+	entropy := ulidPkg.Monotonic(rand.New(rand.NewSource(now.UnixNano())), 0)
+	hdl := srs.New(memory.NewWithClock(sm2.NewWithClock(c), c), ulid.New(entropy))
+
+	r := review.Review{}
+	for i := 0; i < 3; i++ {
+		r.Items = append(r.Items, review.ReviewItem{Quality: review.NoReview})
+	}
+
+	due, err := hdl.Update(r)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	deckId := due.DeckId
+
+	// cards 1 to 3 are due the 2020-11-04
+	c.Advance(24 * time.Hour)
+
+	r.DeckId = deckId
+	r.Items = []review.ReviewItem{
+		{CardId: 1, Quality: review.CorrectEasy},
+		{CardId: 2, Quality: review.CorrectEasy},
+		{CardId: 3, Quality: review.CorrectEasy},
+	}
+
+	if _, err := hdl.Update(r); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// cards 4 to 7 are due the 2020-11-03
+	r.Items = nil
+	for i := 0; i < 4; i++ {
+		r.Items = append(r.Items, review.ReviewItem{Quality: review.NoReview})
+	}
+
+	if _, err := hdl.Update(r); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	c.Advance(48*time.Hour + time.Hour)
+
+	return hdl, deckId, c
+}
+
+func queueIds(s *session.Session) []int {
+	ids := []int{}
+	for {
+		item, ok := s.Next()
+		if !ok {
+			return ids
+		}
+
+		ids = append(ids, item.CardId)
+
+		if _, err := s.Answer(review.CorrectEasy, 0); err != nil {
+			return append(ids, -1)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+
+	tests := []struct {
+		cfg  session.Config
+		want []int
+	}{
+		{cfg: session.Config{}, want: []int{1, 2, 3, 4, 5, 6, 7}},
+		{cfg: session.Config{Policy: session.NewFirst}, want: []int{4, 5, 6, 7, 1, 2, 3}},
+		{cfg: session.Config{Policy: session.Mix}, want: []int{4, 5, 1, 6, 2, 7, 3}},
+		{cfg: session.Config{Policy: session.Mix, NewPerDay: 2, ReviewsPerDay: 2}, want: []int{4, 1, 5, 2}},
+		{cfg: session.Config{NewPerDay: 1}, want: []int{1, 2, 3, 4}},
+	}
+
+	for _, tc := range tests {
+		hdl, deckId, c := newDeck(t)
+
+		s, err := session.New(hdl, deckId, tc.cfg, c)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if s.Len() != len(tc.want) {
+			t.Errorf("\nChecking len:\ngot %d\nwant %d", s.Len(), len(tc.want))
+		}
+
+		if got := queueIds(s); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("\nconfig %#v\ngot %v\nwant %v", tc.cfg, got, tc.want)
+		}
+	}
+}
+
+// TestRequeueAndDailyLimits tests that failed cards are shown again, and that
+// a new session of the same day continues the daily limits
+func TestRequeueAndDailyLimits(t *testing.T) {
+
+	hdl, deckId, c := newDeck(t)
+
+	cfg := session.Config{NewPerDay: 3, ReviewsPerDay: 3, Relearn: 24 * time.Hour}
+
+	s, err := session.New(hdl, deckId, cfg, c)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	// fail card 1 and new card 4: they are shown again at the end
+	answers := []review.Quality{
+		review.IncorrectFamiliar, review.CorrectEasy, review.CorrectEasy,
+		review.IncorrectFamiliar, review.CorrectEasy, review.CorrectEasy,
+		review.CorrectEasy, review.CorrectEasy,
+	}
+
+	got := []int{}
+	for _, q := range answers {
+		item, ok := s.Next()
+		if !ok {
+			t.Fatalf("got empty session after %v", got)
+		}
+
+		got = append(got, item.CardId)
+
+		if _, err := s.Answer(q, time.Second); err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+	}
+
+	want := []int{1, 2, 3, 4, 5, 6, 1, 4}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+
+	if _, err := s.Answer(review.CorrectEasy, 0); err != session.ErrEmpty {
+		t.Errorf("\ngot error %v\nwant %v", err, session.ErrEmpty)
+	}
+
+	// the limits of the day are used: card 7 waits for tomorrow
+	c.Advance(time.Hour)
+
+	s, err = session.New(hdl, deckId, cfg, c)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if s.Len() != 0 {
+		t.Errorf("\nChecking len:\ngot %d\nwant %d", s.Len(), 0)
+	}
+
+	c.Advance(24 * time.Hour)
+
+	s, err = session.New(hdl, deckId, cfg, c)
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	want = []int{7}
+	if got := queueIds(s); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+}
+
+// TestRelearnDisabled tests that a zero Relearn does not requeue failed cards,
+// also those with a zero interval
+func TestRelearnDisabled(t *testing.T) {
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+
+	a := anki.NewWithClock(c)
+	a.LearningSteps = []time.Duration{0}
+
+	entropy := ulidPkg.Monotonic(rand.New(rand.NewSource(now.UnixNano())), 0)
+	hdl := srs.New(memory.NewWithClock(a, c), ulid.New(entropy))
+
+	due, err := hdl.Update(review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}}})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	c.Advance(time.Hour)
+
+	for _, relearn := range []time.Duration{0, time.Hour} {
+		c.Advance(time.Second)
+
+		s, err := session.New(hdl, due.DeckId, session.Config{Relearn: relearn}, c)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		next, err := s.Answer(review.IncorrectFamiliar, 0)
+		if err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+
+		if next.Interval != 0 {
+			t.Fatalf("\ngot %#v\nwant %#v", next.Interval, time.Duration(0))
+		}
+
+		want := 0
+		if relearn > 0 {
+			want = 1
+		}
+
+		if s.Len() != want {
+			t.Errorf("\nChecking len %v:\ngot %d\nwant %d", relearn, s.Len(), want)
+		}
+	}
+}
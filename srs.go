@@ -1,6 +1,8 @@
 package srs
 
 import (
+	"context"
+	"sort"
 	"time"
 
 	"github.com/revelaction/go-srs/db"
@@ -8,24 +10,58 @@ import (
 	"github.com/revelaction/go-srs/uid"
 )
 
+// DefaultLeechThreshold is the LeechThreshold of new Srs
+const DefaultLeechThreshold = 8
+
+// LeechPolicy is the action taken on a card that becomes a leech
+type LeechPolicy int
+
+const (
+	// LeechTag only marks the card as leech
+	LeechTag LeechPolicy = iota
+
+	// LeechSuspend marks and suspends the card
+	LeechSuspend
+
+	// LeechEvent marks the card and calls OnLeech
+	LeechEvent
+)
+
 // Srs encapsulates the db handler and the UID generator.
 //
 // Deck and card ids are provided by this package
 type Srs struct {
 	Db  db.Handler
 	UID uid.UID
+
+	// LeechThreshold is the number of lapses that makes a card a leech. Zero
+	// disables the leech detection.
+	LeechThreshold int
+
+	LeechPolicy LeechPolicy
+
+	// OnLeech is called with the new leeches of the deck after an Update,
+	// if LeechPolicy is LeechEvent
+	OnLeech func(deckId string, cardIds []int)
 }
 
 func New(db db.Handler, id uid.UID) *Srs {
 	return &Srs{
-		Db:  db,
-		UID: id,
+		Db:             db,
+		UID:            id,
+		LeechThreshold: DefaultLeechThreshold,
 	}
 }
 
 // Update update the cards in Review, persist then in th db and returns the
-// updated Cards due time.
+// updated Cards due time, interval and state.
 func (h *Srs) Update(r review.Review) (due review.Due, err error) {
+	return h.UpdateContext(context.Background(), r)
+}
+
+// UpdateContext is Update with a context. If ctx is done, the db is not
+// changed and ctx.Err() is returned.
+func (h *Srs) UpdateContext(ctx context.Context, r review.Review) (due review.Due, err error) {
 
 	err = r.Validate()
 	if err != nil {
@@ -37,7 +73,7 @@ func (h *Srs) Update(r review.Review) (due review.Due, err error) {
 		// is external
 		deckId := h.UID.Create()
 
-		due, err = h.Db.Insert(r, deckId)
+		due, err = h.Db.InsertContext(ctx, r, deckId)
 		if err != nil {
 			return due, err
 		}
@@ -48,7 +84,7 @@ func (h *Srs) Update(r review.Review) (due review.Due, err error) {
 	// 2) we have DeckId and all cards are new. Look up and insert after last
 	// index
 	if r.AllNewCards() {
-		due, err = h.Db.Insert(r, "")
+		due, err = h.Db.InsertContext(ctx, r, "")
 		if err != nil {
 			return review.Due{}, err
 		}
@@ -57,22 +93,219 @@ func (h *Srs) Update(r review.Review) (due review.Due, err error) {
 
 	}
 
-	//3) review has DeckId and cardIds: update for existent
-	due, err = h.Db.Update(r)
+	//3) review has DeckId and cardIds: update for existent. The new leeches
+	// are marked, and suspended, in the same transaction
+	rule := db.LeechRule{Threshold: h.LeechThreshold, Suspend: h.LeechPolicy == LeechSuspend}
+
+	due, leeches, err := h.Db.UpdateLeechesContext(ctx, r, rule)
 	if err != nil {
 		return review.Due{}, err
 	}
 
+	if h.LeechPolicy == LeechEvent && h.OnLeech != nil && len(leeches) > 0 {
+		h.OnLeech(due.DeckId, leeches)
+	}
+
 	return due, nil
 }
 
-// Due returns all card ids that are due to be reviewed at time t
+// Due returns all cards that are due to be reviewed at time t, with their
+// due time, interval and state.
 func (h *Srs) Due(deckId string, t time.Time) (due review.Due, err error) {
+	return h.DueContext(context.Background(), deckId, t)
+}
+
+// DueContext is Due with a context.
+func (h *Srs) DueContext(ctx context.Context, deckId string, t time.Time) (due review.Due, err error) {
+
+	due, err = h.Db.DueContext(ctx, deckId, t)
+	if err != nil {
+		return due, err
+	}
+
+	return due, nil
+}
+
+// DueNow returns all card ids that are due to be reviewed at the current time
+// of the db handler clock.
+func (h *Srs) DueNow(deckId string) (due review.Due, err error) {
+	return h.Due(deckId, time.Time{})
+}
+
+// DueQuery returns a page of the cards that are due to be reviewed, in the
+// order of the query q. A study session can fetch the next cards with
+// increasing offsets.
+func (h *Srs) DueQuery(q db.Query) (due review.Due, err error) {
+	return h.DueQueryContext(context.Background(), q)
+}
+
+// DueQueryContext is DueQuery with a context.
+func (h *Srs) DueQueryContext(ctx context.Context, q db.Query) (due review.Due, err error) {
 
-	due, err = h.Db.Due(deckId, t)
+	due, err = h.Db.DueQueryContext(ctx, q)
 	if err != nil {
 		return due, err
 	}
 
 	return due, nil
 }
+
+// Forecast returns the number of cards of the deck due on each calendar day,
+// for the days from the day of from on, in the location of from. Cards
+// overdue at the start of the first day count on the first day. Suspended
+// cards are not counted, and buried cards count on the day they are shown
+// again if it is later than their due day.
+//
+// The forecast reads the due index of the db up to the end of the last day,
+// with DueTimes.
+func (h *Srs) Forecast(deckId string, from time.Time, days int) ([]int, error) {
+	return h.ForecastContext(context.Background(), deckId, from, days)
+}
+
+// ForecastContext is Forecast with a context.
+func (h *Srs) ForecastContext(ctx context.Context, deckId string, from time.Time, days int) ([]int, error) {
+	if days <= 0 {
+		return []int{}, nil
+	}
+
+	// ends[i] is the start of the day after the day i
+	start := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	ends := make([]time.Time, days)
+	for i := range ends {
+		ends[i] = start.AddDate(0, 0, i+1)
+	}
+
+	// overdue cards are read from the start of the index
+	times, err := h.Db.DueTimesContext(ctx, deckId, time.Time{}, ends[days-1])
+	if err != nil {
+		return nil, err
+	}
+
+	forecast := make([]int, days)
+	for _, shown := range times {
+		i := sort.Search(days, func(i int) bool {
+			return shown.Before(ends[i])
+		})
+
+		forecast[i]++
+	}
+
+	return forecast, nil
+}
+
+// DeleteCards removes the cards of the deck. Their ids are not reused.
+func (h *Srs) DeleteCards(deckId string, cardIds []int) error {
+	return h.Db.DeleteCards(deckId, cardIds)
+}
+
+// DeleteCardsContext is DeleteCards with a context.
+func (h *Srs) DeleteCardsContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.Db.DeleteCardsContext(ctx, deckId, cardIds)
+}
+
+// DeleteDeck removes the deck and all its cards.
+func (h *Srs) DeleteDeck(deckId string) error {
+	return h.Db.DeleteDeck(deckId)
+}
+
+// DeleteDeckContext is DeleteDeck with a context.
+func (h *Srs) DeleteDeckContext(ctx context.Context, deckId string) error {
+	return h.Db.DeleteDeckContext(ctx, deckId)
+}
+
+// Suspend hides the cards from the due cards, without losing their
+// schedule, until Unsuspend is called.
+func (h *Srs) Suspend(deckId string, cardIds []int) error {
+	return h.Db.Suspend(deckId, cardIds)
+}
+
+// SuspendContext is Suspend with a context.
+func (h *Srs) SuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.Db.SuspendContext(ctx, deckId, cardIds)
+}
+
+// Unsuspend shows again suspended or buried cards.
+func (h *Srs) Unsuspend(deckId string, cardIds []int) error {
+	return h.Db.Unsuspend(deckId, cardIds)
+}
+
+// UnsuspendContext is Unsuspend with a context.
+func (h *Srs) UnsuspendContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.Db.UnsuspendContext(ctx, deckId, cardIds)
+}
+
+// Bury hides the cards from the due cards until the time until. A zero until
+// means until tomorrow.
+func (h *Srs) Bury(deckId string, cardIds []int, until time.Time) error {
+	return h.Db.Bury(deckId, cardIds, until)
+}
+
+// BuryContext is Bury with a context.
+func (h *Srs) BuryContext(ctx context.Context, deckId string, cardIds []int, until time.Time) error {
+	return h.Db.BuryContext(ctx, deckId, cardIds, until)
+}
+
+// CardLog returns the review log of the card.
+func (h *Srs) CardLog(deckId string, cardId int) ([]db.LogEntry, error) {
+	return h.Db.CardLog(deckId, cardId)
+}
+
+// CardLogContext is CardLog with a context.
+func (h *Srs) CardLogContext(ctx context.Context, deckId string, cardId int) ([]db.LogEntry, error) {
+	return h.Db.CardLogContext(ctx, deckId, cardId)
+}
+
+// DeckLog returns the review log of all the cards of the deck.
+func (h *Srs) DeckLog(deckId string) ([]db.LogEntry, error) {
+	return h.Db.DeckLog(deckId)
+}
+
+// DeckLogContext is DeckLog with a context.
+func (h *Srs) DeckLogContext(ctx context.Context, deckId string) ([]db.LogEntry, error) {
+	return h.Db.DeckLogContext(ctx, deckId)
+}
+
+// DeckLogRange returns the review log of the deck reviewed in the range
+// [from, to).
+func (h *Srs) DeckLogRange(deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	return h.Db.DeckLogRange(deckId, from, to)
+}
+
+// DeckLogRangeContext is DeckLogRange with a context.
+func (h *Srs) DeckLogRangeContext(ctx context.Context, deckId string, from, to time.Time) ([]db.LogEntry, error) {
+	return h.Db.DeckLogRangeContext(ctx, deckId, from, to)
+}
+
+// Leeches returns the cards of the deck marked as leech, with their lapses.
+func (h *Srs) Leeches(deckId string) ([]db.Leech, error) {
+	return h.Db.Leeches(deckId)
+}
+
+// LeechesContext is Leeches with a context.
+func (h *Srs) LeechesContext(ctx context.Context, deckId string) ([]db.Leech, error) {
+	return h.Db.LeechesContext(ctx, deckId)
+}
+
+// UnmarkLeeches clears the leech mark and the lapses of the cards, for
+// example after they were rewritten. Suspended leeches stay suspended until
+// Unsuspend.
+func (h *Srs) UnmarkLeeches(deckId string, cardIds []int) error {
+	return h.Db.UnmarkLeeches(deckId, cardIds)
+}
+
+// UnmarkLeechesContext is UnmarkLeeches with a context.
+func (h *Srs) UnmarkLeechesContext(ctx context.Context, deckId string, cardIds []int) error {
+	return h.Db.UnmarkLeechesContext(ctx, deckId, cardIds)
+}
+
+// Undo restores the cards of the last Update of the deck to their previous
+// state, and returns their due time, interval and state. Reviews of new cards
+// can not be undone: they are inserted, which clears the undo of the deck.
+func (h *Srs) Undo(deckId string) (review.Due, error) {
+	return h.Db.Undo(deckId)
+}
+
+// UndoContext is Undo with a context.
+func (h *Srs) UndoContext(ctx context.Context, deckId string) (review.Due, error) {
+	return h.Db.UndoContext(ctx, deckId)
+}
@@ -1,6 +1,7 @@
 package srs_test
 
 import (
+	"errors"
 	ulidPkg "github.com/oklog/ulid/v2"
 	badger "github.com/outcaste-io/badger/v3"
 	"math/rand"
@@ -10,7 +11,10 @@ import (
 
 	"github.com/revelaction/go-srs"
 	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
+	dbPkg "github.com/revelaction/go-srs/db"
 	bdg "github.com/revelaction/go-srs/db/badger"
+	"github.com/revelaction/go-srs/db/memory"
 	"github.com/revelaction/go-srs/review"
 	"github.com/revelaction/go-srs/uid/ulid"
 )
@@ -247,3 +251,298 @@ func TestInsertUpdateDue(t *testing.T) {
 	}
 
 }
+
+func TestDeleteCardsAndDeck(t *testing.T) {
+
+	// Algo
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+	sm2 := sm2.New(now)
+
+	dir, err := os.MkdirTemp(".", "badger")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir) // clean up
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+	bad, _ := badger.Open(opts)
+	defer bad.Close()
+
+	db := bdg.New(bad, sm2)
+
+	// fixed  entropy value
+	ti := time.Unix(1000000, 0)
+	entropy := ulidPkg.Monotonic(rand.New(rand.NewSource(ti.UnixNano())), 0)
+
+	uid := ulid.New(entropy)
+
+	hdl := srs.New(db, uid)
+
+	r := review.Review{}
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+		{Quality: review.NoReview},
+		{Quality: review.NoReview},
+	}
+
+	res, err := hdl.Update(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deckId := res.DeckId
+
+	// delete the first and the last card: the last id is not reused
+	err = hdl.DeleteCards(deckId, []int{1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.DeckId = deckId
+	r.Items = []review.ReviewItem{
+		{Quality: review.NoReview},
+	}
+
+	res, err = hdl.Update(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Items[0].CardId != 4 {
+		t.Errorf("\ngot cardId %d\nwant cardId %d", res.Items[0].CardId, 4)
+	}
+
+	tdue := now.AddDate(0, 0, 2)
+	due, err := hdl.Due(deckId, tdue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantIds := []int{2, 4}
+	if len(due.Items) != len(wantIds) {
+		t.Fatalf("\nCheking len:\ngot %d\nwant %d", len(due.Items), len(wantIds))
+	}
+
+	for idx, item := range due.Items {
+		if item.CardId != wantIds[idx] {
+			t.Errorf("\ngot cardId %d\nwant cardId %d", item.CardId, wantIds[idx])
+		}
+	}
+
+	// deleted cards can not be deleted again
+	err = hdl.DeleteCards(deckId, []int{3})
+	if !errors.Is(err, dbPkg.ErrCardIdNotExists) {
+		t.Errorf("\ngot error %v\nwant %v", err, dbPkg.ErrCardIdNotExists)
+	}
+
+	err = hdl.DeleteDeck(deckId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err = hdl.Due(deckId, tdue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due.Items) != 0 {
+		t.Errorf("\nCheking len:\ngot %d\nwant %d", len(due.Items), 0)
+	}
+
+	// the deck does not exist anymore
+	err = hdl.DeleteDeck(deckId)
+	if !errors.Is(err, dbPkg.ErrDeckIdNotExists) {
+		t.Errorf("\ngot error %v\nwant %v", err, dbPkg.ErrDeckIdNotExists)
+	}
+}
+
+func TestLeechPolicy(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	// lapse reviews the card 1 incorrect after a correct review
+	lapse := func(hdl *srs.Srs, c *clock.Fake, deckId string) {
+		t.Helper()
+
+		for _, q := range []review.Quality{review.CorrectEasy, review.IncorrectBlackout} {
+			c.Advance(24 * time.Hour)
+
+			r := review.Review{DeckId: deckId, Items: []review.ReviewItem{{CardId: 1, Quality: q}}}
+			if _, err := hdl.Update(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	newSrs := func(policy srs.LeechPolicy) (*srs.Srs, *clock.Fake, string) {
+		t.Helper()
+
+		c := clock.NewFake(now)
+		entropy := ulidPkg.Monotonic(rand.New(rand.NewSource(now.UnixNano())), 0)
+
+		hdl := srs.New(memory.NewWithClock(sm2.NewWithClock(c), c), ulid.New(entropy))
+		hdl.LeechThreshold = 2
+		hdl.LeechPolicy = policy
+
+		res, err := hdl.Update(review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return hdl, c, res.DeckId
+	}
+
+	// LeechSuspend: the card is not due after the second lapse
+	hdl, c, deckId := newSrs(srs.LeechSuspend)
+
+	lapse(hdl, c, deckId)
+	due, err := hdl.Due(deckId, c.Now().AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due.Items) != 1 {
+		t.Errorf("\nCheking len:\ngot %d\nwant %d", len(due.Items), 1)
+	}
+
+	lapse(hdl, c, deckId)
+	due, err = hdl.Due(deckId, c.Now().AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due.Items) != 0 {
+		t.Errorf("\nCheking len:\ngot %d\nwant %d", len(due.Items), 0)
+	}
+
+	leeches, err := hdl.Leeches(deckId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []dbPkg.Leech{{CardId: 1, Lapses: 2}}
+	if len(leeches) != 1 || leeches[0] != want[0] {
+		t.Errorf("\ngot %#v\nwant %#v", leeches, want)
+	}
+
+	// undo of the last Update reverts the suspension
+	if _, err := hdl.Undo(deckId); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err = hdl.Due(deckId, c.Now().AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due.Items) != 1 {
+		t.Errorf("\nCheking len:\ngot %d\nwant %d", len(due.Items), 1)
+	}
+
+	// LeechEvent: OnLeech is called once, when the card becomes a leech
+	hdl, c, deckId = newSrs(srs.LeechEvent)
+
+	var events [][]int
+	hdl.OnLeech = func(id string, cardIds []int) {
+		if id != deckId {
+			t.Errorf("\ngot deckId %s\nwant deckId %s", id, deckId)
+		}
+
+		events = append(events, cardIds)
+	}
+
+	for i := 0; i < 3; i++ {
+		lapse(hdl, c, deckId)
+	}
+
+	if len(events) != 1 || len(events[0]) != 1 || events[0][0] != 1 {
+		t.Errorf("\ngot events %v\nwant %v", events, [][]int{{1}})
+	}
+
+	// unmarked cards count the lapses again
+	if err := hdl.UnmarkLeeches(deckId, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	lapse(hdl, c, deckId)
+	lapse(hdl, c, deckId)
+
+	if len(events) != 2 {
+		t.Errorf("\ngot events %v\nwant %d events", events, 2)
+	}
+}
+
+func TestForecast(t *testing.T) {
+
+	now := time.Date(2020, time.November, 1, 10, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	entropy := ulidPkg.Monotonic(rand.New(rand.NewSource(now.UnixNano())), 0)
+
+	hdl := srs.New(memory.NewWithClock(sm2.NewWithClock(c), c), ulid.New(entropy))
+
+	// 5 new cards due the 2020-11-02 10:00
+	r := review.Review{}
+	for i := 0; i < 5; i++ {
+		r.Items = append(r.Items, review.ReviewItem{Quality: review.NoReview})
+	}
+
+	res, err := hdl.Update(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deckId := res.DeckId
+
+	// card 1 due the 2020-11-04, card 2 the 2020-11-03
+	c.Advance(24 * time.Hour)
+	r = review.Review{DeckId: deckId, Items: []review.ReviewItem{
+		{CardId: 1, Quality: review.CorrectEasy},
+		{CardId: 2, Quality: review.IncorrectBlackout},
+	}}
+
+	if _, err := hdl.Update(r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hdl.Suspend(deckId, []int{3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// card 4 is shown again the 2020-11-06
+	if err := hdl.Bury(deckId, []int{4}, time.Date(2020, time.November, 6, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from time.Time
+		days int
+		want []int
+	}{
+		{from: c.Now(), days: 5, want: []int{1, 1, 1, 0, 1}},
+		// card 5 is overdue
+		{from: time.Date(2020, time.November, 3, 0, 0, 0, 0, time.UTC), days: 3, want: []int{2, 1, 0}},
+		// the days of the location: card 2 is due the 2020-11-03 21:00 in UTC+11
+		{from: time.Date(2020, time.November, 3, 0, 0, 0, 0, time.FixedZone("UTC+11", 11*60*60)), days: 2, want: []int{2, 1}},
+		{from: c.Now(), days: 0, want: []int{}},
+	}
+
+	for _, tc := range tests {
+		got, err := hdl.Forecast(deckId, tc.from, tc.days)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != len(tc.want) {
+			t.Fatalf("\nCheking len:\ngot %d\nwant %d", len(got), len(tc.want))
+		}
+
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("\nfrom %v\ngot %v\nwant %v", tc.from, got, tc.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Package stats computes the statistics of a deck from the stored cards and
+// the review log of a db.Handler.
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/revelaction/go-srs/algo"
+	"github.com/revelaction/go-srs/db"
+	"github.com/revelaction/go-srs/review"
+)
+
+const day = 24 * time.Hour
+
+// DefaultMature is the Mature interval of a zero Config
+const DefaultMature = 21 * day
+
+// DefaultIntervalBins are the IntervalBins of a zero Config
+var DefaultIntervalBins = []time.Duration{day, 2 * day, 3 * day, 7 * day, 14 * day, 30 * day, 90 * day, 180 * day, 365 * day}
+
+// Config contains the options of the statistics.
+type Config struct {
+
+	// Location of the calendar days. Nil means UTC.
+	Location *time.Location
+
+	// Mature is the minimum interval of a mature card. Zero means
+	// DefaultMature.
+	Mature time.Duration
+
+	// IntervalBins are the ascending upper bounds of the interval histogram.
+	// Nil means DefaultIntervalBins.
+	IntervalBins []time.Duration
+}
+
+// Stats contains the statistics of a deck.
+//
+// The card counts, ease, difficulty and intervals are the current state of
+// the cards. The review counts, retention, streaks and heatmap only use the
+// reviews of the time range.
+type Stats struct {
+	DeckId string
+
+	// Cards is the number of cards in each state, also the suspended and
+	// buried ones
+	Cards map[review.State]int
+
+	Suspended int
+	Leeches   int
+
+	// Ease is the average ease of the reviewed cards if the algo is an
+	// algo.Easer, otherwise zero.
+	Ease float64
+
+	// Difficulty is the average difficulty of the reviewed cards if the algo
+	// is an algo.Difficulter, otherwise zero.
+	Difficulty float64
+
+	// Intervals is the interval histogram of the reviewed cards. Intervals[i]
+	// is the number of cards with interval up to IntervalBins[i] (and longer
+	// than the previous bin). The last element counts the longer intervals.
+	Intervals []int
+
+	// Days contains a Day for each calendar day of the range
+	Days []Day
+
+	Reviews int
+
+	// MatureReviews is the number of reviews of mature cards, and
+	// MatureCorrect the number of them that were correct
+	MatureReviews int
+	MatureCorrect int
+
+	// Retention is the true retention: the pass rate of the reviews of
+	// mature cards. It is zero without mature reviews.
+	Retention float64
+
+	// CurrentStreak is the number of consecutive days with reviews until the
+	// last day of the range. A last day without reviews does not break the
+	// streak: it is still in progress.
+	CurrentStreak int
+
+	// LongestStreak is the highest number of consecutive days with reviews
+	// in the range
+	LongestStreak int
+
+	// Heatmap is the calendar of the number of reviews per day, one row per
+	// week starting on Monday. Days outside the range are zero.
+	Heatmap [][7]int
+}
+
+// Day contains the reviews of a calendar day.
+type Day struct {
+
+	// Date is the start of the day
+	Date time.Time
+
+	Reviews int
+	Correct int
+
+	// New is the number of first reviews of cards
+	New int
+
+	// Time is the sum of the answer times of the reviews, if given
+	Time time.Duration
+}
+
+// Deck computes the statistics of the deck for the calendar days of from and
+// to, both included. The algo a is only needed for Ease and Difficulty, and
+// can be nil.
+func Deck(h db.Handler, a algo.Algo, deckId string, from, to time.Time, cfg Config) (Stats, error) {
+	return DeckContext(context.Background(), h, a, deckId, from, to, cfg)
+}
+
+// DeckContext is Deck with a context.
+func DeckContext(ctx context.Context, h db.Handler, a algo.Algo, deckId string, from, to time.Time, cfg Config) (s Stats, err error) {
+	cfg = cfg.withDefaults()
+
+	s.DeckId = deckId
+
+	cards, err := h.CardsContext(ctx, deckId)
+	if err != nil {
+		return s, err
+	}
+
+	if err := s.cards(cards, a, cfg); err != nil {
+		return s, err
+	}
+
+	entries, err := h.DeckLogContext(ctx, deckId)
+	if err != nil {
+		return s, err
+	}
+
+	s.reviews(entries, from, to, cfg)
+	s.streaks()
+	s.heatmap()
+
+	return s, nil
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Location == nil {
+		cfg.Location = time.UTC
+	}
+
+	if cfg.Mature == 0 {
+		cfg.Mature = DefaultMature
+	}
+
+	if cfg.IntervalBins == nil {
+		cfg.IntervalBins = DefaultIntervalBins
+	}
+
+	return cfg
+}
+
+// cards computes the statistics of the current state of the cards
+func (s *Stats) cards(cards []db.Card, a algo.Algo, cfg Config) error {
+	s.Cards = map[review.State]int{}
+	s.Intervals = make([]int, len(cfg.IntervalBins)+1)
+
+	easer, _ := a.(algo.Easer)
+	difficulter, _ := a.(algo.Difficulter)
+
+	var reviewed int
+	var ease, difficulty float64
+
+	for _, c := range cards {
+		s.Cards[c.State]++
+
+		if c.Suspended {
+			s.Suspended++
+		}
+
+		if c.Lapses.Leech {
+			s.Leeches++
+		}
+
+		if c.State == review.StateNew {
+			continue
+		}
+
+		reviewed++
+		s.Intervals[bin(c.Interval, cfg.IntervalBins)]++
+
+		if easer != nil {
+			e, err := easer.Ease(c.Item)
+			if err != nil {
+				return err
+			}
+
+			ease += e
+		}
+
+		if difficulter != nil {
+			d, err := difficulter.Difficulty(c.Item)
+			if err != nil {
+				return err
+			}
+
+			difficulty += d
+		}
+	}
+
+	if reviewed > 0 {
+		s.Ease = ease / float64(reviewed)
+		s.Difficulty = difficulty / float64(reviewed)
+	}
+
+	return nil
+}
+
+// bin returns the index of the interval in the histogram
+func bin(interval time.Duration, bins []time.Duration) int {
+	for i, upper := range bins {
+		if interval <= upper {
+			return i
+		}
+	}
+
+	return len(bins)
+}
+
+// reviews computes the statistics of the log entries in the range. The
+// entries must be in review order.
+func (s *Stats) reviews(entries []db.LogEntry, from, to time.Time, cfg Config) {
+	first := startOfDay(from, cfg.Location)
+	last := startOfDay(to, cfg.Location)
+
+	index := map[int64]int{}
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		index[d.Unix()] = len(s.Days)
+		s.Days = append(s.Days, Day{Date: d})
+	}
+
+	// a card is new until its first review, also if it was before the range
+	reviewed := map[int]bool{}
+
+	for _, e := range entries {
+		if e.Quality == review.NoReview {
+			continue
+		}
+
+		isNew := !reviewed[e.CardId]
+		reviewed[e.CardId] = true
+
+		i, ok := index[startOfDay(e.ReviewedAt, cfg.Location).Unix()]
+		if !ok {
+			continue
+		}
+
+		d := &s.Days[i]
+		d.Reviews++
+		d.Time += e.Elapsed
+
+		if e.Quality.Correct() {
+			d.Correct++
+		}
+
+		if isNew {
+			d.New++
+		}
+
+		s.Reviews++
+
+		if e.PrevInterval >= cfg.Mature {
+			s.MatureReviews++
+
+			if e.Quality.Correct() {
+				s.MatureCorrect++
+			}
+		}
+	}
+
+	if s.MatureReviews > 0 {
+		s.Retention = float64(s.MatureCorrect) / float64(s.MatureReviews)
+	}
+}
+
+// streaks computes the streaks from the Days
+func (s *Stats) streaks() {
+	run := 0
+	for _, d := range s.Days {
+		if d.Reviews == 0 {
+			run = 0
+			continue
+		}
+
+		run++
+		if run > s.LongestStreak {
+			s.LongestStreak = run
+		}
+	}
+
+	days := s.Days
+	if len(days) > 0 && days[len(days)-1].Reviews == 0 {
+		days = days[:len(days)-1]
+	}
+
+	for i := len(days) - 1; i >= 0 && days[i].Reviews > 0; i-- {
+		s.CurrentStreak++
+	}
+}
+
+// heatmap builds the Heatmap from the Days
+func (s *Stats) heatmap() {
+	if len(s.Days) == 0 {
+		return
+	}
+
+	// days before the first day in its week
+	offset := (int(s.Days[0].Date.Weekday()) + 6) % 7
+
+	s.Heatmap = make([][7]int, (offset+len(s.Days)+6)/7)
+	for i, d := range s.Days {
+		cell := offset + i
+		s.Heatmap[cell/7][cell%7] = d.Reviews
+	}
+}
+
+// startOfDay returns the start of the calendar day of t in loc
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
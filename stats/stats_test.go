@@ -0,0 +1,188 @@
+package stats_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/revelaction/go-srs/algo/sm2"
+	"github.com/revelaction/go-srs/clock"
+	"github.com/revelaction/go-srs/db/memory"
+	"github.com/revelaction/go-srs/review"
+	"github.com/revelaction/go-srs/stats"
+)
+
+const deckId = "hi"
+
+// monday is the day of the Insert of the test deck
+var monday = time.Date(2020, time.November, 2, 10, 0, 0, 0, time.UTC)
+
+// newDeck returns a deck with 3 new cards, reviewed from Tuesday to Friday.
+// Thursday has no reviews.
+func newDeck(t *testing.T) (*memory.Handler, *sm2.Sm2) {
+	c := clock.NewFake(monday)
+	a := sm2.NewWithClock(c)
+	h := memory.NewWithClock(a, c)
+
+	r := review.Review{Items: []review.ReviewItem{{Quality: review.NoReview}, {Quality: review.NoReview}, {Quality: review.NoReview}}}
+	if _, err := h.Insert(r, deckId); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	reviews := []struct {
+		days  int
+		items []review.ReviewItem
+	}{
+		// card 1: interval 2 days. Card 2: interval 1 day
+		{days: 1, items: []review.ReviewItem{{CardId: 1, Quality: review.CorrectEasy}, {CardId: 2, Quality: review.IncorrectBlackout}}},
+		// card 2: interval 4 days
+		{days: 2, items: []review.ReviewItem{{CardId: 2, Quality: review.CorrectHard, Elapsed: 3 * time.Second}}},
+		// card 1: interval 6 days. Card 2: interval 1 day
+		{days: 4, items: []review.ReviewItem{{CardId: 1, Quality: review.CorrectEffort}, {CardId: 2, Quality: review.IncorrectFamiliar}}},
+	}
+
+	for _, rv := range reviews {
+		c.Set(monday.AddDate(0, 0, rv.days))
+
+		if _, err := h.Update(review.Review{DeckId: deckId, Items: rv.items}); err != nil {
+			t.Fatalf("got unexpected error %s", err)
+		}
+	}
+
+	if err := h.Suspend(deckId, []int{3}); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if _, err := h.MarkLeeches(deckId, []int{2}, 1); err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	return h, a
+}
+
+func TestDeck(t *testing.T) {
+	h, a := newDeck(t)
+
+	// Tuesday to Saturday
+	s, err := stats.Deck(h, a, deckId, monday.AddDate(0, 0, 1), monday.AddDate(0, 0, 5), stats.Config{Mature: 2 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	wantCards := map[review.State]int{review.StateNew: 1, review.StateReview: 1, review.StateRelearning: 1}
+	if fmt.Sprint(s.Cards) != fmt.Sprint(wantCards) {
+		t.Errorf("\ngot %v\nwant %v", s.Cards, wantCards)
+	}
+
+	if s.Suspended != 1 || s.Leeches != 1 {
+		t.Errorf("\ngot suspended %d, leeches %d\nwant 1, 1", s.Suspended, s.Leeches)
+	}
+
+	// card 1: 2.5 -> 3.6 -> 4.24. Card 2: 2.5 -> 1.7 -> 1.92 -> 1.42
+	if math.Abs(s.Ease-(4.24+1.42)/2) > 0.000001 {
+		t.Errorf("\ngot ease %f\nwant %f", s.Ease, (4.24+1.42)/2)
+	}
+
+	if s.Difficulty != 0 {
+		t.Errorf("\ngot difficulty %f\nwant 0", s.Difficulty)
+	}
+
+	// 1 day and 6 days
+	wantIntervals := []int{1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	if fmt.Sprint(s.Intervals) != fmt.Sprint(wantIntervals) {
+		t.Errorf("\ngot %v\nwant %v", s.Intervals, wantIntervals)
+	}
+
+	wantDays := []stats.Day{
+		{Date: time.Date(2020, time.November, 3, 0, 0, 0, 0, time.UTC), Reviews: 2, Correct: 1, New: 2},
+		{Date: time.Date(2020, time.November, 4, 0, 0, 0, 0, time.UTC), Reviews: 1, Correct: 1, Time: 3 * time.Second},
+		{Date: time.Date(2020, time.November, 5, 0, 0, 0, 0, time.UTC)},
+		{Date: time.Date(2020, time.November, 6, 0, 0, 0, 0, time.UTC), Reviews: 2, Correct: 1},
+		{Date: time.Date(2020, time.November, 7, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if fmt.Sprint(s.Days) != fmt.Sprint(wantDays) {
+		t.Errorf("\ngot %v\nwant %v", s.Days, wantDays)
+	}
+
+	if s.Reviews != 5 {
+		t.Errorf("\ngot reviews %d\nwant %d", s.Reviews, 5)
+	}
+
+	// the reviews of friday are mature
+	if s.MatureReviews != 2 || s.MatureCorrect != 1 || s.Retention != 0.5 {
+		t.Errorf("\ngot mature %d, correct %d, retention %f\nwant 2, 1, 0.5", s.MatureReviews, s.MatureCorrect, s.Retention)
+	}
+
+	// saturday is in progress
+	if s.CurrentStreak != 1 || s.LongestStreak != 2 {
+		t.Errorf("\ngot current streak %d, longest %d\nwant 1, 2", s.CurrentStreak, s.LongestStreak)
+	}
+
+	wantHeatmap := [][7]int{{0, 2, 1, 0, 2, 0, 0}}
+	if fmt.Sprint(s.Heatmap) != fmt.Sprint(wantHeatmap) {
+		t.Errorf("\ngot %v\nwant %v", s.Heatmap, wantHeatmap)
+	}
+}
+
+func TestDeckRange(t *testing.T) {
+	h, _ := newDeck(t)
+
+	// Wednesday to the next Tuesday, without algo
+	s, err := stats.Deck(h, nil, deckId, monday.AddDate(0, 0, 2), monday.AddDate(0, 0, 8), stats.Config{})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	if s.Ease != 0 {
+		t.Errorf("\ngot ease %f\nwant 0", s.Ease)
+	}
+
+	// the reviews of tuesday are before the range: card 2 is not new
+	if len(s.Days) != 7 || s.Days[0].New != 0 || s.Reviews != 3 {
+		t.Errorf("\ngot days %v\nwant 7 days, 3 reviews", s.Days)
+	}
+
+	// no mature reviews with the default Mature
+	if s.MatureReviews != 0 || s.Retention != 0 {
+		t.Errorf("\ngot mature %d, retention %f\nwant 0, 0", s.MatureReviews, s.Retention)
+	}
+
+	if s.CurrentStreak != 0 || s.LongestStreak != 1 {
+		t.Errorf("\ngot current streak %d, longest %d\nwant 0, 1", s.CurrentStreak, s.LongestStreak)
+	}
+
+	wantHeatmap := [][7]int{{0, 0, 1, 0, 2, 0, 0}, {0, 0, 0, 0, 0, 0, 0}}
+	if fmt.Sprint(s.Heatmap) != fmt.Sprint(wantHeatmap) {
+		t.Errorf("\ngot %v\nwant %v", s.Heatmap, wantHeatmap)
+	}
+}
+
+func TestDeckLocation(t *testing.T) {
+	h, a := newDeck(t)
+
+	// in UTC-11 the reviews at 10:00 UTC are the day before
+	loc := time.FixedZone("UTC-11", -11*60*60)
+
+	from := time.Date(2020, time.November, 2, 0, 0, 0, 0, loc)
+
+	s, err := stats.Deck(h, a, deckId, from, from.AddDate(0, 0, 3), stats.Config{Location: loc})
+	if err != nil {
+		t.Fatalf("got unexpected error %s", err)
+	}
+
+	want := []int{2, 1, 0, 2}
+	got := []int{}
+	for _, d := range s.Days {
+		got = append(got, d.Reviews)
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("\ngot %v\nwant %v", got, want)
+	}
+
+	if !s.Days[0].Date.Equal(from) {
+		t.Errorf("\ngot %v\nwant %v", s.Days[0].Date, from)
+	}
+}